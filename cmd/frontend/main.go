@@ -17,11 +17,14 @@
 package main
 
 import (
+	"coda-explorer/config"
 	"coda-explorer/db"
 	"coda-explorer/handlers"
+	"coda-explorer/metrics"
 	"coda-explorer/services"
 	"coda-explorer/util"
-	"flag"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
@@ -39,17 +42,13 @@ import (
 var logger = logrus.New().WithField("module", "main")
 
 func main() {
-	dbHost := flag.String("dbHost", "", "Database host")
-	dbPort := flag.String("dbPort", "", "Database port")
-	dbUser := flag.String("dbUser", "", "Database user")
-	dbPassword := flag.String("dbPassword", "", "Database password")
-	dbName := flag.String("dbName", "", "Database name")
+	cfg := config.MustLoad(
+		config.LegacyFlag{Name: "port", Key: "frontend.port"},
+		config.LegacyFlag{Name: "shutdownTimeout", Key: "frontend.shutdown_timeout"},
+	)
 
-	port := flag.Int("port", 3333, "Port to start the frontend http server on")
-
-	flag.Parse()
-
-	dbConn, err := sqlx.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", *dbUser, *dbPassword, *dbHost, *dbPort, *dbName))
+	dbConnString := cfg.Database.ConnString()
+	dbConn, err := sqlx.Open("postgres", dbConnString)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -68,27 +67,22 @@ func main() {
 
 	logger.Info("database connection established")
 
-	db.DB = dbConn
+	db.DB = db.NewConn(dbConn)
 	defer db.DB.Close()
 
-	router := mux.NewRouter()
-	router.HandleFunc("/", handlers.Index).Methods("GET")
-	router.HandleFunc("/index/data", handlers.IndexPageData).Methods("GET")
-	router.HandleFunc("/tx/{hash}", handlers.Tx).Methods("GET")
-	router.HandleFunc("/block/{hash}", handlers.Block).Methods("GET")
-	router.HandleFunc("/blocks", handlers.Blocks).Methods("GET")
-	router.HandleFunc("/blocks/data", handlers.BlocksData).Methods("GET")
-	router.HandleFunc("/account/{pk}", handlers.Account).Methods("GET")
-	router.HandleFunc("/account/{pk}/data_blocks", handlers.AccountBlocksData).Methods("GET")
-	router.HandleFunc("/account/{pk}/data_txs", handlers.AccountTxData).Methods("GET")
-	router.HandleFunc("/account/{pk}/data_snarkjobs", handlers.AccountSnarkJobsData).Methods("GET")
-	router.HandleFunc("/accounts", handlers.Accounts).Methods("GET")
-	router.HandleFunc("/accounts/data", handlers.AccountsData).Methods("GET")
-	router.HandleFunc("/charts", handlers.Charts).Methods("GET")
-	router.HandleFunc("/status", handlers.Status).Methods("GET")
-	router.HandleFunc("/search", handlers.Search).Methods("POST")
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		logger.Fatalf("error checking database schema: %v", err)
+	}
 
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("static")))
+	handlers.SetPageLimit(cfg.Frontend.PageLimit)
+
+	err = handlers.LoadTemplates()
+	if err != nil {
+		logger.Fatalf("error loading templates: %v", err)
+	}
+
+	router := newRouter()
 
 	n := negroni.New(negroni.NewRecovery())
 
@@ -112,10 +106,16 @@ func main() {
 
 	n.UseHandler(router)
 
-	services.Init()
+	ctx := util.ShutdownContext()
+
+	services.Init(ctx, dbConnString, cfg.Frontend.IP2LocationPath)
+
+	if cfg.Frontend.MetricsPort > 0 {
+		go metrics.Serve(ctx, cfg.Frontend.MetricsPort)
+	}
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf("0.0.0.0:%v", *port),
+		Addr:         fmt.Sprintf("0.0.0.0:%v", cfg.Frontend.Port),
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
@@ -124,10 +124,57 @@ func main() {
 
 	logger.Printf("http server listening on %v", srv.Addr)
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Println(err)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("error running http server: %v", err)
 		}
 	}()
 
-	util.WaitForCtrlC()
+	<-ctx.Done()
+
+	logger.Infof("shutting down http server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Frontend.ShutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Errorf("error shutting down http server: %v", err)
+	}
+}
+
+// Registers the handlers of all frontend routes, the OpenAPI document returned by handlers.OpenAPISpec has to
+// be updated when adding or changing json routes
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/", handlers.Index).Methods("GET")
+	router.HandleFunc("/index/data", handlers.IndexPageData).Methods("GET")
+	router.HandleFunc("/tx/{hash}", handlers.Tx).Methods("GET")
+	router.HandleFunc("/block/{hash}", handlers.Block).Methods("GET")
+	router.HandleFunc("/blocks", handlers.Blocks).Methods("GET")
+	router.HandleFunc("/blocks/data", handlers.BlocksData).Methods("GET")
+	router.HandleFunc("/account/{pk}", handlers.Account).Methods("GET")
+	router.HandleFunc("/account/{pk}/data_blocks", handlers.AccountBlocksData).Methods("GET")
+	router.HandleFunc("/account/{pk}/data_txs", handlers.AccountTxData).Methods("GET")
+	router.HandleFunc("/account/{pk}/data_snarkjobs", handlers.AccountSnarkJobsData).Methods("GET")
+	router.HandleFunc("/accounts", handlers.Accounts).Methods("GET")
+	router.HandleFunc("/accounts/data", handlers.AccountsData).Methods("GET")
+	router.HandleFunc("/charts", handlers.Charts).Methods("GET")
+	router.HandleFunc("/status", handlers.Status).Methods("GET")
+	router.HandleFunc("/mempool", handlers.Mempool).Methods("GET")
+	router.HandleFunc("/search", handlers.Search).Methods("POST")
+	router.HandleFunc("/openapi.json", handlers.OpenAPI).Methods("GET")
+	router.HandleFunc("/stream", handlers.Stream).Methods("GET")
+
+	apiV1Router := router.PathPrefix("/api/v1").Subrouter()
+	apiV1Router.HandleFunc("/blocks", handlers.APIBlocks).Methods("GET")
+	apiV1Router.HandleFunc("/blocks/{hashOrHeight}", handlers.APIBlock).Methods("GET")
+	apiV1Router.HandleFunc("/transactions/{id}", handlers.APITransaction).Methods("GET")
+	apiV1Router.HandleFunc("/accounts/{pk}", handlers.APIAccount).Methods("GET")
+	apiV1Router.HandleFunc("/accounts/{pk}/transactions", handlers.APIAccountTransactions).Methods("GET")
+	apiV1Router.HandleFunc("/accounts/{pk}/balances", handlers.APIAccountBalances).Methods("GET")
+	apiV1Router.HandleFunc("/statistics/{indicator}", handlers.APIStatistics).Methods("GET")
+
+	router.PathPrefix("/").Handler(http.FileServer(http.Dir("static")))
+
+	router.Use(handlers.HTTPMetrics)
+
+	return router
 }
@@ -17,11 +17,12 @@
 package main
 
 import (
+	"coda-explorer/config"
 	"coda-explorer/db"
 	"coda-explorer/indexer"
+	"coda-explorer/metrics"
+	"coda-explorer/rpc"
 	"coda-explorer/util"
-	"flag"
-	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"log"
@@ -33,18 +34,15 @@ import (
 var logger = logrus.New().WithField("module", "main")
 
 func main() {
-	dbHost := flag.String("dbHost", "", "Database host")
-	dbPort := flag.String("dbPort", "", "Database port")
-	dbUser := flag.String("dbUser", "", "Database user")
-	dbPassword := flag.String("dbPassword", "", "Database password")
-	dbName := flag.String("dbName", "", "Database name")
+	cfg := config.MustLoad(
+		config.LegacyFlag{Name: "coda", Key: "indexer.nodes"},
+		config.LegacyFlag{Name: "healthCheckInterval", Key: "indexer.health_check_interval"},
+		config.LegacyFlag{Name: "startupLookback", Key: "indexer.startup_lookback"},
+		config.LegacyFlag{Name: "backfill", Key: "indexer.backfill"},
+		config.LegacyFlag{Name: "shutdownTimeout", Key: "indexer.shutdown_timeout"},
+	)
 
-	codaEndpoint := flag.String("coda", "localhost:3085/graphql", "CODA node graphql endpoint")
-	startupLookback := flag.Int("startupLookback", 1000, "Check the last x blocks immediately after startup")
-
-	flag.Parse()
-
-	dbConn, err := sqlx.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", *dbUser, *dbPassword, *dbHost, *dbPort, *dbName))
+	dbConn, err := sqlx.Open("postgres", cfg.Database.ConnString())
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -63,11 +61,28 @@ func main() {
 
 	logger.Info("database connection established")
 
-	db.DB = dbConn
+	db.DB = db.NewConn(dbConn)
 	defer db.DB.Close()
 
-	indexer.Start(*codaEndpoint, *startupLookback)
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		logger.Fatalf("error checking database schema: %v", err)
+	}
+
+	ctx := util.ShutdownContext()
+	go func() {
+		<-ctx.Done()
+		time.Sleep(cfg.Indexer.ShutdownTimeout)
+		logger.Fatalf("indexer did not shut down within %v", cfg.Indexer.ShutdownTimeout)
+	}()
+
+	if cfg.Indexer.MetricsPort > 0 {
+		go metrics.Serve(ctx, cfg.Indexer.MetricsPort)
+	}
 
-	util.WaitForCtrlC()
+	pool := rpc.NewNodePool(cfg.Indexer.Nodes, db.SaveNodeDivergences)
+	pool.CheckHealth(ctx)
+	go pool.MonitorHealth(ctx, cfg.Indexer.HealthCheckInterval)
 
+	indexer.Start(ctx, pool, &cfg.Indexer)
 }
@@ -0,0 +1,105 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"coda-explorer/config"
+	"coda-explorer/db"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var logger = logrus.New().WithField("module", "main")
+
+// Helper application to manage the database schema, supports the up, down and status commands
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up|down|status\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	cfg := config.MustLoad()
+
+	command := flag.Arg(0)
+	if command != "up" && command != "down" && command != "status" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dbConn, err := sqlx.Open("postgres", cfg.Database.ConnString())
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// The golang postgres sql driver does not properly implement PingContext
+	// therefore we use a timer to catch db connection timeouts
+	dbConnectionTimeout := time.NewTimer(15 * time.Second)
+	go func() {
+		<-dbConnectionTimeout.C
+		log.Fatal("Timeout while connecting to the database")
+	}()
+	err = dbConn.Ping()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	dbConnectionTimeout.Stop()
+
+	logger.Info("database connection established")
+
+	db.DB = db.NewConn(dbConn)
+	defer db.DB.Close()
+
+	switch command {
+	case "up":
+		err = db.MigrateUp()
+		if err != nil {
+			logger.Fatalf("error applying migrations: %v", err)
+		}
+		logger.Infof("database schema is at version %v", db.LatestSchemaVersion())
+	case "down":
+		err = db.MigrateDown()
+		if err != nil {
+			logger.Fatalf("error reverting migration: %v", err)
+		}
+		version, err := db.SchemaVersion()
+		if err != nil {
+			logger.Fatalf("error retrieving schema version: %v", err)
+		}
+		logger.Infof("database schema is at version %v", version)
+	case "status":
+		status, err := db.GetMigrationStatus()
+		if err != nil {
+			logger.Fatalf("error retrieving migration status: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED")
+		for _, m := range status {
+			applied := "pending"
+			if !m.AppliedAt.IsZero() {
+				applied = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", m.Version, m.Description, applied)
+		}
+		w.Flush()
+	}
+}
@@ -17,9 +17,8 @@
 package main
 
 import (
+	"coda-explorer/config"
 	"coda-explorer/db"
-	"flag"
-	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"log"
@@ -30,15 +29,9 @@ var logger = logrus.New().WithField("module", "main")
 
 // Helper application to re-generate all statistics
 func main() {
-	dbHost := flag.String("dbHost", "", "Database host")
-	dbPort := flag.String("dbPort", "", "Database port")
-	dbUser := flag.String("dbUser", "", "Database user")
-	dbPassword := flag.String("dbPassword", "", "Database password")
-	dbName := flag.String("dbName", "", "Database name")
+	cfg := config.MustLoad()
 
-	flag.Parse()
-
-	dbConn, err := sqlx.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", *dbUser, *dbPassword, *dbHost, *dbPort, *dbName))
+	dbConn, err := sqlx.Open("postgres", cfg.Database.ConnString())
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -57,11 +50,11 @@ func main() {
 
 	logger.Info("database connection established")
 
-	db.DB = dbConn
+	db.DB = db.NewConn(dbConn)
 	defer db.DB.Close()
 
 	var startTime time.Time
-	err = db.DB.Get(&startTime, "SELECT MIN(ts) FROM blocks")
+	err = db.DB.Get("statistics.main", &startTime, "SELECT MIN(ts) FROM blocks")
 	if err != nil {
 		logger.Fatalf("error retrieving start time from blocks table: %v", err)
 	}
@@ -0,0 +1,313 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"coda-explorer/config"
+	"coda-explorer/db"
+	"coda-explorer/ledger"
+	"coda-explorer/types"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var logger = logrus.New().WithField("module", "main")
+
+// Number of blocks loaded from the database at once
+const batchSize = 1000
+
+// genesisLedger is the ledger section of a genesis configuration file, balances are given in coda
+type genesisLedger struct {
+	Ledger struct {
+		Accounts []struct {
+			PublicKey string `json:"pk"`
+			Balance   string `json:"balance"`
+			Delegate  string `json:"delegate"`
+		} `json:"accounts"`
+	} `json:"ledger"`
+}
+
+// divergence is a value of the replayed ledger that differs from the indexed data
+type divergence struct {
+	Subject  string
+	Field    string
+	Replayed string
+	Indexed  string
+	// First block whose state reported by the node differs from the replayed value, nil if unknown
+	FirstBlock *types.Block
+	// Last block whose state reported by the node matches the replayed value, nil if no reported state exists
+	LastMatch *types.Block
+}
+
+// replayResult holds the replayed divergences together with the last block at which the state reported by the node
+// matched the replayed state of each account
+type replayResult struct {
+	divergences []*divergence
+	lastMatches map[string]*types.Block
+}
+
+// Helper application that replays all canonical blocks from the genesis ledger and reports indexed account and
+// currency data that differs from the replayed ledger. Exits with status 1 if any divergence has been found.
+func main() {
+	genesisFile := flag.String("genesis", "", "Path to the genesis configuration file containing the initial ledger, all accounts start empty if not set")
+
+	cfg := config.MustLoad()
+
+	dbConn, err := sqlx.Open("postgres", cfg.Database.ConnString())
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// The golang postgres sql driver does not properly implement PingContext
+	// therefore we use a timer to catch db connection timeouts
+	dbConnectionTimeout := time.NewTimer(15 * time.Second)
+	go func() {
+		<-dbConnectionTimeout.C
+		log.Fatal("Timeout while connecting to the database")
+	}()
+	err = dbConn.Ping()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	dbConnectionTimeout.Stop()
+
+	logger.Info("database connection established")
+
+	db.DB = db.NewConn(dbConn)
+	defer db.DB.Close()
+
+	states := make(map[string]*types.AccountBalance)
+	if *genesisFile != "" {
+		states, err = loadGenesisLedger(*genesisFile)
+		if err != nil {
+			logger.Fatalf("error loading genesis ledger: %v", err)
+		}
+		logger.Infof("loaded %v genesis accounts", len(states))
+	}
+
+	gaps, err := db.GetHeightGaps()
+	if err != nil {
+		logger.Fatalf("error retrieving height gaps: %v", err)
+	}
+	for _, gap := range gaps {
+		logger.Warnf("blocks between height %v and %v are missing, the replayed ledger will be incomplete", gap.From, gap.To)
+	}
+
+	result, err := replay(states)
+	if err != nil {
+		logger.Fatalf("error replaying canonical blocks: %v", err)
+	}
+
+	accounts, err := db.GetAccounts()
+	if err != nil {
+		logger.Fatalf("error retrieving accounts: %v", err)
+	}
+	divergences := append(result.divergences, compareAccounts(states, accounts, result)...)
+
+	printReport(divergences)
+	if len(divergences) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Loads the initial account states from the ledger section of a genesis configuration file
+func loadGenesisLedger(path string) (map[string]*types.AccountBalance, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading genesis file %v: %w", path, err)
+	}
+
+	genesis := &genesisLedger{}
+	err = json.Unmarshal(data, genesis)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing genesis file %v: %w", path, err)
+	}
+
+	states := make(map[string]*types.AccountBalance, len(genesis.Ledger.Accounts))
+	for _, a := range genesis.Ledger.Accounts {
+		balance, err := types.ParseCoda(a.Balance)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing balance of genesis account %v: %w", a.PublicKey, err)
+		}
+		delegate := a.Delegate
+		if delegate == "" {
+			delegate = a.PublicKey
+		}
+		states[a.PublicKey] = &types.AccountBalance{PublicKey: a.PublicKey, Balance: balance, Delegate: delegate}
+	}
+	return states, nil
+}
+
+// Replays all canonical blocks ordered by height on top of the given account states. Returns the first divergence of
+// every account from its per block state reported by the node as well as the first block whose total currency does not
+// match the genesis currency plus all coinbases. States derived by the indexer are skipped, as they have been derived
+// with the same ledger rules and would diverge whenever the replayed ledger does.
+func replay(states map[string]*types.AccountBalance) (*replayResult, error) {
+	currency := types.Amount{}
+	for _, s := range states {
+		currency = currency.Add(s.Balance)
+	}
+
+	var divergences []*divergence
+	diverged := make(map[string]bool)
+	lastMatches := make(map[string]*types.Block)
+	currencyDiverged := false
+
+	state := func(pk string) *types.AccountBalance {
+		if states[pk] == nil {
+			states[pk] = &types.AccountBalance{PublicKey: pk, Delegate: pk}
+		}
+		return states[pk]
+	}
+
+	height := -1
+	replayed := 0
+	for {
+		blocks, err := db.GetCanonicalBlocksWithTransactions(height, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+
+		for _, block := range blocks {
+			ledger.ApplyBlock(block, state)
+			currency = currency.Add(block.Coinbase)
+
+			if !currencyDiverged && currency.Cmp(block.TotalCurrency) != 0 {
+				currencyDiverged = true
+				divergences = append(divergences, &divergence{Subject: "total currency", Field: "totalcurrency", Replayed: currency.String(), Indexed: block.TotalCurrency.String(), FirstBlock: block})
+			}
+
+			for _, recorded := range block.AccountBalances {
+				if recorded.Derived || diverged[recorded.PublicKey] {
+					continue
+				}
+				blockDivergences := compareState(state(recorded.PublicKey), recorded.Balance, recorded.Nonce, recorded.Delegate)
+				if len(blockDivergences) == 0 {
+					lastMatches[recorded.PublicKey] = block
+					continue
+				}
+				for _, d := range blockDivergences {
+					d.FirstBlock = block
+					d.LastMatch = lastMatches[recorded.PublicKey]
+					divergences = append(divergences, d)
+				}
+				diverged[recorded.PublicKey] = true
+			}
+			height = block.Height
+		}
+
+		replayed += len(blocks)
+		logger.Infof("replayed %v blocks up to height %v", replayed, height)
+	}
+
+	return &replayResult{divergences: divergences, lastMatches: lastMatches}, nil
+}
+
+// Compares the replayed ledger with the accounts table. Divergences of accounts that already diverged from their
+// reported per block state are reported with the block of the first divergence, otherwise with the last block whose
+// reported state matched.
+func compareAccounts(states map[string]*types.AccountBalance, accounts []*types.Account, result *replayResult) []*divergence {
+	firstBlocks := make(map[string]*types.Block)
+	for _, d := range result.divergences {
+		firstBlocks[d.Subject] = d.FirstBlock
+	}
+
+	var divergences []*divergence
+	indexed := make(map[string]bool, len(accounts))
+	for _, a := range accounts {
+		indexed[a.PublicKey] = true
+
+		s := states[a.PublicKey]
+		if s == nil {
+			s = &types.AccountBalance{PublicKey: a.PublicKey}
+		}
+		for _, d := range compareState(s, a.Balance, a.Nonce, a.Delegate) {
+			d.FirstBlock = firstBlocks[a.PublicKey]
+			d.LastMatch = result.lastMatches[a.PublicKey]
+			divergences = append(divergences, d)
+		}
+	}
+
+	for pk, s := range states {
+		if !indexed[pk] && (s.Balance.Sign() != 0 || s.Nonce != 0) {
+			divergences = append(divergences, &divergence{Subject: pk, Field: "account", Replayed: "present", Indexed: "missing", FirstBlock: firstBlocks[pk], LastMatch: result.lastMatches[pk]})
+		}
+	}
+
+	return divergences
+}
+
+// Returns a divergence for every field of the replayed state that differs from the indexed values
+func compareState(replayed *types.AccountBalance, balance types.Amount, nonce int, delegate string) []*divergence {
+	var divergences []*divergence
+	if replayed.Balance.Cmp(balance) != 0 {
+		divergences = append(divergences, &divergence{Subject: replayed.PublicKey, Field: "balance", Replayed: replayed.Balance.String(), Indexed: balance.String()})
+	}
+	if replayed.Nonce != nonce {
+		divergences = append(divergences, &divergence{Subject: replayed.PublicKey, Field: "nonce", Replayed: strconv.Itoa(replayed.Nonce), Indexed: strconv.Itoa(nonce)})
+	}
+	if replayed.Delegate != delegate {
+		divergences = append(divergences, &divergence{Subject: replayed.PublicKey, Field: "delegate", Replayed: replayed.Delegate, Indexed: delegate})
+	}
+	return divergences
+}
+
+func printReport(divergences []*divergence) {
+	if len(divergences) == 0 {
+		fmt.Println("the indexed data is consistent with the replayed ledger")
+		return
+	}
+
+	sort.SliceStable(divergences, func(i, j int) bool {
+		return divergences[i].Subject < divergences[j].Subject
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tFIELD\tREPLAYED\tINDEXED\tFIRST DIVERGED AT")
+	for _, d := range divergences {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", d.Subject, d.Field, d.Replayed, d.Indexed, firstDiverged(d))
+	}
+	w.Flush()
+
+	fmt.Printf("found %v divergences\n", len(divergences))
+}
+
+// Describes the block at which a divergence first occurred. The block is only known if a state reported by the node
+// diverges, as the node reports the state at its best tip only and the indexer records it for few blocks.
+func firstDiverged(d *divergence) string {
+	if d.FirstBlock != nil {
+		return fmt.Sprintf("%v (%v)", d.FirstBlock.Height, d.FirstBlock.StateHash)
+	}
+	if d.LastMatch != nil {
+		return fmt.Sprintf("after %v (%v), the last block with a matching state reported by the node", d.LastMatch.Height, d.LastMatch.StateHash)
+	}
+	return "unknown, no state reported by the node has been recorded for the account"
+}
@@ -0,0 +1,497 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.New().WithField("module", "config")
+
+// EnvPrefix is the prefix of the environment variables overriding configuration values, the variable of a value is
+// named after its key in upper case with dots replaced by underscores, e.g. CODA_EXPLORER_DATABASE_PASSWORD
+const EnvPrefix = "CODA_EXPLORER_"
+
+// Config holds the configuration shared by all binaries
+type Config struct {
+	Database DatabaseConfig `config:"database"`
+	Indexer  IndexerConfig  `config:"indexer"`
+	Frontend FrontendConfig `config:"frontend"`
+}
+
+// DatabaseConfig holds the connection settings of the PostgreSQL database
+type DatabaseConfig struct {
+	Host     string `config:"host"`
+	Port     int    `config:"port"`
+	User     string `config:"user"`
+	Password string `config:"password" secret:"true"`
+	Name     string `config:"name"`
+	SSLMode  string `config:"ssl_mode"`
+}
+
+// IndexerConfig holds the settings of the indexer
+type IndexerConfig struct {
+	// Graphql endpoints of the coda nodes, requests are routed to the best synced node
+	Nodes               []string      `config:"nodes"`
+	HealthCheckInterval time.Duration `config:"health_check_interval"`
+	// Number of blocks checked immediately after startup
+	StartupLookback int `config:"startup_lookback"`
+	// Number of blocks checked periodically and on chain reorganizations
+	CheckLookback        int           `config:"check_lookback"`
+	CheckInterval        time.Duration `config:"check_interval"`
+	DaemonStatusInterval time.Duration `config:"daemon_status_interval"`
+	MempoolInterval      time.Duration `config:"mempool_interval"`
+	// Retrieve all blocks missing from the database down to the genesis block
+	Backfill           bool          `config:"backfill"`
+	BackfillInterval   time.Duration `config:"backfill_interval"`
+	StatisticsInterval time.Duration `config:"statistics_interval"`
+	ShutdownTimeout    time.Duration `config:"shutdown_timeout"`
+	// Port of the http server serving the prometheus metrics on /metrics, 0 disables the server
+	MetricsPort int `config:"metrics_port"`
+}
+
+// FrontendConfig holds the settings of the frontend
+type FrontendConfig struct {
+	Port            int    `config:"port"`
+	IP2LocationPath string `config:"ip2location_path"`
+	// Maximum number of rows returned by paginated routes
+	PageLimit       int           `config:"page_limit"`
+	ShutdownTimeout time.Duration `config:"shutdown_timeout"`
+	// Port of the http server serving the prometheus metrics on /metrics, 0 disables the server
+	MetricsPort int `config:"metrics_port"`
+}
+
+// Default returns the configuration used for all values that are neither set in the configuration file nor by an
+// environment variable
+func Default() *Config {
+	return &Config{
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		Indexer: IndexerConfig{
+			Nodes:                []string{"localhost:3085/graphql"},
+			HealthCheckInterval:  time.Second * 30,
+			StartupLookback:      1000,
+			CheckLookback:        10,
+			CheckInterval:        time.Minute,
+			DaemonStatusInterval: time.Minute * 10,
+			MempoolInterval:      time.Second * 10,
+			BackfillInterval:     time.Minute * 10,
+			StatisticsInterval:   time.Hour,
+			ShutdownTimeout:      time.Minute,
+			MetricsPort:          9090,
+		},
+		Frontend: FrontendConfig{
+			Port:            3333,
+			IP2LocationPath: "ip2location/IP2LOCATION-LITE-DB5.BIN",
+			PageLimit:       100,
+			ShutdownTimeout: time.Second * 15,
+			MetricsPort:     9091,
+		},
+	}
+}
+
+// ConnString returns the PostgreSQL connection string of the database
+func (c *DatabaseConfig) ConnString() string {
+	u := &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%v:%v", c.Host, c.Port),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// Load returns the default configuration overridden by the values of the given YAML (.yaml, .yml) or TOML (.toml)
+// file and the CODA_EXPLORER_* environment variables, the file is optional. The configuration is not validated.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration file %v: %w", path, err)
+		}
+
+		var values map[string]*rawValue
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			values, err = parseYAML(string(data))
+		case ".toml":
+			values, err = parseTOML(string(data))
+		default:
+			err = fmt.Errorf("unsupported file extension, expected .yaml, .yml or .toml")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing configuration file %v: %w", path, err)
+		}
+
+		for _, key := range sortedKeys(values) {
+			err = cfg.set(key, values[key])
+			if err != nil {
+				return nil, fmt.Errorf("error in configuration file %v: %w", path, err)
+			}
+		}
+	}
+
+	for _, key := range cfg.keys() {
+		env := envName(key)
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		err := cfg.setString(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("error in environment variable %v: %w", env, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Validate checks all configuration values and returns an error listing every invalid value
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, key, problem string) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%v %v", key, problem))
+		}
+	}
+
+	check(c.Database.Host != "", "database.host", "must be set")
+	check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port", "must be between 1 and 65535")
+	check(c.Database.User != "", "database.user", "must be set")
+	check(c.Database.Name != "", "database.name", "must be set")
+	check(c.Database.SSLMode != "", "database.ssl_mode", "must be set")
+
+	check(len(c.Indexer.Nodes) > 0, "indexer.nodes", "must contain at least one graphql endpoint")
+	check(c.Indexer.HealthCheckInterval > 0, "indexer.health_check_interval", "must be positive")
+	check(c.Indexer.StartupLookback > 0, "indexer.startup_lookback", "must be positive")
+	check(c.Indexer.CheckLookback > 0, "indexer.check_lookback", "must be positive")
+	check(c.Indexer.CheckInterval > 0, "indexer.check_interval", "must be positive")
+	check(c.Indexer.DaemonStatusInterval > 0, "indexer.daemon_status_interval", "must be positive")
+	check(c.Indexer.MempoolInterval > 0, "indexer.mempool_interval", "must be positive")
+	check(c.Indexer.BackfillInterval > 0, "indexer.backfill_interval", "must be positive")
+	check(c.Indexer.StatisticsInterval > 0, "indexer.statistics_interval", "must be positive")
+	check(c.Indexer.ShutdownTimeout > 0, "indexer.shutdown_timeout", "must be positive")
+	check(c.Indexer.MetricsPort >= 0 && c.Indexer.MetricsPort < 65536, "indexer.metrics_port", "must be between 0 and 65535")
+
+	check(c.Frontend.Port > 0 && c.Frontend.Port < 65536, "frontend.port", "must be between 1 and 65535")
+	check(c.Frontend.IP2LocationPath != "", "frontend.ip2location_path", "must be set")
+	check(c.Frontend.PageLimit > 0, "frontend.page_limit", "must be positive")
+	check(c.Frontend.ShutdownTimeout > 0, "frontend.shutdown_timeout", "must be positive")
+	check(c.Frontend.MetricsPort >= 0 && c.Frontend.MetricsPort < 65536, "frontend.metrics_port", "must be between 0 and 65535")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %v", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Print writes the configuration in the YAML format accepted by Load, secrets are masked
+func (c *Config) Print(w io.Writer) error {
+	section := ""
+	for _, f := range c.fields() {
+		key := strings.SplitN(f.key, ".", 2)
+		if key[0] != section {
+			section = key[0]
+			_, err := fmt.Fprintf(w, "%v:\n", section)
+			if err != nil {
+				return err
+			}
+		}
+
+		value := formatValue(f.value)
+		if f.secret && value != `""` {
+			value = `"********"`
+		}
+		_, err := fmt.Fprintf(w, "  %v: %v\n", key[1], value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the name of the environment variable overriding the value of the given key
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// LegacyFlag is a command line flag of earlier versions that is still accepted, it overrides the configuration
+// value of the given key
+type LegacyFlag struct {
+	Name string
+	Key  string
+}
+
+// Legacy flags accepted by all binaries
+var databaseFlags = []LegacyFlag{
+	{Name: "dbHost", Key: "database.host"},
+	{Name: "dbPort", Key: "database.port"},
+	{Name: "dbUser", Key: "database.user"},
+	{Name: "dbPassword", Key: "database.password"},
+	{Name: "dbName", Key: "database.name"},
+}
+
+// MustLoad registers the -config, -print-config and legacy flags, parses the command line and returns the validated
+// configuration loaded from the file given by -config and the environment, overridden by the legacy flags given on
+// the command line. The -dbHost, -dbPort, -dbUser, -dbPassword and -dbName flags are accepted by all binaries.
+// Binaries specific flags have to be defined before. Exits the process if the configuration is invalid, or after
+// printing it if -print-config is set.
+func MustLoad(legacyFlags ...LegacyFlag) *Config {
+	path := flag.String("config", "", "Path to a YAML or TOML configuration file, all values can be overridden by "+EnvPrefix+"* environment variables")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration and exit")
+	overrides := registerLegacyFlags(flag.CommandLine, append(databaseFlags, legacyFlags...))
+
+	flag.Parse()
+
+	cfg, err := Load(*path)
+	if err != nil {
+		logger.Fatalf("error loading configuration: %v", err)
+	}
+	err = applyLegacyFlags(cfg, overrides)
+	if err != nil {
+		logger.Fatalf("error loading configuration: %v", err)
+	}
+
+	if *printConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			logger.Fatalf("error printing configuration: %v", err)
+		}
+		err = cfg.Validate()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return cfg
+}
+
+// legacyFlagValue holds the value of a legacy flag given on the command line
+type legacyFlagValue struct {
+	name   string
+	key    string
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *legacyFlagValue) String() string {
+	return v.value
+}
+
+func (v *legacyFlagValue) Set(s string) error {
+	v.value = s
+	v.set = true
+	return nil
+}
+
+func (v *legacyFlagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Defines the legacy flags on the flag set, the returned values are applied by applyLegacyFlags after parsing
+func registerLegacyFlags(fs *flag.FlagSet, flags []LegacyFlag) []*legacyFlagValue {
+	kinds := make(map[string]reflect.Kind)
+	for _, f := range Default().fields() {
+		kinds[f.key] = f.value.Kind()
+	}
+
+	values := make([]*legacyFlagValue, len(flags))
+	for i, f := range flags {
+		values[i] = &legacyFlagValue{name: f.Name, key: f.Key, isBool: kinds[f.Key] == reflect.Bool}
+		fs.Var(values[i], f.Name, fmt.Sprintf("Deprecated, overrides %v", f.Key))
+	}
+	return values
+}
+
+// Sets the values of the legacy flags given on the command line, they take precedence over the configuration file
+// and the environment variables
+func applyLegacyFlags(cfg *Config, values []*legacyFlagValue) error {
+	for _, v := range values {
+		if !v.set {
+			continue
+		}
+		logger.Warnf("flag -%v is deprecated, set %v in the configuration file or %v instead", v.name, v.key, envName(v.key))
+		err := cfg.setString(v.key, v.value)
+		if err != nil {
+			return fmt.Errorf("error in flag -%v: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+type field struct {
+	key    string
+	value  reflect.Value
+	secret bool
+}
+
+// Returns all configuration values in declaration order keyed by section and name, e.g. database.host
+func (c *Config) fields() []*field {
+	var fields []*field
+
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		sectionName := root.Type().Field(i).Tag.Get("config")
+		for j := 0; j < section.NumField(); j++ {
+			t := section.Type().Field(j)
+			fields = append(fields, &field{
+				key:    sectionName + "." + t.Tag.Get("config"),
+				value:  section.Field(j),
+				secret: t.Tag.Get("secret") == "true",
+			})
+		}
+	}
+	return fields
+}
+
+func (c *Config) keys() []string {
+	var keys []string
+	for _, f := range c.fields() {
+		keys = append(keys, f.key)
+	}
+	return keys
+}
+
+// Sets the value of the given key, a scalar given for a list is its only item
+func (c *Config) set(key string, value *rawValue) error {
+	for _, f := range c.fields() {
+		if f.key != key {
+			continue
+		}
+
+		err := parseValue(f.value, value)
+		if err != nil {
+			return fmt.Errorf("invalid value %v for %v: %w", value, key, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown key %v", key)
+}
+
+// Sets the value of the given key from an environment variable or command line flag, lists are given as comma
+// separated values
+func (c *Config) setString(key, s string) error {
+	for _, f := range c.fields() {
+		if f.key == key && f.value.Kind() == reflect.Slice {
+			return c.set(key, listValue(strings.Split(s, ",")))
+		}
+	}
+	return c.set(key, scalarValue(s))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func parseValue(v reflect.Value, value *rawValue) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		items := []string{value.scalar}
+		if value.list {
+			items = value.items
+		}
+		var nonEmpty []string
+		for _, item := range items {
+			if item = strings.TrimSpace(item); item != "" {
+				nonEmpty = append(nonEmpty, item)
+			}
+		}
+		v.Set(reflect.ValueOf(nonEmpty))
+		return nil
+	}
+	if value.list {
+		return errors.New("not a single value")
+	}
+
+	s := value.scalar
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		v.SetInt(int64(i))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	case v.Kind() == reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = strconv.Quote(v.Index(i).String())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// Returns the keys of the given values in sorted order
+func sortedKeys(values map[string]*rawValue) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,219 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a configuration file with the given name to a temporary directory, the caller has to remove the directory
+func writeConfigFile(t *testing.T, name, data string) (string, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("error writing configuration file: %v", err)
+	}
+	return dir, path
+}
+
+// Sets the given environment variables and returns a function restoring the previous environment
+func setEnv(t *testing.T, env map[string]string) func() {
+	t.Helper()
+
+	previous := make(map[string]*string)
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	const yamlConfig = `
+database:
+  host: db
+  password: "secret"
+indexer:
+  nodes:
+    - a:3085/graphql
+    - "b,c:3085/graphql"
+  backfill: true
+  check_interval: 2m
+frontend:
+`
+	const tomlConfig = `
+[database]
+host = "db"
+password = "secret"
+
+[indexer]
+nodes = ["a:3085/graphql", "b,c:3085/graphql"]
+backfill = true
+check_interval = "2m"
+
+[frontend]
+`
+	fromFile := Default()
+	fromFile.Database.Host = "db"
+	fromFile.Database.Password = "secret"
+	fromFile.Indexer.Nodes = []string{"a:3085/graphql", "b,c:3085/graphql"}
+	fromFile.Indexer.Backfill = true
+	fromFile.Indexer.CheckInterval = time.Minute * 2
+
+	withEnv := *fromFile
+	withEnv.Database.Password = "from-env"
+	withEnv.Database.Port = 6543
+	withEnv.Indexer.Nodes = []string{"x:3085/graphql", "y:3085/graphql"}
+
+	tests := []struct {
+		name     string
+		file     string
+		data     string
+		env      map[string]string
+		expected *Config
+		err      string
+	}{
+		{name: "yaml", file: "config.yaml", data: yamlConfig, expected: fromFile},
+		{name: "yml", file: "config.yml", data: yamlConfig, expected: fromFile},
+		{name: "toml", file: "config.toml", data: tomlConfig, expected: fromFile},
+		{
+			name: "environment overrides file",
+			file: "config.yaml",
+			data: yamlConfig,
+			env: map[string]string{
+				"CODA_EXPLORER_DATABASE_PASSWORD": "from-env",
+				"CODA_EXPLORER_DATABASE_PORT":     "6543",
+				"CODA_EXPLORER_INDEXER_NODES":     "x:3085/graphql, y:3085/graphql,",
+			},
+			expected: &withEnv,
+		},
+		{name: "defaults", expected: Default()},
+		{name: "scalar for list", file: "config.yaml", data: "indexer:\n  nodes: a:3085/graphql\n", expected: func() *Config {
+			cfg := Default()
+			cfg.Indexer.Nodes = []string{"a:3085/graphql"}
+			return cfg
+		}()},
+		{name: "unknown key", file: "config.yaml", data: "database:\n  hots: db\n", err: "unknown key database.hots"},
+		{name: "unknown section", file: "config.toml", data: "[db]\nhost = \"db\"\n", err: "unknown key db.host"},
+		{name: "invalid integer", file: "config.yaml", data: "database:\n  port: high\n", err: `invalid value "high" for database.port: not an integer`},
+		{name: "list for scalar", file: "config.yaml", data: "database:\n  host: [a, b]\n", err: `invalid value ["a", "b"] for database.host: not a single value`},
+		{name: "invalid environment variable", env: map[string]string{"CODA_EXPLORER_INDEXER_BACKFILL": "sometimes"}, err: "error in environment variable CODA_EXPLORER_INDEXER_BACKFILL"},
+		{name: "unsupported extension", file: "config.json", data: "{}", err: "unsupported file extension"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				var dir string
+				dir, path = writeConfigFile(t, tt.file, tt.data)
+				defer os.RemoveAll(dir)
+			}
+			defer setEnv(t, tt.env)()
+
+			cfg, err := Load(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error loading configuration: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, cfg)
+			}
+		})
+	}
+}
+
+func TestLegacyFlags(t *testing.T) {
+	dir, path := writeConfigFile(t, "config.yaml", "database:\n  host: from-file\n  user: from-file\n  name: from-file\n")
+	defer os.RemoveAll(dir)
+	defer setEnv(t, map[string]string{
+		"CODA_EXPLORER_DATABASE_USER": "from-env",
+		"CODA_EXPLORER_DATABASE_NAME": "from-env",
+	})()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides := registerLegacyFlags(fs, append(databaseFlags,
+		LegacyFlag{Name: "coda", Key: "indexer.nodes"},
+		LegacyFlag{Name: "backfill", Key: "indexer.backfill"},
+	))
+	err := fs.Parse([]string{"-dbName", "from-flag", "-dbPort", "6543", "-coda", "a:3085/graphql,b:3085/graphql", "-backfill"})
+	if err != nil {
+		t.Fatalf("error parsing flags: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("error loading configuration: %v", err)
+	}
+	err = applyLegacyFlags(cfg, overrides)
+	if err != nil {
+		t.Fatalf("error applying flags: %v", err)
+	}
+
+	expected := Default()
+	expected.Database.Host = "from-file"
+	expected.Database.User = "from-env"
+	expected.Database.Name = "from-flag"
+	expected.Database.Port = 6543
+	expected.Indexer.Nodes = []string{"a:3085/graphql", "b:3085/graphql"}
+	expected.Indexer.Backfill = true
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides = registerLegacyFlags(fs, databaseFlags)
+	err = fs.Parse([]string{"-dbPort", "high"})
+	if err != nil {
+		t.Fatalf("error parsing flags: %v", err)
+	}
+	err = applyLegacyFlags(Default(), overrides)
+	if err == nil || !strings.Contains(err.Error(), "error in flag -dbPort") {
+		t.Errorf("expected error for an invalid flag value, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rawValue is a configuration value before it is converted to the type of its field, either a scalar or a list
+type rawValue struct {
+	scalar string
+	items  []string
+	list   bool
+}
+
+func scalarValue(s string) *rawValue {
+	return &rawValue{scalar: s}
+}
+
+func listValue(items []string) *rawValue {
+	return &rawValue{items: items, list: true}
+}
+
+func (v *rawValue) String() string {
+	if !v.list {
+		return strconv.Quote(v.scalar)
+	}
+	items := make([]string, len(v.items))
+	for i, item := range v.items {
+		items[i] = strconv.Quote(item)
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// Parses the subset of YAML used by configuration files: nested mappings, scalars and lists of scalars given either
+// as block sequences or in flow style. Returns the values keyed by their dot separated path. Keys without a value
+// and without nested keys or list items are null and omitted, so that empty sections are allowed.
+func parseYAML(data string) (map[string]*rawValue, error) {
+	type openKey struct {
+		indent int
+		key    string
+	}
+
+	values := make(map[string]*rawValue)
+	lists := make(map[string][]string)
+	// Keys opened without a value, followed by nested keys or list items
+	opened := make(map[string]bool)
+	var stack []openKey
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %v: tabs are not allowed for indentation", i+1)
+		}
+		indent := len(line) - len(trimmed)
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			for len(stack) > 0 && stack[len(stack)-1].indent > indent {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %v: list item without key", i+1)
+			}
+			key := stack[len(stack)-1].key
+			item, err := parseScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %w", i+1, err)
+			}
+			lists[key] = append(lists[key], item)
+			continue
+		}
+
+		colon := strings.Index(trimmed, ":")
+		if colon < 1 || (colon+1 < len(trimmed) && trimmed[colon+1] != ' ') {
+			return nil, fmt.Errorf("line %v: expected key: value", i+1)
+		}
+		name := strings.TrimSpace(trimmed[:colon])
+		value := strings.TrimSpace(trimmed[colon+1:])
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		key := name
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1].key
+			if lists[parent] != nil {
+				return nil, fmt.Errorf("line %v: %v is a list", i+1, parent)
+			}
+			key = parent + "." + name
+		}
+		if _, exists := values[key]; exists || opened[key] || lists[key] != nil {
+			return nil, fmt.Errorf("line %v: duplicate key %v", i+1, key)
+		}
+
+		if value == "" {
+			stack = append(stack, openKey{indent: indent, key: key})
+			opened[key] = true
+			continue
+		}
+
+		var err error
+		values[key], err = parseRaw(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", i+1, err)
+		}
+	}
+
+	for key, items := range lists {
+		values[key] = listValue(items)
+	}
+	return values, nil
+}
+
+// Parses the subset of TOML used by configuration files: tables, key/value pairs, strings, numbers, booleans and
+// single line arrays. Returns the values keyed by their dot separated path.
+func parseTOML(data string) (map[string]*rawValue, error) {
+	values := make(map[string]*rawValue)
+	table := ""
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %v: invalid table header", i+1)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 1 {
+			return nil, fmt.Errorf("line %v: expected key = value", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("line %v: duplicate key %v", i+1, key)
+		}
+
+		var err error
+		values[key], err = parseRaw(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", i+1, err)
+		}
+	}
+	return values, nil
+}
+
+// Parses a scalar or a flow style list
+func parseRaw(s string) (*rawValue, error) {
+	if strings.HasPrefix(s, "[") {
+		items, err := parseList(s)
+		if err != nil {
+			return nil, err
+		}
+		return listValue(items), nil
+	}
+	scalar, err := parseScalar(s)
+	if err != nil {
+		return nil, err
+	}
+	return scalarValue(scalar), nil
+}
+
+// Removes a trailing comment started by # outside of a quoted string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Returns the value of a plain, single quoted or double quoted scalar
+func parseScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %v", s)
+		}
+		return value, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid quoted string %v", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	default:
+		return s, nil
+	}
+}
+
+// Returns the items of a [a, b] list
+func parseList(s string) ([]string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated list %v", s)
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+
+	items := []string{}
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			if quote != 0 {
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '"' || c == '\'' {
+				quote = c
+				continue
+			}
+			if c != ',' {
+				continue
+			}
+		}
+
+		item := strings.TrimSpace(inner[start:i])
+		start = i + 1
+		if item == "" {
+			// Trailing comma
+			continue
+		}
+		value, err := parseScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
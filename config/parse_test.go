@@ -0,0 +1,149 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected map[string]*rawValue
+		err      string
+	}{
+		{
+			name: "nested mappings and scalars",
+			data: "# comment\ndatabase:\n  host: db # comment\n  port: 5432\n  password: \"p#ss \\\"word\\\"\"\n  name: 'it''s'\n",
+			expected: map[string]*rawValue{
+				"database.host":     scalarValue("db"),
+				"database.port":     scalarValue("5432"),
+				"database.password": scalarValue(`p#ss "word"`),
+				"database.name":     scalarValue("it's"),
+			},
+		},
+		{
+			name: "block sequence",
+			data: "indexer:\n  nodes:\n    - a:3085/graphql\n    - \"b,c:3085/graphql\"\n  backfill: true\n",
+			expected: map[string]*rawValue{
+				"indexer.nodes":    listValue([]string{"a:3085/graphql", "b,c:3085/graphql"}),
+				"indexer.backfill": scalarValue("true"),
+			},
+		},
+		{
+			name: "flow sequence",
+			data: "indexer:\n  nodes: [a:3085/graphql, \"b,c:3085/graphql\", 'd',]\n",
+			expected: map[string]*rawValue{
+				"indexer.nodes": listValue([]string{"a:3085/graphql", "b,c:3085/graphql", "d"}),
+			},
+		},
+		{
+			name: "empty flow sequence",
+			data: "indexer:\n  nodes: []\n",
+			expected: map[string]*rawValue{
+				"indexer.nodes": listValue([]string{}),
+			},
+		},
+		{
+			name: "empty sections",
+			data: "database:\nindexer:\n  nodes:\nfrontend:\n  port: 80\n",
+			expected: map[string]*rawValue{
+				"frontend.port": scalarValue("80"),
+			},
+		},
+		{
+			name:     "empty document",
+			data:     "\n# only a comment\n",
+			expected: map[string]*rawValue{},
+		},
+		{name: "tab indentation", data: "database:\n\thost: db\n", err: "line 2: tabs are not allowed"},
+		{name: "list item without key", data: "- a\n", err: "line 1: list item without key"},
+		{name: "missing space after colon", data: "database:\n  host:db\n", err: "line 2: expected key: value"},
+		{name: "missing colon", data: "database\n", err: "line 1: expected key: value"},
+		{name: "duplicate key", data: "database:\n  host: a\n  host: b\n", err: "line 3: duplicate key database.host"},
+		{name: "duplicate section", data: "database:\n  host: a\ndatabase:\n  port: 1\n", err: "line 3: duplicate key database"},
+		{name: "key below list", data: "indexer:\n  nodes:\n    - a\n    host: b\n", err: "line 4: indexer.nodes is a list"},
+		{name: "unterminated list", data: "indexer:\n  nodes: [a, b\n", err: "line 2: unterminated list"},
+		{name: "invalid quoted string", data: "database:\n  host: \"db\n", err: "line 2: invalid quoted string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseYAML(tt.data)
+			checkParsed(t, values, err, tt.expected, tt.err)
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected map[string]*rawValue
+		err      string
+	}{
+		{
+			name: "tables",
+			data: "# comment\n[database]\nhost = \"db\" # comment\nport = 5432\n\n[indexer]\nbackfill = true\nnodes = [\"a:3085/graphql\", \"b,c:3085/graphql\"]\n",
+			expected: map[string]*rawValue{
+				"database.host":    scalarValue("db"),
+				"database.port":    scalarValue("5432"),
+				"indexer.backfill": scalarValue("true"),
+				"indexer.nodes":    listValue([]string{"a:3085/graphql", "b,c:3085/graphql"}),
+			},
+		},
+		{
+			name: "empty tables and arrays",
+			data: "[database]\n[indexer]\nnodes = []\n[frontend]\n",
+			expected: map[string]*rawValue{
+				"indexer.nodes": listValue([]string{}),
+			},
+		},
+		{name: "invalid table header", data: "[database\n", err: "line 1: invalid table header"},
+		{name: "array of tables", data: "[[database]]\n", err: "line 1: invalid table header"},
+		{name: "missing equals sign", data: "[database]\nhost\n", err: "line 2: expected key = value"},
+		{name: "duplicate key", data: "[database]\nhost = \"a\"\nhost = \"b\"\n", err: "line 3: duplicate key database.host"},
+		{name: "unterminated array", data: "[indexer]\nnodes = [\"a\"\n", err: "line 2: unterminated list"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseTOML(tt.data)
+			checkParsed(t, values, err, tt.expected, tt.err)
+		})
+	}
+}
+
+func checkParsed(t *testing.T, values map[string]*rawValue, err error, expected map[string]*rawValue, expectedErr string) {
+	t.Helper()
+
+	if expectedErr != "" {
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("expected error containing %q, got %v", expectedErr, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Maximum number of bind parameters of a single postgres statement
+const maxBindParameters = 65535
+
+// Builds the placeholders of a multi-row VALUES clause, e.g. ($1, $2), ($3, $4) for two rows with two columns
+func valuesPlaceholders(rows, columns int) string {
+	placeholders := &strings.Builder{}
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			placeholders.WriteString(", ")
+		}
+		placeholders.WriteString("(")
+		for c := 0; c < columns; c++ {
+			if c > 0 {
+				placeholders.WriteString(", ")
+			}
+			fmt.Fprintf(placeholders, "$%d", r*columns+c+1)
+		}
+		placeholders.WriteString(")")
+	}
+	return placeholders.String()
+}
+
+// Returns the maximum number of rows with the given number of columns that can be written by a single statement
+func rowsPerStatement(columns int) int {
+	return maxBindParameters / columns
+}
+
+// Inserts rows into a table using multi-row INSERT statements. Each row must contain a value for every column, the
+// conflict clause (e.g. ON CONFLICT DO NOTHING) is appended to every statement.
+func bulkInsert(tx *Tx, table string, columns []string, rows [][]interface{}, conflict string) error {
+	batchSize := rowsPerStatement(len(columns))
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s", table, strings.Join(columns, ", "), valuesPlaceholders(end-start, len(columns)), conflict)
+		_, err := tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("error inserting %v rows into %v: %w", end-start, table, err)
+		}
+	}
+	return nil
+}
@@ -17,9 +17,12 @@
 package db
 
 import (
+	"coda-explorer/metrics"
 	"coda-explorer/types"
+	"database/sql"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 	"time"
 )
@@ -27,62 +30,121 @@ import (
 var logger = logrus.New().WithField("module", "db")
 
 // DB holds the current DB connection
-var DB *sqlx.DB
+var DB *Conn
+
+var queryDuration = metrics.NewHistogramVec("coda_db_query_duration_seconds", "Duration of database queries and transactions by operation", metrics.DurationBuckets, "operation")
+
+// Conn is a database connection observing the duration of its queries and transactions. Every query and transaction
+// is labeled with the name of the operation issuing it, usually the name of the calling function.
+type Conn struct {
+	*sqlx.DB
+}
+
+// NewConn wraps a database connection
+func NewConn(db *sqlx.DB) *Conn {
+	return &Conn{DB: db}
+}
+
+// Get executes a query returning a single row and scans it into dest
+func (c *Conn) Get(op string, dest interface{}, query string, args ...interface{}) error {
+	defer queryDuration.ObserveSince(time.Now(), op)
+	return c.DB.Get(dest, query, args...)
+}
+
+// Select executes a query and scans all rows into dest
+func (c *Conn) Select(op string, dest interface{}, query string, args ...interface{}) error {
+	defer queryDuration.ObserveSince(time.Now(), op)
+	return c.DB.Select(dest, query, args...)
+}
+
+// Exec executes a query without returning any rows
+func (c *Conn) Exec(op string, query string, args ...interface{}) (sql.Result, error) {
+	defer queryDuration.ObserveSince(time.Now(), op)
+	return c.DB.Exec(query, args...)
+}
+
+// NamedExec executes a query with named parameters bound to arg without returning any rows
+func (c *Conn) NamedExec(op string, query string, arg interface{}) (sql.Result, error) {
+	defer queryDuration.ObserveSince(time.Now(), op)
+	return c.DB.NamedExec(query, arg)
+}
+
+// Beginx starts a transaction, the time until it is committed or rolled back is observed as a whole
+func (c *Conn) Beginx(op string) (*Tx, error) {
+	start := time.Now()
+	tx, err := c.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, op: op, start: start}, nil
+}
+
+// Tx is a transaction started by Conn.Beginx
+type Tx struct {
+	*sqlx.Tx
+	op    string
+	start time.Time
+	done  bool
+}
+
+// Commit commits the transaction
+func (tx *Tx) Commit() error {
+	defer tx.observe()
+	return tx.Tx.Commit()
+}
+
+// Rollback aborts the transaction, after a commit it only returns sql.ErrTxDone
+func (tx *Tx) Rollback() error {
+	defer tx.observe()
+	return tx.Tx.Rollback()
+}
+
+func (tx *Tx) observe() {
+	if !tx.done {
+		tx.done = true
+		queryDuration.ObserveSince(tx.start, tx.op)
+	}
+}
 
 // BlockExists checks if a block is already present in the database
 func BlockExists(stateHash string) (bool, error) {
 	var stateHashDb string
-	err := DB.Get(&stateHashDb, "SELECT statehash FROM blocks WHERE statehash = $1", stateHash)
+	err := DB.Get("BlockExists", &stateHashDb, "SELECT statehash FROM blocks WHERE statehash = $1", stateHash)
 	return err == nil && stateHashDb == stateHash, err
 }
 
 // SaveAccount saves or updates an account in the database
 func SaveAccount(account *types.Account) error {
-	tx, err := DB.Beginx()
+	return SaveAccounts([]*types.Account{account})
+}
+
+// SaveAccounts saves or updates multiple accounts within a single db transaction using multi-row upserts.
+// The public keys of the accounts must be unique.
+func SaveAccounts(accounts []*types.Account) error {
+	tx, err := DB.Beginx("SaveAccounts")
 
 	if err != nil {
 		return fmt.Errorf("error starting db tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	_, err = tx.NamedExec(`INSERT INTO accounts (
-								publickey,
-								balance,
-								nonce,
-								receiptchainhash,
-								delegate,
-								votingfor,
-								txsent,
-								txreceived,
-								blocksproposed,
-								snarkjobs,
-								firstseen,
-								lastseen
-							) VALUES (
-								:publickey,
-								:balance,
-								:nonce,
-								:receiptchainhash,
-								:delegate,
-								:votingfor,
-								:txsent,
-								:txreceived,
-								:blocksproposed,
-								:snarkjobs,
-								:firstseen,
-								:lastseen
-							) ON CONFLICT (publickey) DO UPDATE SET 
+	rows := make([][]interface{}, len(accounts))
+	for i, a := range accounts {
+		rows[i] = []interface{}{a.PublicKey, a.Balance, a.Nonce, a.ReceiptChainHash, a.Delegate, a.VotingFor, a.TxSent, a.TxReceived, a.BlocksProposed, a.SnarkJobs, a.FirstSeen, a.LastSeen}
+	}
+
+	err = bulkInsert(tx, "accounts",
+		[]string{"publickey", "balance", "nonce", "receiptchainhash", "delegate", "votingfor", "txsent", "txreceived", "blocksproposed", "snarkjobs", "firstseen", "lastseen"},
+		rows, `ON CONFLICT (publickey) DO UPDATE SET 
 								balance = EXCLUDED.balance, 
 								nonce = EXCLUDED.nonce,
 								receiptchainhash = EXCLUDED.receiptchainhash,
 								delegate = EXCLUDED.delegate,
 								votingfor = EXCLUDED.votingfor,
 								firstseen = LEAST(EXCLUDED.firstseen, accounts.firstseen),
-								lastseen = GREATEST(EXCLUDED.lastseen, accounts.lastseen)
-                             `, account)
-
+								lastseen = GREATEST(EXCLUDED.lastseen, accounts.lastseen)`)
 	if err != nil {
-		return fmt.Errorf("error saving account %v db tx: %w", account.PublicKey, err)
+		return fmt.Errorf("error saving accounts db tx: %w", err)
 	}
 
 	err = tx.Commit()
@@ -98,7 +160,7 @@ func SaveBlock(block *types.Block) error {
 		return fmt.Errorf("error block %v has already been indexed", block.StateHash)
 	}
 
-	tx, err := DB.Beginx()
+	tx, err := DB.Beginx("SaveBlock")
 
 	if err != nil {
 		return fmt.Errorf("error starting db tx: %w", err)
@@ -144,38 +206,15 @@ func SaveBlock(block *types.Block) error {
 		return fmt.Errorf("error executing block insert db query: %w", err)
 	}
 
-	logger.Infof("saving snark job data")
-	for _, sj := range block.SnarkJobs {
-		_, err = tx.NamedExec(`INSERT INTO snarkjobs (blockstatehash, canonical, index, jobids, prover, fee) VALUES (:blockstatehash, :canonical, :index, :jobids, :prover, :fee) ON CONFLICT DO NOTHING`, sj)
-		if err != nil {
-			return fmt.Errorf("error executing snark job insert db query: %w", err)
-		}
-	}
-
-	logger.Infof("saving fee transfers data")
-	for _, ft := range block.FeeTransfers {
-		_, err := tx.NamedExec(`INSERT INTO feetransfers (blockstatehash, canonical, index, recipient, fee) VALUES (:blockstatehash, :canonical, :index, :recipient, :fee) ON CONFLICT DO NOTHING `, ft)
-		if err != nil {
-			return fmt.Errorf("error executing fee transfer insert db query: %w", err)
-		}
+	err = saveBlockChildren(tx, block)
+	if err != nil {
+		return err
 	}
 
-	logger.Infof("saving user jobs data")
-	for _, uj := range block.UserJobs {
-		_, err := tx.NamedExec(`INSERT INTO userjobs (blockstatehash, canonical, index, id, sender, recipient, memo, fee, amount, nonce, delegation) VALUES (:blockstatehash, :canonical, :index, :id, :sender, :recipient, :memo, :fee, :amount, :nonce, :delegation) ON CONFLICT DO NOTHING`, uj)
-		if err != nil {
-			return fmt.Errorf("error executing userjobs insert db query: %w", err)
-		}
-
-		_, err = tx.Exec("INSERT INTO accounttransactions (publickey, blockstatehash, canonical, id, ts) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;", uj.Sender, block.StateHash, false, uj.ID, block.Ts)
-		if err != nil {
-			return fmt.Errorf("error executing accounttransactions userjob sender insert db query: %w", err)
-		}
-
-		_, err = tx.Exec("INSERT INTO accounttransactions (publickey, blockstatehash, canonical, id, ts) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;", uj.Recipient, block.StateHash, false, uj.ID, block.Ts)
-		if err != nil {
-			return fmt.Errorf("error executing accounttransactions userjob recipient insert db query: %w", err)
-		}
+	// A block that has previously been quarantined passed validation this time
+	_, err = tx.Exec("DELETE FROM quarantined_blocks WHERE statehash = $1", block.StateHash)
+	if err != nil {
+		return fmt.Errorf("error deleting block from the quarantined blocks table: %w", err)
 	}
 
 	logger.Infof("updating proposed blocks statistics table")
@@ -184,23 +223,95 @@ func SaveBlock(block *types.Block) error {
 		return fmt.Errorf("error incrementing blocksproposed column of accounts table: %w", err)
 	}
 
+	err = notify(tx, &types.Notification{Type: types.NotificationBlockSaved, StateHash: block.StateHash, Height: block.Height})
+	if err != nil {
+		return err
+	}
+
 	logger.Infof("committing tx")
 
 	err = tx.Commit()
 	return err
 }
 
+// Saves the snark jobs, fee transfers, user jobs, account transactions and account balances of a block using one
+// multi-row statement per table
+func saveBlockChildren(tx *Tx, block *types.Block) error {
+	logger.Infof("saving snark job data")
+	rows := make([][]interface{}, len(block.SnarkJobs))
+	for i, sj := range block.SnarkJobs {
+		rows[i] = []interface{}{sj.BlockStateHash, sj.Canonical, sj.Index, sj.Jobids, sj.Prover, sj.Fee}
+	}
+	err := bulkInsert(tx, "snarkjobs", []string{"blockstatehash", "canonical", "index", "jobids", "prover", "fee"}, rows, "ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("error executing snark job insert db query: %w", err)
+	}
+
+	logger.Infof("saving fee transfers data")
+	rows = make([][]interface{}, len(block.FeeTransfers))
+	for i, ft := range block.FeeTransfers {
+		rows[i] = []interface{}{ft.BlockStateHash, ft.Canonical, ft.Index, ft.Recipient, ft.Fee}
+	}
+	err = bulkInsert(tx, "feetransfers", []string{"blockstatehash", "canonical", "index", "recipient", "fee"}, rows, "ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("error executing fee transfer insert db query: %w", err)
+	}
+
+	logger.Infof("saving user jobs data")
+	rows = make([][]interface{}, len(block.UserJobs))
+	accountTxRows := make([][]interface{}, 0, len(block.UserJobs)*2)
+	for i, uj := range block.UserJobs {
+		rows[i] = []interface{}{uj.BlockStateHash, uj.Canonical, uj.Index, uj.ID, uj.Sender, uj.Recipient, uj.Memo, uj.Fee, uj.Amount, uj.Nonce, uj.Delegation}
+		accountTxRows = append(accountTxRows,
+			[]interface{}{uj.Sender, block.StateHash, false, uj.ID, block.Ts},
+			[]interface{}{uj.Recipient, block.StateHash, false, uj.ID, block.Ts})
+	}
+	err = bulkInsert(tx, "userjobs", []string{"blockstatehash", "canonical", "index", "id", "sender", "recipient", "memo", "fee", "amount", "nonce", "delegation"}, rows, "ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("error executing userjobs insert db query: %w", err)
+	}
+	err = bulkInsert(tx, "accounttransactions", []string{"publickey", "blockstatehash", "canonical", "id", "ts"}, accountTxRows, "ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("error executing accounttransactions insert db query: %w", err)
+	}
+
+	logger.Infof("saving account balances data")
+	rows = make([][]interface{}, len(block.AccountBalances))
+	for i, ab := range block.AccountBalances {
+		ab.Canonical = block.Canonical
+		rows[i] = []interface{}{ab.PublicKey, ab.BlockStateHash, ab.Height, ab.Canonical, ab.Balance, ab.Nonce, ab.Ts, ab.Delegate, ab.Derived}
+	}
+	err = bulkInsert(tx, "account_balances", []string{"publickey", "blockstatehash", "height", "canonical", "balance", "nonce", "ts", "delegate", "derived"}, rows, "ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("error executing account_balances insert db query: %w", err)
+	}
+
+	return nil
+}
+
 // MarkBlockCanonical marks a block as canonical in the database, also updates relevant statistics
 func MarkBlockCanonical(block *types.Block) error {
-	tx, err := DB.Beginx()
+	tx, err := DB.Beginx("MarkBlockCanonical")
 
 	if err != nil {
 		return fmt.Errorf("error starting db tx: %w", err)
 	}
 	defer tx.Rollback()
 
+	err = markBlockCanonical(tx, block)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("committing tx")
+
+	err = tx.Commit()
+	return err
+}
+
+func markBlockCanonical(tx *Tx, block *types.Block) error {
 	var canonical bool
-	err = tx.Get(&canonical, "SELECT canonical FROM blocks WHERE statehash = $1", block.StateHash)
+	err := tx.Get(&canonical, "SELECT canonical FROM blocks WHERE statehash = $1", block.StateHash)
 	if err != nil {
 		return fmt.Errorf("error retrieving canonical status from db: %w", err)
 	}
@@ -240,6 +351,12 @@ func MarkBlockCanonical(block *types.Block) error {
 		return fmt.Errorf("error executing block accounttransactions canonical update db query: %w", err)
 	}
 
+	_, err = tx.Exec(`UPDATE account_balances SET canonical = true WHERE blockstatehash = $1`, block.StateHash)
+
+	if err != nil {
+		return fmt.Errorf("error executing block account_balances canonical update db query: %w", err)
+	}
+
 	logger.Infof("updating snark jobs statistics")
 	for _, sj := range block.SnarkJobs {
 		_, err := tx.Exec("UPDATE accounts SET snarkjobs = snarkjobs + 1 WHERE publickey = $1", sj.Prover)
@@ -261,23 +378,63 @@ func MarkBlockCanonical(block *types.Block) error {
 		}
 	}
 
+	return notify(tx, &types.Notification{Type: types.NotificationBlockCanonical, StateHash: block.StateHash, Height: block.Height})
+}
+
+// MarkBlockOrphaned marks a block as orphaned in the database, also updates relevant statistics
+func MarkBlockOrphaned(block *types.Block) error {
+	tx, err := DB.Beginx("MarkBlockOrphaned")
+
+	if err != nil {
+		return fmt.Errorf("error starting db tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = markBlockOrphaned(tx, block)
+	if err != nil {
+		return err
+	}
+
 	logger.Infof("committing tx")
 
 	err = tx.Commit()
 	return err
 }
 
-// MarkBlockOrphaned marks a block as orphaned in the database, also updates relevant statistics
-func MarkBlockOrphaned(block *types.Block) error {
-	tx, err := DB.Beginx()
+// UpdateCanonicalChain marks a set of blocks as orphaned and another set of blocks as canonical within a single db transaction
+func UpdateCanonicalChain(orphaned, canonical []*types.Block) error {
+	tx, err := DB.Beginx("UpdateCanonicalChain")
 
 	if err != nil {
 		return fmt.Errorf("error starting db tx: %w", err)
 	}
 	defer tx.Rollback()
 
+	for _, block := range orphaned {
+		logger.Infof("marking block %v at height %v as orphaned", block.StateHash, block.Height)
+		err = markBlockOrphaned(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, block := range canonical {
+		logger.Infof("marking block %v at height %v as canonical", block.StateHash, block.Height)
+		err = markBlockCanonical(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("committing tx")
+
+	err = tx.Commit()
+	return err
+}
+
+func markBlockOrphaned(tx *Tx, block *types.Block) error {
 	var canonical bool
-	err = tx.Get(&canonical, "SELECT canonical FROM blocks WHERE statehash = $1", block.StateHash)
+	err := tx.Get(&canonical, "SELECT canonical FROM blocks WHERE statehash = $1", block.StateHash)
 	if err != nil {
 		return fmt.Errorf("error retrieving canonical status from db: %w", err)
 	}
@@ -317,6 +474,12 @@ func MarkBlockOrphaned(block *types.Block) error {
 		return fmt.Errorf("error executing block accounttransactions canonical update db query: %w", err)
 	}
 
+	_, err = tx.Exec(`UPDATE account_balances SET canonical = false WHERE blockstatehash = $1`, block.StateHash)
+
+	if err != nil {
+		return fmt.Errorf("error executing block account_balances canonical update db query: %w", err)
+	}
+
 	logger.Infof("updating snark jobs statistics")
 	for _, sj := range block.SnarkJobs {
 		_, err := tx.Exec("UPDATE accounts SET snarkjobs = snarkjobs - 1 WHERE publickey = $1", sj.Prover)
@@ -338,15 +501,12 @@ func MarkBlockOrphaned(block *types.Block) error {
 		}
 	}
 
-	logger.Infof("committing tx")
-
-	err = tx.Commit()
-	return err
+	return notify(tx, &types.Notification{Type: types.NotificationBlockOrphaned, StateHash: block.StateHash, Height: block.Height})
 }
 
 // RollbackBlock removes a block from the database, rolling back all mutations to the account counters
 func RollbackBlock(block *types.Block) error {
-	tx, err := DB.Begin()
+	tx, err := DB.Beginx("RollbackBlock")
 
 	if err != nil {
 		return fmt.Errorf("error starting db tx: %w", err)
@@ -412,7 +572,7 @@ func RollbackBlock(block *types.Block) error {
 // GetBlockByHeight retrieves a block from the database by its canonical height
 func GetBlockByHeight(height int) (*types.Block, error) {
 	var stateHash string
-	err := DB.Get(&stateHash, "SELECT statehash FROM blocks WHERE height = $1 AND canonical", height)
+	err := DB.Get("GetBlockByHeight", &stateHash, "SELECT statehash FROM blocks WHERE height = $1 AND canonical", height)
 
 	if err != nil {
 		return nil, fmt.Errorf("error block at height %v not found: %w", height, err)
@@ -424,7 +584,7 @@ func GetBlockByHeight(height int) (*types.Block, error) {
 // GetLastBlockHashes retrieves a set of blocks from the database by their canonical height
 func GetLastBlockHashes(lookback int) ([]*types.BlockHashNumber, error) {
 	var hashes []*types.BlockHashNumber
-	err := DB.Select(&hashes, "SELECT statehash, canonical, previousstatehash, height FROM blocks ORDER BY height DESC limit $1", lookback)
+	err := DB.Select("GetLastBlockHashes", &hashes, "SELECT statehash, canonical, previousstatehash, height FROM blocks ORDER BY height DESC limit $1", lookback)
 
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving last block hashes: %w", err)
@@ -433,6 +593,105 @@ func GetLastBlockHashes(lookback int) ([]*types.BlockHashNumber, error) {
 	return hashes, nil
 }
 
+// GetCanonicalBlockHashesAboveHeight retrieves the hashes of all blocks currently marked as canonical above the given height
+func GetCanonicalBlockHashesAboveHeight(height int) ([]*types.BlockHashNumber, error) {
+	var hashes []*types.BlockHashNumber
+	err := DB.Select("GetCanonicalBlockHashesAboveHeight", &hashes, "SELECT statehash, canonical, previousstatehash, height FROM blocks WHERE height > $1 AND canonical ORDER BY height DESC", height)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving canonical block hashes above height %v: %w", height, err)
+	}
+
+	return hashes, nil
+}
+
+// GetBlockHashNumber retrieves the hash, parent hash, height and canonical status of a block, returns nil if the block is not present
+func GetBlockHashNumber(hash string) (*types.BlockHashNumber, error) {
+	var hashes []*types.BlockHashNumber
+	err := DB.Select("GetBlockHashNumber", &hashes, "SELECT statehash, canonical, previousstatehash, height FROM blocks WHERE statehash = $1", hash)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving block hash %v: %w", hash, err)
+	}
+
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	return hashes[0], nil
+}
+
+// GetBlocksWithMissingParent retrieves all canonical blocks above the genesis block whose parent block is not present in
+// the database. Orphaned blocks are excluded as the node usually does not know their ancestors anymore.
+func GetBlocksWithMissingParent() ([]*types.BlockHashNumber, error) {
+	var blocks []*types.BlockHashNumber
+	err := DB.Select("GetBlocksWithMissingParent", &blocks, `SELECT statehash, canonical, previousstatehash, height 
+										FROM blocks 
+										WHERE height > 1 AND canonical AND NOT EXISTS (SELECT 1 FROM blocks parent WHERE parent.statehash = blocks.previousstatehash) 
+										ORDER BY height DESC`)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving blocks with missing parent: %w", err)
+	}
+
+	return blocks, nil
+}
+
+// GetHeightGaps retrieves all ranges of heights between the genesis block and the highest block that have no block in the database
+func GetHeightGaps() ([]*types.HeightGap, error) {
+	var gaps []*types.HeightGap
+	err := DB.Select("GetHeightGaps", &gaps, `SELECT previous + 1 AS fromheight, height - 1 AS toheight FROM (
+										SELECT height, LAG(height, 1, 0) OVER (ORDER BY height) AS previous FROM (SELECT DISTINCT height FROM blocks) AS heights
+									) AS a WHERE height - previous > 1 ORDER BY height`)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving height gaps: %w", err)
+	}
+
+	return gaps, nil
+}
+
+// GetBackfillCheckpoint retrieves the persisted backfill checkpoint, returns nil if no backfill is in progress
+func GetBackfillCheckpoint() (*types.BackfillCheckpoint, error) {
+	var checkpoints []*types.BackfillCheckpoint
+	err := DB.Select("GetBackfillCheckpoint", &checkpoints, "SELECT statehash, canonical, updated FROM backfillcheckpoint WHERE id = 1")
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving backfill checkpoint: %w", err)
+	}
+
+	if len(checkpoints) == 0 {
+		return nil, nil
+	}
+	return checkpoints[0], nil
+}
+
+// SaveBackfillCheckpoint persists the state hash of the next block the backfill process has to retrieve
+func SaveBackfillCheckpoint(checkpoint *types.BackfillCheckpoint) error {
+	_, err := DB.NamedExec("SaveBackfillCheckpoint", `INSERT INTO backfillcheckpoint (id, statehash, canonical, updated) 
+									VALUES (1, :statehash, :canonical, :updated) 
+									ON CONFLICT (id) DO UPDATE SET 
+										statehash = EXCLUDED.statehash, 
+										canonical = EXCLUDED.canonical, 
+										updated = EXCLUDED.updated`, checkpoint)
+
+	if err != nil {
+		return fmt.Errorf("error saving backfill checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBackfillCheckpoint removes the persisted backfill checkpoint
+func DeleteBackfillCheckpoint() error {
+	_, err := DB.Exec("DeleteBackfillCheckpoint", "DELETE FROM backfillcheckpoint WHERE id = 1")
+
+	if err != nil {
+		return fmt.Errorf("error deleting backfill checkpoint: %w", err)
+	}
+
+	return nil
+}
+
 // GetBlockByHash retrieves a block from the database by its canonical state hash
 func GetBlockByHash(hash string) (*types.Block, error) {
 	block := &types.Block{
@@ -441,28 +700,28 @@ func GetBlockByHash(hash string) (*types.Block, error) {
 		UserJobs:     []*types.UserJob{},
 	}
 
-	err := DB.Get(block, "SELECT * FROM blocks WHERE statehash = $1", hash)
+	err := DB.Get("GetBlockByHash", block, "SELECT * FROM blocks WHERE statehash = $1", hash)
 
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving data for block %v from the database: %w", hash, err)
 	}
 
 	if block.SnarkJobsCount > 0 {
-		err = DB.Select(&block.SnarkJobs, "SELECT * FROM snarkjobs WHERE blockstatehash = $1 ORDER BY index", hash)
+		err = DB.Select("GetBlockByHash", &block.SnarkJobs, "SELECT * FROM snarkjobs WHERE blockstatehash = $1 ORDER BY index", hash)
 		if err != nil {
 			return nil, fmt.Errorf("error retrieving snark job data for block %v from the database: %w", hash, err)
 		}
 	}
 
 	if block.FeeTransferCount > 0 {
-		err = DB.Select(&block.FeeTransfers, "SELECT * FROM feetransfers WHERE blockstatehash = $1 ORDER BY index", hash)
+		err = DB.Select("GetBlockByHash", &block.FeeTransfers, "SELECT * FROM feetransfers WHERE blockstatehash = $1 ORDER BY index", hash)
 		if err != nil {
 			return nil, fmt.Errorf("error retrieving fee transfer data for block %v from the database: %w", hash, err)
 		}
 	}
 
 	if block.UserCommandsCount > 0 {
-		err = DB.Select(&block.UserJobs, "SELECT * FROM userjobs WHERE blockstatehash = $1 ORDER BY index", hash)
+		err = DB.Select("GetBlockByHash", &block.UserJobs, "SELECT * FROM userjobs WHERE blockstatehash = $1 ORDER BY index", hash)
 		if err != nil {
 			return nil, fmt.Errorf("error retrieving user jobs data for block %v from the database: %w", hash, err)
 		}
@@ -471,9 +730,234 @@ func GetBlockByHash(hash string) (*types.Block, error) {
 	return block, nil
 }
 
+// GetBlocks retrieves all blocks within an inclusive height range ordered by height and state hash descending.
+// Only blocks sorting below the given height and state hash are returned to allow for keyset pagination.
+func GetBlocks(fromHeight, toHeight, beforeHeight int64, beforeHash string, limit int) ([]*types.Block, error) {
+	var blocks []*types.Block
+	// The bounds are compared as bigint as they are not limited to the int range of the height column
+	err := DB.Select("GetBlocks", &blocks, `SELECT * 
+										FROM blocks 
+										WHERE height >= $1::bigint AND height <= $2::bigint AND (height, statehash) < ($3::bigint, $4) 
+										ORDER BY height DESC, statehash DESC LIMIT $5`, fromHeight, toHeight, beforeHeight, beforeHash, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving blocks between height %v and %v: %w", fromHeight, toHeight, err)
+	}
+
+	return blocks, nil
+}
+
+// GetCanonicalBlocksWithTransactions retrieves canonical blocks above the given height ordered by height ascending,
+// including their fee transfers, user jobs and recorded account balances
+func GetCanonicalBlocksWithTransactions(aboveHeight, limit int) ([]*types.Block, error) {
+	var blocks []*types.Block
+	err := DB.Select("GetCanonicalBlocksWithTransactions", &blocks, "SELECT * FROM blocks WHERE canonical AND height > $1 ORDER BY height LIMIT $2", aboveHeight, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving canonical blocks above height %v from the database: %w", aboveHeight, err)
+	}
+	if len(blocks) == 0 {
+		return blocks, nil
+	}
+
+	hashes := make(pq.StringArray, len(blocks))
+	blocksByHash := make(map[string]*types.Block, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = b.StateHash
+		blocksByHash[b.StateHash] = b
+	}
+
+	var feeTransfers []*types.FeeTransfer
+	err = DB.Select("GetCanonicalBlocksWithTransactions", &feeTransfers, "SELECT * FROM feetransfers WHERE blockstatehash = ANY($1) ORDER BY blockstatehash, index", hashes)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving fee transfer data above height %v from the database: %w", aboveHeight, err)
+	}
+	for _, ft := range feeTransfers {
+		b := blocksByHash[ft.BlockStateHash]
+		b.FeeTransfers = append(b.FeeTransfers, ft)
+	}
+
+	var userJobs []*types.UserJob
+	err = DB.Select("GetCanonicalBlocksWithTransactions", &userJobs, "SELECT * FROM userjobs WHERE blockstatehash = ANY($1) ORDER BY blockstatehash, index", hashes)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving user jobs data above height %v from the database: %w", aboveHeight, err)
+	}
+	for _, uj := range userJobs {
+		b := blocksByHash[uj.BlockStateHash]
+		b.UserJobs = append(b.UserJobs, uj)
+	}
+
+	var balances []*types.AccountBalance
+	err = DB.Select("GetCanonicalBlocksWithTransactions", &balances, "SELECT * FROM account_balances WHERE blockstatehash = ANY($1)", hashes)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving account balances above height %v from the database: %w", aboveHeight, err)
+	}
+	for _, ab := range balances {
+		b := blocksByHash[ab.BlockStateHash]
+		b.AccountBalances = append(b.AccountBalances, ab)
+	}
+
+	return blocks, nil
+}
+
+// GetAccounts retrieves all accounts
+func GetAccounts() ([]*types.Account, error) {
+	var accounts []*types.Account
+	err := DB.Select("GetAccounts", &accounts, "SELECT * FROM accounts")
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving accounts from the database: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// GetTransaction retrieves a user job by its id, preferring the canonical block if the job has been included in multiple blocks
+func GetTransaction(id string) (*types.TxPageData, error) {
+	tx := &types.TxPageData{}
+	err := DB.Get("GetTransaction", tx, `SELECT userjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts 
+								FROM userjobs 
+								LEFT JOIN blocks ON userjobs.blockstatehash = blocks.statehash 
+								WHERE id = $1 
+								ORDER BY userjobs.canonical DESC, blocks.height DESC LIMIT 1`, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving data for tx %v from the database: %w", id, err)
+	}
+
+	return tx, nil
+}
+
+// GetAccount retrieves an account by its public key
+func GetAccount(publicKey string) (*types.Account, error) {
+	account := &types.Account{}
+	err := DB.Get("GetAccount", account, "SELECT * FROM accounts WHERE publickey = $1", publicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving data for account %v from the database: %w", publicKey, err)
+	}
+
+	return account, nil
+}
+
+// GetPreviousAccountStates retrieves the most recent state of each account on the chain ending at the given parent block.
+// The chain is followed through the parent hashes until a canonical block is reached, below which canonical states are used.
+func GetPreviousAccountStates(publicKeys []string, parentHash string) (map[string]*types.AccountBalance, error) {
+	var balances []*types.AccountBalance
+	err := DB.Select("GetPreviousAccountStates", &balances, `WITH RECURSIVE ancestors AS (
+									SELECT statehash, previousstatehash, height, canonical FROM blocks WHERE statehash = $2
+									UNION ALL
+									SELECT blocks.statehash, blocks.previousstatehash, blocks.height, blocks.canonical 
+									FROM blocks 
+									INNER JOIN ancestors ON blocks.statehash = ancestors.previousstatehash 
+									WHERE NOT ancestors.canonical
+								)
+								SELECT DISTINCT ON (publickey) * FROM account_balances 
+								WHERE publickey = ANY($1) AND (
+									blockstatehash IN (SELECT statehash FROM ancestors) OR 
+									(canonical AND height < (SELECT MIN(height) FROM ancestors))
+								)
+								ORDER BY publickey, height DESC`, pq.StringArray(publicKeys), parentHash)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving account states at block %v: %w", parentHash, err)
+	}
+
+	states := make(map[string]*types.AccountBalance, len(balances))
+	for _, b := range balances {
+		states[b.PublicKey] = b
+	}
+	return states, nil
+}
+
+// SaveAccountDiscrepancies saves differences between derived and reported account states
+func SaveAccountDiscrepancies(discrepancies []*types.AccountDiscrepancy) error {
+	tx, err := DB.Beginx("SaveAccountDiscrepancies")
+	if err != nil {
+		return fmt.Errorf("error starting db tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range discrepancies {
+		_, err := tx.NamedExec(`INSERT INTO account_discrepancies (publickey, blockstatehash, height, field, derived, node, detectedat) 
+			VALUES (:publickey, :blockstatehash, :height, :field, :derived, :node, :detectedat) 
+			ON CONFLICT (publickey, blockstatehash, field) DO UPDATE SET derived = excluded.derived, node = excluded.node, detectedat = excluded.detectedat`, d)
+		if err != nil {
+			return fmt.Errorf("error saving discrepancy of account %v at block %v: %w", d.PublicKey, d.BlockStateHash, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SaveNodeDivergences saves the blocks of nodes disagreeing on the best chain, the last seen time of already known
+// divergences is updated
+func SaveNodeDivergences(divergences []*types.NodeDivergence) error {
+	tx, err := DB.Beginx("SaveNodeDivergences")
+	if err != nil {
+		return fmt.Errorf("error starting db tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range divergences {
+		_, err := tx.NamedExec(`INSERT INTO node_divergences (height, node, statehash, firstseen, lastseen) 
+			VALUES (:height, :node, :statehash, :firstseen, :lastseen) 
+			ON CONFLICT (height, node, statehash) DO UPDATE SET lastseen = excluded.lastseen`, d)
+		if err != nil {
+			return fmt.Errorf("error saving divergence of node %v at height %v: %w", d.Node, d.Height, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAccountBalances retrieves the canonical balance snapshots of an account within a time range ordered by height
+func GetAccountBalances(publicKey string, from, to time.Time) ([]*types.AccountBalance, error) {
+	var balances []*types.AccountBalance
+	err := DB.Select("GetAccountBalances", &balances, `SELECT * FROM account_balances 
+								WHERE publickey = $1 AND canonical AND ts >= $2 AND ts <= $3 
+								ORDER BY height`, publicKey, from, to)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving balances of account %v from the database: %w", publicKey, err)
+	}
+
+	return balances, nil
+}
+
+// GetAccountTransactions retrieves the canonical transactions sent or received by an account ordered by time descending.
+// Only transactions sorting below the given timestamp, block state hash and id are returned to allow for keyset pagination.
+func GetAccountTransactions(publicKey string, beforeTs time.Time, beforeHash, beforeID string, limit int) ([]*types.TxPageData, error) {
+	var txs []*types.TxPageData
+	err := DB.Select("GetAccountTransactions", &txs, `SELECT userjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts
+										FROM accounttransactions 
+										LEFT JOIN userjobs ON accounttransactions.blockstatehash = userjobs.blockstatehash AND accounttransactions.id = userjobs.id
+										LEFT JOIN blocks ON accounttransactions.blockstatehash = blocks.statehash
+										WHERE accounttransactions.publickey = $1 AND accounttransactions.canonical 
+											AND (accounttransactions.ts, accounttransactions.blockstatehash, accounttransactions.id) < ($2, $3, $4)
+										ORDER BY accounttransactions.ts DESC, accounttransactions.blockstatehash DESC, accounttransactions.id DESC LIMIT $5`, publicKey, beforeTs, beforeHash, beforeID, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving transactions of account %v from the database: %w", publicKey, err)
+	}
+
+	return txs, nil
+}
+
+// GetStatistics retrieves all values of a statistics indicator within a time range ordered by time
+func GetStatistics(indicator string, from, to time.Time) ([]*types.Statistic, error) {
+	var statistics []*types.Statistic
+	err := DB.Select("GetStatistics", &statistics, "SELECT * FROM statistics WHERE indicator = $1 AND ts >= $2 AND ts <= $3 ORDER BY ts", indicator, from, to)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving %v statistics from the database: %w", indicator, err)
+	}
+
+	return statistics, nil
+}
+
 // SaveDaemonStatus saves the daemon status the the database
 func SaveDaemonStatus(daemonStatus *types.DaemonStatus) error {
-	_, err := DB.NamedExec(`INSERT INTO daemonstatus (
+	_, err := DB.NamedExec("SaveDaemonStatus", `INSERT INTO daemonstatus (
 						  ts,
                           blockchainlength,
                           commitid,
@@ -510,7 +994,15 @@ func SaveDaemonStatus(daemonStatus *types.DaemonStatus) error {
 		return fmt.Errorf("error saving daemon status: %w", err)
 	}
 
-	return nil
+	return notify(DB.DB, &types.Notification{Type: types.NotificationDaemonStatus})
+}
+
+// StatisticIndicators contains the names of all indicators generated by GenerateAndSaveStatistics
+var StatisticIndicators = []string{
+	"BLOCK_COUNT", "TX_COUNT", "TOTAL_SUPPLY", "BLOCK_PRODUCERS", "NEW_ACCOUNTS", "SNARK_WORKERS",
+	"SNARK_FEES", "SNARK_FEES_P50", "SNARK_FEES_P25", "SNARK_FEES_P95", "SNARK_FEES_P99",
+	"TX_INCLUSION_TIME_P50", "TX_INCLUSION_TIME_P95", "TX_INCLUSION_TIME_P99",
+	"TX_CONFIRMATION_TIME_P50", "TX_CONFIRMATION_TIME_P95", "TX_CONFIRMATION_TIME_P99", "PEERS",
 }
 
 // GenerateAndSaveStatistics generates the statistics for a given day and saves them to the database
@@ -519,7 +1011,7 @@ func GenerateAndSaveStatistics(date time.Time) error {
 	endDate := time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 59, 0, time.UTC)
 
 	logger.Infof("processing statistics for day %v", startDate)
-	tx, err := DB.Begin()
+	tx, err := DB.Beginx("GenerateAndSaveStatistics")
 
 	if err != nil {
 		return fmt.Errorf("error starting db tx: %w", err)
@@ -603,6 +1095,48 @@ func GenerateAndSaveStatistics(date time.Time) error {
 		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
 	}
 
+	// Transaction inclusion time P50 value
+	indicator = "TX_INCLUSION_TIME_P50"
+	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) SELECT $1, $2, percentile_cont(0.5) within group (order by GREATEST(EXTRACT(EPOCH FROM blocks.ts - mempool.firstseen), 0)) FROM mempool INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash WHERE blocks.ts >= $2 AND blocks.ts <= $3 HAVING COUNT(*) > 0 ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
+	}
+
+	// Transaction inclusion time P95 value
+	indicator = "TX_INCLUSION_TIME_P95"
+	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) SELECT $1, $2, percentile_cont(0.95) within group (order by GREATEST(EXTRACT(EPOCH FROM blocks.ts - mempool.firstseen), 0)) FROM mempool INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash WHERE blocks.ts >= $2 AND blocks.ts <= $3 HAVING COUNT(*) > 0 ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
+	}
+
+	// Transaction inclusion time P99 value
+	indicator = "TX_INCLUSION_TIME_P99"
+	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) SELECT $1, $2, percentile_cont(0.99) within group (order by GREATEST(EXTRACT(EPOCH FROM blocks.ts - mempool.firstseen), 0)) FROM mempool INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash WHERE blocks.ts >= $2 AND blocks.ts <= $3 HAVING COUNT(*) > 0 ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
+	}
+
+	// Transaction confirmation time P50 value
+	indicator = "TX_CONFIRMATION_TIME_P50"
+	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) SELECT $1, $2, percentile_cont(0.5) within group (order by GREATEST(EXTRACT(EPOCH FROM confirmation.ts - mempool.firstseen), 0)) FROM mempool INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash INNER JOIN blocks confirmation ON confirmation.height = blocks.height + $4 AND confirmation.canonical WHERE blocks.ts >= $2 AND blocks.ts <= $3 HAVING COUNT(*) > 0 ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate, types.TxConfirmationDepth)
+	if err != nil {
+		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
+	}
+
+	// Transaction confirmation time P95 value
+	indicator = "TX_CONFIRMATION_TIME_P95"
+	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) SELECT $1, $2, percentile_cont(0.95) within group (order by GREATEST(EXTRACT(EPOCH FROM confirmation.ts - mempool.firstseen), 0)) FROM mempool INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash INNER JOIN blocks confirmation ON confirmation.height = blocks.height + $4 AND confirmation.canonical WHERE blocks.ts >= $2 AND blocks.ts <= $3 HAVING COUNT(*) > 0 ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate, types.TxConfirmationDepth)
+	if err != nil {
+		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
+	}
+
+	// Transaction confirmation time P99 value
+	indicator = "TX_CONFIRMATION_TIME_P99"
+	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) SELECT $1, $2, percentile_cont(0.99) within group (order by GREATEST(EXTRACT(EPOCH FROM confirmation.ts - mempool.firstseen), 0)) FROM mempool INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash INNER JOIN blocks confirmation ON confirmation.height = blocks.height + $4 AND confirmation.canonical WHERE blocks.ts >= $2 AND blocks.ts <= $3 HAVING COUNT(*) > 0 ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate, types.TxConfirmationDepth)
+	if err != nil {
+		return fmt.Errorf("error executing %s statistics query for day %v: %w", indicator, startDate, err)
+	}
+
 	// Number of daily seen unique peers
 	indicator = "PEERS"
 	_, err = tx.Exec(`INSERT INTO statistics (indicator, ts, value) (SELECT $1, $2, COUNT(DISTINCT peer) FROM (SELECT UNNEST(peers) AS peer FROM daemonstatus WHERE ts >= $2 AND ts <= $3) AS a) ON CONFLICT (indicator, ts) DO UPDATE SET value = EXCLUDED.value;`, indicator, startDate, endDate)
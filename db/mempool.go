@@ -0,0 +1,102 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"coda-explorer/types"
+	"fmt"
+	"time"
+)
+
+// UpdateMempool saves the transactions currently pooled by the node as pending. Pending transactions included in a
+// canonical block are marked as included, all other transactions that are no longer pooled are marked as dropped.
+func UpdateMempool(pooled []*types.MempoolTransaction, ts time.Time) error {
+	tx, err := DB.Beginx("UpdateMempool")
+	if err != nil {
+		return fmt.Errorf("error starting db tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range pooled {
+		p.Status = types.MempoolStatusPending
+		p.FirstSeen = ts
+		p.LastSeen = ts
+
+		_, err := tx.NamedExec(`INSERT INTO mempool (id, sender, recipient, memo, fee, amount, nonce, delegation, status, firstseen, lastseen) 
+			VALUES (:id, :sender, :recipient, :memo, :fee, :amount, :nonce, :delegation, :status, :firstseen, :lastseen) 
+			ON CONFLICT (id) DO UPDATE SET status = excluded.status, blockstatehash = '', lastseen = excluded.lastseen`, p)
+		if err != nil {
+			return fmt.Errorf("error saving mempool transaction %v: %w", p.ID, err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE mempool SET status = $1, blockstatehash = userjobs.blockstatehash 
+		FROM userjobs 
+		WHERE mempool.id = userjobs.id AND userjobs.canonical AND mempool.status <> $1`, types.MempoolStatusIncluded)
+	if err != nil {
+		return fmt.Errorf("error marking included mempool transactions: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE mempool SET status = $1 WHERE status = $2 AND lastseen < $3", types.MempoolStatusDropped, types.MempoolStatusPending, ts)
+	if err != nil {
+		return fmt.Errorf("error marking dropped mempool transactions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetMempoolTransactions retrieves the most recently first seen mempool transactions with the given status
+func GetMempoolTransactions(status string, limit int) ([]*types.MempoolTransaction, error) {
+	var txs []*types.MempoolTransaction
+	err := DB.Select("GetMempoolTransactions", &txs, "SELECT * FROM mempool WHERE status = $1 ORDER BY firstseen DESC LIMIT $2", status, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving %v mempool transactions: %w", status, err)
+	}
+
+	return txs, nil
+}
+
+// GetMempoolTransaction retrieves a single mempool transaction by its id
+func GetMempoolTransaction(id string) (*types.MempoolTransaction, error) {
+	tx := &types.MempoolTransaction{}
+	err := DB.Get("GetMempoolTransaction", tx, "SELECT * FROM mempool WHERE id = $1", id)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving mempool transaction %v: %w", id, err)
+	}
+
+	return tx, nil
+}
+
+// GetTransactionLatency retrieves the inclusion and confirmation timestamps of a canonical transaction that has been seen in the mempool
+func GetTransactionLatency(id string) (*types.TxLatency, error) {
+	latency := &types.TxLatency{}
+	err := DB.Get("GetTransactionLatency", latency, `SELECT mempool.firstseen, blocks.ts AS includedts,
+       			(SELECT COALESCE(MAX(tip.height), blocks.height) FROM blocks tip WHERE tip.canonical) - blocks.height AS confirmations,
+       			(SELECT confirmation.ts FROM blocks confirmation WHERE confirmation.height = blocks.height + $2 AND confirmation.canonical LIMIT 1) AS confirmedts
+			FROM mempool
+			INNER JOIN userjobs ON userjobs.id = mempool.id AND userjobs.canonical
+			INNER JOIN blocks ON blocks.statehash = userjobs.blockstatehash
+			WHERE mempool.id = $1`, id, types.TxConfirmationDepth)
+
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving latency of transaction %v: %w", id, err)
+	}
+
+	return latency, nil
+}
@@ -0,0 +1,244 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"coda-explorer/types"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Key of the advisory lock serializing concurrent migrate runs against the same database
+const migrationLockID = 20200301
+
+// migration is a versioned change of the database schema
+type migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// LatestSchemaVersion returns the schema version the binary has been built for
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Creates the table keeping track of all applied migrations
+func createMigrationsTable() error {
+	_, err := DB.Exec("createMigrationsTable", `CREATE TABLE IF NOT EXISTS schema_migrations (
+								version     int          not null primary key,
+								description varchar(200) not null,
+								appliedat   timestamp    not null
+							)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// Checks whether the table keeping track of the applied migrations has been created already
+func migrationsTableExists() (bool, error) {
+	var exists bool
+	err := DB.Get("migrationsTableExists", &exists, "SELECT to_regclass('schema_migrations') IS NOT NULL")
+	if err != nil {
+		return false, fmt.Errorf("error checking for schema_migrations table: %w", err)
+	}
+	return exists, nil
+}
+
+// SchemaVersion returns the version of the latest migration applied to the database, 0 if no migration has been applied yet.
+// The database is not modified, a missing schema_migrations table is treated as version 0.
+func SchemaVersion() (int, error) {
+	exists, err := migrationsTableExists()
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = DB.Get("SchemaVersion", &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving schema version: %w", err)
+	}
+	return version, nil
+}
+
+// CheckSchemaVersion returns an error if the database schema does not match the version the binary has been built for
+func CheckSchemaVersion() error {
+	version, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version < LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %v is outdated, please run the migrate up command to upgrade to version %v", version, LatestSchemaVersion())
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %v is newer than the version %v supported by this binary", version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// Runs f while holding the migration advisory lock. The lock is taken on a dedicated connection of the pool
+// and released when f returns, concurrent callers wait until it is available.
+func withMigrationLock(f func() error) error {
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving db connection: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer func() {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+		if err != nil {
+			logger.Errorf("error releasing migration lock: %v", err)
+		}
+	}()
+
+	return f()
+}
+
+// MigrateUp applies all pending migrations, each in its own db transaction
+func MigrateUp() error {
+	return withMigrationLock(migrateUp)
+}
+
+func migrateUp() error {
+	err := createMigrationsTable()
+	if err != nil {
+		return err
+	}
+	version, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		logger.Infof("applying migration %v: %v", m.Version, m.Description)
+		err = runMigration(m.Up, "INSERT INTO schema_migrations (version, description, appliedat) VALUES ($1, $2, $3)", m.Version, m.Description, time.Now())
+		if err != nil {
+			return fmt.Errorf("error applying migration %v: %w", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the latest applied migration
+func MigrateDown() error {
+	return withMigrationLock(migrateDown)
+}
+
+func migrateDown() error {
+	version, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version != version {
+			continue
+		}
+
+		logger.Infof("reverting migration %v: %v", m.Version, m.Description)
+		err = runMigration(m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+		if err != nil {
+			return fmt.Errorf("error reverting migration %v: %w", m.Version, err)
+		}
+		return nil
+	}
+
+	if version == 0 {
+		return fmt.Errorf("no migration has been applied yet")
+	}
+	return fmt.Errorf("migration %v is unknown to this binary", version)
+}
+
+// Executes the statements of a migration together with the update of the schema_migrations table in a single db transaction
+func runMigration(statements string, bookkeeping string, args ...interface{}) error {
+	tx, err := DB.Beginx("runMigration")
+	if err != nil {
+		return fmt.Errorf("error starting db tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(statements)
+	if err != nil {
+		return fmt.Errorf("error executing migration statements: %w", err)
+	}
+
+	_, err = tx.Exec(bookkeeping, args...)
+	if err != nil {
+		return fmt.Errorf("error updating schema_migrations table: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetMigrationStatus returns all known and applied migrations ordered by version
+func GetMigrationStatus() ([]*types.SchemaMigration, error) {
+	exists, err := migrationsTableExists()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []*types.SchemaMigration
+	if exists {
+		err = DB.Select("GetMigrationStatus", &applied, "SELECT version, description, appliedat FROM schema_migrations ORDER BY version")
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving applied migrations: %w", err)
+		}
+	}
+
+	appliedMap := make(map[int]*types.SchemaMigration)
+	for _, m := range applied {
+		appliedMap[m.Version] = m
+	}
+
+	status := make([]*types.SchemaMigration, 0, len(migrations))
+	for _, m := range migrations {
+		if a, exists := appliedMap[m.Version]; exists {
+			status = append(status, a)
+			delete(appliedMap, m.Version)
+			continue
+		}
+		status = append(status, &types.SchemaMigration{Version: m.Version, Description: m.Description})
+	}
+
+	// Migrations applied by a newer binary
+	for _, m := range applied {
+		if _, exists := appliedMap[m.Version]; exists {
+			status = append(status, m)
+		}
+	}
+
+	return status, nil
+}
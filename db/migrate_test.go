@@ -0,0 +1,122 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const migrateTestSchema = "coda_explorer_migrate_test"
+
+// Connects to an empty schema of the test database, requires CODA_EXPLORER_TEST_DB to be set
+func setupMigrateTestDB(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv(testDBEnv)
+	if dsn == "" {
+		t.Skipf("%v is not set", testDBEnv)
+	}
+
+	dbConn, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dbConn.Exec("DROP SCHEMA IF EXISTS " + migrateTestSchema + " CASCADE; CREATE SCHEMA " + migrateTestSchema)
+	dbConn.Close()
+	if err != nil {
+		t.Fatalf("error creating schema %v: %v", migrateTestSchema, err)
+	}
+
+	// Unknown connection parameters are passed to the server as run-time parameters
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		q := u.Query()
+		q.Set("search_path", migrateTestSchema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + migrateTestSchema
+	}
+	dbConn, err = sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB = NewConn(dbConn)
+	return dbConn
+}
+
+// Checking the schema version of an empty database must not create the schema_migrations table
+func TestCheckSchemaVersionReadOnly(t *testing.T) {
+	dbConn := setupMigrateTestDB(t)
+	defer dbConn.Close()
+
+	version, err := SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Errorf("schema version of an empty database is %v, expected 0", version)
+	}
+	if CheckSchemaVersion() == nil {
+		t.Error("expected an error for an outdated schema")
+	}
+
+	exists, err := migrationsTableExists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("schema_migrations table has been created by the schema version check")
+	}
+}
+
+// Concurrent migrate runs wait for each other and apply every migration once
+func TestMigrateUpConcurrently(t *testing.T) {
+	dbConn := setupMigrateTestDB(t)
+	defer dbConn.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- MigrateUp()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("error applying migrations: %v", err)
+		}
+	}
+
+	err := CheckSchemaVersion()
+	if err != nil {
+		t.Error(err)
+	}
+	status, err := GetMigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != len(migrations) {
+		t.Errorf("%v migrations recorded, expected %v", len(status), len(migrations))
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"coda-explorer/types"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres channel all change notifications are sent on
+const notificationChannel = "coda_explorer"
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Sends a change notification, notifications sent within a transaction are only delivered after the commit
+func notify(e execer, notification *types.Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("error encoding %v notification: %w", notification.Type, err)
+	}
+
+	_, err = e.Exec("SELECT pg_notify($1, $2)", notificationChannel, string(payload))
+	if err != nil {
+		return fmt.Errorf("error sending %v notification: %w", notification.Type, err)
+	}
+	return nil
+}
+
+// ListenNotifications opens a dedicated connection to the database and delivers all change notifications on the
+// given channel. A nil notification is delivered after the connection has been re-established, notifications
+// sent while the connection was down are lost. Once the context is done the connection is closed and so is the channel.
+func ListenNotifications(ctx context.Context, connString string, notifications chan<- *types.Notification) error {
+	listener := pq.NewListener(connString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Errorf("error in notification listener: %v", err)
+		}
+	})
+
+	err := listener.Listen(notificationChannel)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("error listening on notification channel %v: %w", notificationChannel, err)
+	}
+
+	go func() {
+		defer close(notifications)
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				if n == nil {
+					notifications <- nil
+					continue
+				}
+
+				notification := &types.Notification{}
+				err := json.Unmarshal([]byte(n.Extra), notification)
+				if err != nil {
+					logger.Errorf("error decoding notification %v: %v", n.Extra, err)
+					continue
+				}
+				notifications <- notification
+			case <-time.After(time.Minute):
+				// Detect broken connections while no notifications are sent
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
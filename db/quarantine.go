@@ -0,0 +1,40 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"coda-explorer/types"
+	"fmt"
+)
+
+// QuarantineBlock saves a block that failed validation to the quarantined blocks table, the attempts counter of a
+// previously quarantined block is incremented
+func QuarantineBlock(block *types.QuarantinedBlock) error {
+	_, err := DB.NamedExec("QuarantineBlock", `INSERT INTO quarantined_blocks (statehash, previousstatehash, height, errors, payload, attempts, firstseen, lastseen) 
+			VALUES (:statehash, :previousstatehash, :height, :errors, :payload, 1, :firstseen, :lastseen) 
+			ON CONFLICT (statehash) DO UPDATE SET 
+				previousstatehash = excluded.previousstatehash, 
+				height = excluded.height, 
+				errors = excluded.errors, 
+				payload = excluded.payload, 
+				attempts = quarantined_blocks.attempts + 1, 
+				lastseen = excluded.lastseen`, block)
+	if err != nil {
+		return fmt.Errorf("error quarantining block %v: %w", block.StateHash, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+import (
+	"coda-explorer/rpc/fake"
+	"coda-explorer/types"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const testDBEnv = "CODA_EXPLORER_TEST_DB"
+
+// Compares the multi-row child table inserts of SaveBlock with the previous implementation issuing one statement per row.
+// Every iteration is rolled back, requires CODA_EXPLORER_TEST_DB to point to an empty database.
+func BenchmarkSaveBlockChildren(b *testing.B) {
+	dsn := os.Getenv(testDBEnv)
+	if dsn == "" {
+		b.Skipf("%v is not set", testDBEnv)
+	}
+
+	dbConn, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer dbConn.Close()
+	DB = NewConn(dbConn)
+
+	err = MigrateUp()
+	if err != nil {
+		b.Fatalf("error migrating test database: %v", err)
+	}
+	logger.Logger.SetLevel(logrus.WarnLevel)
+
+	implementations := []struct {
+		name string
+		save func(tx *Tx, block *types.Block) error
+	}{
+		{"RowByRow", saveBlockChildrenRowByRow},
+		{"MultiRow", saveBlockChildren},
+	}
+
+	for _, size := range []int{10, 100, 1000} {
+		block := newBenchmarkBlock(size)
+		for _, impl := range implementations {
+			b.Run(fmt.Sprintf("%v/%v", impl.name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					tx, err := DB.Beginx("BenchmarkSaveBlockChildren")
+					if err != nil {
+						b.Fatal(err)
+					}
+					err = impl.save(tx, block)
+					tx.Rollback()
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Creates a block with the given number of user jobs and a snark job, a fee transfer and an account balance per user job
+func newBenchmarkBlock(size int) *types.Block {
+	block := fake.NewBlock("benchmark-parent", fmt.Sprintf("benchmark-%v", size), 1, 1, time.Now(), "benchmark-creator")
+	for i := 0; i < size; i++ {
+		pk := fmt.Sprintf("benchmark-account-%v", i)
+		block.UserJobs = append(block.UserJobs, &types.UserJob{BlockStateHash: block.StateHash, Index: i, ID: fmt.Sprintf("benchmark-tx-%v-%v", size, i), Sender: block.Creator, Recipient: pk, Memo: "memo", Fee: types.NewAmount(10000000), Amount: types.NewAmount(1000000000), Nonce: i})
+		block.SnarkJobs = append(block.SnarkJobs, &types.SnarkJob{BlockStateHash: block.StateHash, Index: i, Jobids: pq.Int64Array{int64(2 * i), int64(2*i + 1)}, Prover: pk, Fee: types.NewAmount(5000000)})
+		block.FeeTransfers = append(block.FeeTransfers, &types.FeeTransfer{BlockStateHash: block.StateHash, Index: i, Recipient: pk, Fee: types.NewAmount(5000000)})
+		block.AccountBalances = append(block.AccountBalances, &types.AccountBalance{PublicKey: pk, BlockStateHash: block.StateHash, Height: block.Height, Balance: types.NewAmount(1000000000), Ts: block.Ts, Delegate: pk})
+	}
+	return block
+}
+
+// Previous implementation of saveBlockChildren issuing one statement per row, kept as benchmark baseline
+func saveBlockChildrenRowByRow(tx *Tx, block *types.Block) error {
+	for _, sj := range block.SnarkJobs {
+		_, err := tx.NamedExec(`INSERT INTO snarkjobs (blockstatehash, canonical, index, jobids, prover, fee) VALUES (:blockstatehash, :canonical, :index, :jobids, :prover, :fee) ON CONFLICT DO NOTHING`, sj)
+		if err != nil {
+			return fmt.Errorf("error executing snark job insert db query: %w", err)
+		}
+	}
+
+	for _, ft := range block.FeeTransfers {
+		_, err := tx.NamedExec(`INSERT INTO feetransfers (blockstatehash, canonical, index, recipient, fee) VALUES (:blockstatehash, :canonical, :index, :recipient, :fee) ON CONFLICT DO NOTHING `, ft)
+		if err != nil {
+			return fmt.Errorf("error executing fee transfer insert db query: %w", err)
+		}
+	}
+
+	for _, uj := range block.UserJobs {
+		_, err := tx.NamedExec(`INSERT INTO userjobs (blockstatehash, canonical, index, id, sender, recipient, memo, fee, amount, nonce, delegation) VALUES (:blockstatehash, :canonical, :index, :id, :sender, :recipient, :memo, :fee, :amount, :nonce, :delegation) ON CONFLICT DO NOTHING`, uj)
+		if err != nil {
+			return fmt.Errorf("error executing userjobs insert db query: %w", err)
+		}
+
+		_, err = tx.Exec("INSERT INTO accounttransactions (publickey, blockstatehash, canonical, id, ts) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;", uj.Sender, block.StateHash, false, uj.ID, block.Ts)
+		if err != nil {
+			return fmt.Errorf("error executing accounttransactions userjob sender insert db query: %w", err)
+		}
+
+		_, err = tx.Exec("INSERT INTO accounttransactions (publickey, blockstatehash, canonical, id, ts) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;", uj.Recipient, block.StateHash, false, uj.ID, block.Ts)
+		if err != nil {
+			return fmt.Errorf("error executing accounttransactions userjob recipient insert db query: %w", err)
+		}
+	}
+
+	for _, ab := range block.AccountBalances {
+		ab.Canonical = block.Canonical
+		_, err := tx.NamedExec(`INSERT INTO account_balances (publickey, blockstatehash, height, canonical, balance, nonce, ts, delegate, derived) VALUES (:publickey, :blockstatehash, :height, :canonical, :balance, :nonce, :ts, :delegate, :derived) ON CONFLICT DO NOTHING`, ab)
+		if err != nil {
+			return fmt.Errorf("error executing account_balances insert db query: %w", err)
+		}
+	}
+
+	return nil
+}
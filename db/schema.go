@@ -0,0 +1,294 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package db
+
+// migrations contains all versioned changes of the database schema, ordered by version
+var migrations = []*migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: `
+		create table if not exists blocks
+		(
+		    statehash         varchar(400) not null,
+		    canonical         bool         not null,
+		    previousstatehash varchar(400) not null,
+		    snarkedledgerhash varchar(400) not null,
+		    stagedledgerhash  varchar(400) not null,
+		    coinbase          numeric      not null,
+		    creator           varchar(200) not null,
+		    slot              int          not null,
+		    height            int          not null,
+		    epoch             int          not null,
+		    ts                timestamp    not null,
+		    totalcurrency     numeric      not null,
+		    usercommandscount int          not null,
+		    snarkjobscount    int          not null,
+		    feetransfercount  int          not null,
+		    primary key (statehash)
+		);
+		create index if not exists idx_blocks_creator on blocks (creator);
+		create index if not exists idx_blocks_ts on blocks (ts);
+		create index if not exists idx_blocks_height on blocks (height);
+
+		create table if not exists snarkjobs
+		(
+		    blockstatehash varchar(400) not null,
+		    canonical      bool         not null,
+		    index          int          not null,
+		    jobids         int[]        not null,
+		    prover         varchar(200) not null,
+		    fee            int          not null,
+		    primary key (blockstatehash, index)
+		);
+		create index if not exists idx_snarkjobs_prover on snarkjobs (prover);
+
+		create table if not exists feetransfers
+		(
+		    blockstatehash varchar(400) not null,
+		    canonical      bool         not null,
+		    index          int          not null,
+		    recipient      varchar(200) not null,
+		    fee            int          not null,
+		    primary key (blockstatehash, index)
+		);
+		create index if not exists idx_feetransfers_recipient on feetransfers (recipient);
+
+		create table if not exists userjobs
+		(
+		    blockstatehash varchar(400) not null,
+		    canonical      bool         not null,
+		    index          int          not null,
+		    id             text         not null,
+		    sender         varchar(200) not null,
+		    recipient      varchar(200) not null,
+		    memo           varchar(200) not null,
+		    fee            numeric      not null,
+		    amount         numeric      not null,
+		    nonce          varchar(200) not null,
+		    delegation     bool         not null,
+		    primary key (blockstatehash, index)
+		);
+		create index if not exists idx_userjobs_id on userjobs (id);
+
+		create table if not exists accounts
+		(
+		    publickey        varchar(200) not null primary key,
+		    balance          numeric      not null,
+		    nonce            int          not null,
+		    receiptchainhash varchar(400) not null,
+		    delegate         varchar(200) not null,
+		    votingfor        varchar(200) not null,
+		    txsent           int          not null,
+		    txreceived       int          not null,
+		    blocksproposed   int          not null,
+		    snarkjobs        int          not null,
+		    firstseen        timestamp    not null,
+		    lastseen         timestamp    not null
+		);
+		create index if not exists idx_accounts_firstseen on accounts (firstseen);
+		create index if not exists idx_accounts_lastseen on accounts (lastseen);
+		create index if not exists idx_accounts_balance on accounts (balance);
+		create index if not exists idx_accounts_delegate on accounts (delegate);
+		create index if not exists idx_accounts_txsent on accounts (txsent);
+		create index if not exists idx_accounts_txreceived on accounts (txreceived);
+		create index if not exists idx_accounts_blocksproposed on accounts (blocksproposed);
+		create index if not exists idx_accounts_snarkjobs on accounts (snarkjobs);
+
+		create table if not exists accounttransactions
+		(
+		    publickey      varchar(200)  not null,
+		    blockstatehash varchar(400)  not null,
+		    canonical      bool          not null,
+		    id             text not null,
+		    ts             timestamp     not null,
+		    primary key (publickey, ts, blockstatehash, id)
+		);
+		create index if not exists idx_accounttransactions_blockstatehash on accounttransactions (blockstatehash);
+
+		create table if not exists daemonstatus
+		(
+		    ts                         timestamp    not null primary key,
+		    blockchainlength           int          not null,
+		    commitid                   varchar(200) not null,
+		    epochduration              int          not null,
+		    slotduration               int          not null,
+		    slotsperepoch              int          not null,
+		    consensusmechanism         varchar(200) not null,
+		    highestblocklengthreceived int          not null,
+		    ledgermerkleroot           varchar(400) not null,
+		    numaccounts                int          not null,
+		    peers                      text[]       not null,
+		    peerscount                 int          not null,
+		    statehash                  varchar(400) not null,
+		    syncstatus                 varchar(200) not null,
+		    uptime                     int          not null
+		);
+
+		create table if not exists statistics
+		(
+		    indicator varchar(50) not null,
+		    ts        timestamp   not null,
+		    value     numeric     not null,
+		    primary key (indicator, ts)
+		);`,
+		Down: `
+		drop table if exists blocks;
+		drop table if exists snarkjobs;
+		drop table if exists feetransfers;
+		drop table if exists userjobs;
+		drop table if exists accounts;
+		drop table if exists accounttransactions;
+		drop table if exists daemonstatus;
+		drop table if exists statistics;`,
+	},
+	{
+		Version:     2,
+		Description: "add backfill checkpoint table",
+		Up: `
+		create table if not exists backfillcheckpoint
+		(
+		    id        int          not null default 1,
+		    statehash varchar(400) not null,
+		    canonical bool         not null,
+		    updated   timestamp    not null,
+		    primary key (id)
+		);`,
+		Down: `
+		drop table if exists backfillcheckpoint;`,
+	},
+	{
+		Version:     3,
+		Description: "add blocks previous state hash index",
+		Up: `
+		create index if not exists idx_blocks_previousstatehash on blocks (previousstatehash);`,
+		Down: `
+		drop index if exists idx_blocks_previousstatehash;`,
+	},
+	{
+		Version:     4,
+		Description: "store all amounts as numeric values",
+		Up: `
+		alter table snarkjobs alter column fee type numeric;
+		alter table feetransfers alter column fee type numeric;`,
+		Down: `
+		alter table snarkjobs alter column fee type int;
+		alter table feetransfers alter column fee type int;`,
+	},
+	{
+		Version:     5,
+		Description: "add mempool table",
+		Up: `
+		create table if not exists mempool
+		(
+		    id             text         not null,
+		    sender         varchar(200) not null,
+		    recipient      varchar(200) not null,
+		    memo           varchar(200) not null,
+		    fee            numeric      not null,
+		    amount         numeric      not null,
+		    nonce          int          not null,
+		    delegation     bool         not null,
+		    status         varchar(20)  not null,
+		    blockstatehash varchar(400) not null default '',
+		    firstseen      timestamp    not null,
+		    lastseen       timestamp    not null,
+		    primary key (id)
+		);
+		create index if not exists idx_mempool_status on mempool (status, firstseen);`,
+		Down: `
+		drop table if exists mempool;`,
+	},
+	{
+		Version:     6,
+		Description: "add account balances table",
+		Up: `
+		create table if not exists account_balances
+		(
+		    publickey      varchar(200) not null,
+		    blockstatehash varchar(400) not null,
+		    height         int          not null,
+		    canonical      bool         not null,
+		    balance        numeric      not null,
+		    nonce          int          not null,
+		    ts             timestamp    not null,
+		    primary key (publickey, blockstatehash, height)
+		);
+		create index if not exists idx_account_balances_blockstatehash on account_balances (blockstatehash);
+		create index if not exists idx_account_balances_publickey_height on account_balances (publickey, height);`,
+		Down: `
+		drop table if exists account_balances;`,
+	},
+	{
+		Version:     7,
+		Description: "add account state columns and account discrepancies table",
+		Up: `
+		alter table account_balances add column if not exists delegate varchar(200) not null default '';
+		alter table account_balances add column if not exists derived bool not null default false;
+
+		create table if not exists account_discrepancies
+		(
+		    publickey      varchar(200) not null,
+		    blockstatehash varchar(400) not null,
+		    height         int          not null,
+		    field          varchar(20)  not null,
+		    derived        text         not null,
+		    node           text         not null,
+		    detectedat     timestamp    not null,
+		    primary key (publickey, blockstatehash, field)
+		);`,
+		Down: `
+		drop table if exists account_discrepancies;
+		alter table account_balances drop column if exists derived;
+		alter table account_balances drop column if exists delegate;`,
+	},
+	{
+		Version:     8,
+		Description: "add quarantined blocks table",
+		Up: `
+		create table if not exists quarantined_blocks
+		(
+		    statehash         varchar(400) not null,
+		    previousstatehash varchar(400) not null,
+		    height            int          not null,
+		    errors            jsonb        not null,
+		    payload           jsonb        not null,
+		    attempts          int          not null,
+		    firstseen         timestamp    not null,
+		    lastseen          timestamp    not null,
+		    primary key (statehash)
+		);`,
+		Down: `
+		drop table if exists quarantined_blocks;`,
+	},
+	{
+		Version:     9,
+		Description: "add node divergences table",
+		Up: `
+		create table if not exists node_divergences
+		(
+		    height    int          not null,
+		    node      varchar(200) not null,
+		    statehash varchar(400) not null,
+		    firstseen timestamp    not null,
+		    lastseen  timestamp    not null,
+		    primary key (height, node, statehash)
+		);`,
+		Down: `
+		drop table if exists node_divergences;`,
+	},
+}
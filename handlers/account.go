@@ -18,7 +18,6 @@ package handlers
 
 import (
 	"coda-explorer/db"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"encoding/json"
@@ -29,7 +28,7 @@ import (
 	"strconv"
 )
 
-var accountTemplate = template.Must(template.New("blocks").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/account.html"))
+var accountTemplate *template.Template
 
 // Account will return information about an account using a go template
 func Account(w http.ResponseWriter, r *http.Request) {
@@ -52,14 +51,14 @@ func Account(w http.ResponseWriter, r *http.Request) {
 	pk := vars["pk"]
 	account := &types.AccountPageData{}
 
-	err := db.DB.Get(account, "SELECT * FROM accounts WHERE publickey = $1", pk)
+	err := db.DB.Get("handlers.Account", account, "SELECT * FROM accounts WHERE publickey = $1", pk)
 	if err != nil {
 		logger.Errorf("error retrieving account data for account %v: %v", pk, err)
 		http.Error(w, "Internal server error", 503)
 		return
 	}
 
-	err = db.DB.Select(&account.Delegations, "SELECT publickey, balance FROM accounts WHERE delegate = $1 AND publickey != $1", pk)
+	err = db.DB.Select("handlers.Account", &account.Delegations, "SELECT publickey, balance FROM accounts WHERE delegate = $1 AND publickey != $1", pk)
 	if err != nil {
 		logger.Errorf("error retrieving account delegation data for account %v: %v", pk, err)
 		http.Error(w, "Internal server error", 503)
@@ -103,8 +102,8 @@ func AccountBlocksData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 503)
 		return
 	}
-	if length > 100 {
-		length = 100
+	if length > int64(pageLimit) {
+		length = int64(pageLimit)
 	}
 
 	vars := mux.Vars(r)
@@ -112,7 +111,7 @@ func AccountBlocksData(w http.ResponseWriter, r *http.Request) {
 
 	var blocksCount int64
 
-	err = db.DB.Get(&blocksCount, "SELECT least(blocksproposed, 10000) FROM accounts WHERE publickey = $1", pk)
+	err = db.DB.Get("handlers.AccountBlocksData", &blocksCount, "SELECT least(blocksproposed, 10000) FROM accounts WHERE publickey = $1", pk)
 	if err != nil {
 		logger.Errorf("error retrieving blockproposed for account %v: %v", pk, err)
 		http.Error(w, "Internal server error", 503)
@@ -121,7 +120,7 @@ func AccountBlocksData(w http.ResponseWriter, r *http.Request) {
 
 	var blocks []*types.Block
 
-	err = db.DB.Select(&blocks, `SELECT *
+	err = db.DB.Select("handlers.AccountBlocksData", &blocks, `SELECT *
 										FROM blocks 
 										WHERE creator = $1
 										ORDER BY blocks.height DESC, canonical DESC LIMIT $2 OFFSET $3`, pk, length, start)
@@ -187,8 +186,8 @@ func AccountTxData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 503)
 		return
 	}
-	if length > 100 {
-		length = 100
+	if length > int64(pageLimit) {
+		length = int64(pageLimit)
 	}
 
 	vars := mux.Vars(r)
@@ -196,7 +195,7 @@ func AccountTxData(w http.ResponseWriter, r *http.Request) {
 
 	var txCount int64
 
-	err = db.DB.Get(&txCount, "SELECT least(count(*), 10000) FROM accounttransactions WHERE publickey = $1 AND canonical", pk)
+	err = db.DB.Get("handlers.AccountTxData", &txCount, "SELECT least(count(*), 10000) FROM accounttransactions WHERE publickey = $1 AND canonical", pk)
 	if err != nil {
 		logger.Errorf("error retrieving tx count for account %v: %v", pk, err)
 		http.Error(w, "Internal server error", 503)
@@ -205,7 +204,7 @@ func AccountTxData(w http.ResponseWriter, r *http.Request) {
 
 	var txs []*types.TxPageData
 
-	err = db.DB.Select(&txs, `SELECT userjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts
+	err = db.DB.Select("handlers.AccountTxData", &txs, `SELECT userjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts
 										FROM accounttransactions 
 										LEFT JOIN userjobs ON accounttransactions.blockstatehash = userjobs.blockstatehash AND accounttransactions.id = userjobs.id
 										LEFT JOIN blocks ON accounttransactions.blockstatehash = blocks.statehash
@@ -273,8 +272,8 @@ func AccountSnarkJobsData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 503)
 		return
 	}
-	if length > 100 {
-		length = 100
+	if length > int64(pageLimit) {
+		length = int64(pageLimit)
 	}
 
 	vars := mux.Vars(r)
@@ -282,7 +281,7 @@ func AccountSnarkJobsData(w http.ResponseWriter, r *http.Request) {
 
 	var blocksCount int64
 
-	err = db.DB.Get(&blocksCount, "SELECT least(snarkjobs, 10000) FROM accounts WHERE publickey = $1", pk)
+	err = db.DB.Get("handlers.AccountSnarkJobsData", &blocksCount, "SELECT least(snarkjobs, 10000) FROM accounts WHERE publickey = $1", pk)
 	if err != nil {
 		logger.Errorf("error retrieving snarkjobs for account %v: %v", pk, err)
 		http.Error(w, "Internal server error", 503)
@@ -291,7 +290,7 @@ func AccountSnarkJobsData(w http.ResponseWriter, r *http.Request) {
 
 	var snarkJobs []*types.SnarkJobPageData
 
-	err = db.DB.Select(&snarkJobs, `SELECT snarkjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts
+	err = db.DB.Select("handlers.AccountSnarkJobsData", &snarkJobs, `SELECT snarkjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts
 										FROM snarkjobs 
 										LEFT JOIN blocks On snarkjobs.blockstatehash = blocks.statehash
 										WHERE prover = $1 AND snarkjobs.canonical
@@ -18,7 +18,6 @@ package handlers
 
 import (
 	"coda-explorer/db"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"encoding/json"
@@ -28,7 +27,7 @@ import (
 	"strconv"
 )
 
-var accountsTemplate = template.Must(template.New("blocks").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/accounts.html"))
+var accountsTemplate *template.Template
 
 // Accounts will return information about all accounts using a go template
 func Accounts(w http.ResponseWriter, r *http.Request) {
@@ -80,8 +79,8 @@ func AccountsData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 503)
 		return
 	}
-	if length > 100 {
-		length = 100
+	if length > int64(pageLimit) {
+		length = int64(pageLimit)
 	}
 
 	orderColumn := q.Get("order[0][column]")
@@ -107,7 +106,7 @@ func AccountsData(w http.ResponseWriter, r *http.Request) {
 
 	var accountsCount int64
 
-	err = db.DB.Get(&accountsCount, "SELECT COUNT(*) FROM accounts")
+	err = db.DB.Get("handlers.AccountsData", &accountsCount, "SELECT COUNT(*) FROM accounts")
 	if err != nil {
 		logger.Errorf("error retrieving accounts count: %v", err)
 		http.Error(w, "Internal server error", 503)
@@ -116,7 +115,7 @@ func AccountsData(w http.ResponseWriter, r *http.Request) {
 
 	var accounts []*types.Account
 
-	err = db.DB.Select(&accounts, fmt.Sprintf(`SELECT *
+	err = db.DB.Select("handlers.AccountsData", &accounts, fmt.Sprintf(`SELECT *
 										FROM accounts 
 										ORDER BY %s %s LIMIT $1 OFFSET $2`, orderBy, orderDir), length, start)
 
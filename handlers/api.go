@@ -0,0 +1,368 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package handlers
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/types"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const apiDefaultLimit = 25
+
+// Maximum number of rows returned by the paginated data and api routes
+var pageLimit = 100
+
+// SetPageLimit sets the maximum number of rows returned by the paginated data and api routes, the OpenAPI document is
+// regenerated to describe the new limit. Must be called before the routes are served.
+func SetPageLimit(limit int) {
+	pageLimit = limit
+	openAPISpec = newOpenAPISpec()
+}
+
+// APIBlock will return a single block including its transactions, snark jobs and fee transfers by state hash or canonical height
+func APIBlock(w http.ResponseWriter, r *http.Request) {
+	hashOrHeight := mux.Vars(r)["hashOrHeight"]
+
+	var block *types.Block
+	height, err := strconv.Atoi(hashOrHeight)
+	if err == nil {
+		block, err = db.GetBlockByHeight(height)
+	} else {
+		block, err = db.GetBlockByHash(hashOrHeight)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		sendAPIError(w, http.StatusNotFound, fmt.Sprintf("block %v not found", hashOrHeight))
+		return
+	}
+	if err != nil {
+		logger.Errorf("error retrieving block data for block %v: %v", hashOrHeight, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendAPIResponse(w, &types.APIResponse{Data: block})
+}
+
+// APIBlocks will return all blocks within the height range given by the from and to parameters ordered by height descending
+func APIBlocks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit, err := parseAPILimit(q.Get("limit"))
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	from := int64(0)
+	if q.Get("from") != "" {
+		from, err = strconv.ParseInt(q.Get("from"), 10, 64)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid from parameter")
+			return
+		}
+	}
+
+	// Heights are stored as int, a higher upper bound is equivalent to no upper bound
+	to := int64(math.MaxInt32)
+	if q.Get("to") != "" {
+		to, err = strconv.ParseInt(q.Get("to"), 10, 64)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid to parameter")
+			return
+		}
+		if to > math.MaxInt32 {
+			to = math.MaxInt32
+		}
+	}
+
+	beforeHeight := to + 1
+	beforeHash := ""
+	if q.Get("cursor") != "" {
+		cursor, err := decodeAPICursor(q.Get("cursor"), 2)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		beforeHeight, err = strconv.ParseInt(cursor[0], 10, 64)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid cursor parameter")
+			return
+		}
+		beforeHash = cursor[1]
+	}
+
+	blocks, err := db.GetBlocks(from, to, beforeHeight, beforeHash, limit+1)
+	if err != nil {
+		logger.Errorf("error retrieving blocks between height %v and %v: %v", from, to, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if blocks == nil {
+		blocks = []*types.Block{}
+	}
+
+	resp := &types.APIResponse{Data: blocks}
+	if len(blocks) > limit {
+		last := blocks[limit-1]
+		resp.Data = blocks[:limit]
+		resp.Next = encodeAPICursor(strconv.Itoa(last.Height), last.StateHash)
+	}
+
+	sendAPIResponse(w, resp)
+}
+
+// APITransaction will return a single transaction by its id
+func APITransaction(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	tx, err := db.GetTransaction(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendAPIError(w, http.StatusNotFound, fmt.Sprintf("transaction %v not found", id))
+		return
+	}
+	if err != nil {
+		logger.Errorf("error retrieving tx data for tx %v: %v", id, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendAPIResponse(w, &types.APIResponse{Data: tx})
+}
+
+// APIAccount will return a single account by its public key
+func APIAccount(w http.ResponseWriter, r *http.Request) {
+	pk := mux.Vars(r)["pk"]
+
+	account, err := db.GetAccount(pk)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendAPIError(w, http.StatusNotFound, fmt.Sprintf("account %v not found", pk))
+		return
+	}
+	if err != nil {
+		logger.Errorf("error retrieving account data for account %v: %v", pk, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendAPIResponse(w, &types.APIResponse{Data: account})
+}
+
+// APIAccountTransactions will return the canonical transactions sent or received by an account ordered by time descending
+func APIAccountTransactions(w http.ResponseWriter, r *http.Request) {
+	pk := mux.Vars(r)["pk"]
+	q := r.URL.Query()
+
+	limit, err := parseAPILimit(q.Get("limit"))
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	beforeTs := time.Now().Add(time.Hour * 24 * 365 * 100)
+	beforeHash := ""
+	beforeID := ""
+	if q.Get("cursor") != "" {
+		cursor, err := decodeAPICursor(q.Get("cursor"), 3)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ts, err := strconv.ParseInt(cursor[0], 10, 64)
+		if err != nil {
+			sendAPIError(w, http.StatusBadRequest, "invalid cursor parameter")
+			return
+		}
+		beforeTs = time.Unix(0, ts).UTC()
+		beforeHash = cursor[1]
+		beforeID = cursor[2]
+	}
+
+	txs, err := db.GetAccountTransactions(pk, beforeTs, beforeHash, beforeID, limit+1)
+	if err != nil {
+		logger.Errorf("error retrieving tx data for account %v: %v", pk, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if txs == nil {
+		txs = []*types.TxPageData{}
+	}
+
+	resp := &types.APIResponse{Data: txs}
+	if len(txs) > limit {
+		last := txs[limit-1]
+		resp.Data = txs[:limit]
+		resp.Next = encodeAPICursor(strconv.FormatInt(last.Ts.UnixNano(), 10), last.BlockStateHash, last.ID)
+	}
+
+	sendAPIResponse(w, resp)
+}
+
+// APIAccountBalances will return the balance history of an account ordered by height, optionally limited to the time range given as unix timestamps by the from and to parameters
+func APIAccountBalances(w http.ResponseWriter, r *http.Request) {
+	pk := mux.Vars(r)["pk"]
+
+	from, to, err := parseAPITimeRange(r)
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	balances, err := db.GetAccountBalances(pk, from, to)
+	if err != nil {
+		logger.Errorf("error retrieving balance history for account %v: %v", pk, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if balances == nil {
+		balances = []*types.AccountBalance{}
+	}
+
+	sendAPIResponse(w, &types.APIResponse{Data: balances})
+}
+
+// APIStatistics will return the daily values of a statistics indicator, optionally limited to the time range given as unix timestamps by the from and to parameters
+func APIStatistics(w http.ResponseWriter, r *http.Request) {
+	indicator := strings.ToUpper(mux.Vars(r)["indicator"])
+	if !isStatisticIndicator(indicator) {
+		sendAPIError(w, http.StatusNotFound, fmt.Sprintf("unknown indicator %v", indicator))
+		return
+	}
+
+	from, to, err := parseAPITimeRange(r)
+	if err != nil {
+		sendAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	statistics, err := db.GetStatistics(indicator, from, to)
+	if err != nil {
+		logger.Errorf("error retrieving %v statistics: %v", indicator, err)
+		sendAPIError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if statistics == nil {
+		statistics = []*types.Statistic{}
+	}
+
+	sendAPIResponse(w, &types.APIResponse{Data: statistics})
+}
+
+func isStatisticIndicator(indicator string) bool {
+	for _, i := range db.StatisticIndicators {
+		if i == indicator {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the limit parameter of paginated api resources
+func parseAPILimit(limitParam string) (int, error) {
+	limit := apiDefaultLimit
+	if limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			return 0, fmt.Errorf("invalid limit parameter")
+		}
+	}
+
+	if limit > pageLimit {
+		limit = pageLimit
+	}
+	return limit, nil
+}
+
+// Parses the from and to unix timestamp parameters of api resources limited to a time range
+func parseAPITimeRange(r *http.Request) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+
+	from := time.Unix(0, 0)
+	if q.Get("from") != "" {
+		ts, err := strconv.ParseInt(q.Get("from"), 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from parameter")
+		}
+		from = time.Unix(ts, 0)
+	}
+
+	to := time.Now()
+	if q.Get("to") != "" {
+		ts, err := strconv.ParseInt(q.Get("to"), 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to parameter")
+		}
+		to = time.Unix(ts, 0)
+	}
+
+	return from.UTC(), to.UTC(), nil
+}
+
+// Encodes the sort key of the last item of a page into an opaque cursor
+func encodeAPICursor(parts ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, "|")))
+}
+
+// Decodes a cursor created by encodeAPICursor into its parts
+func decodeAPICursor(cursor string, parts int) ([]string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor parameter")
+	}
+
+	split := strings.SplitN(string(decoded), "|", parts)
+	if len(split) != parts {
+		return nil, fmt.Errorf("invalid cursor parameter")
+	}
+	return split, nil
+}
+
+func sendAPIResponse(w http.ResponseWriter, resp *types.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logger.Errorf("error encoding api response: %v", err)
+	}
+}
+
+func sendAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(&types.APIErrorResponse{Error: &types.APIError{Status: status, Message: message}})
+	if err != nil {
+		logger.Errorf("error encoding api error response: %v", err)
+	}
+}
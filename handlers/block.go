@@ -18,7 +18,6 @@ package handlers
 
 import (
 	"coda-explorer/db"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"github.com/gorilla/mux"
@@ -27,7 +26,7 @@ import (
 	"strconv"
 )
 
-var blockTemplate = template.Must(template.New("blocks").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/block.html"))
+var blockTemplate *template.Template
 
 // Block will return information about a block using a go template
 func Block(w http.ResponseWriter, r *http.Request) {
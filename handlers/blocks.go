@@ -18,7 +18,6 @@ package handlers
 
 import (
 	"coda-explorer/db"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"encoding/json"
@@ -27,7 +26,7 @@ import (
 	"strconv"
 )
 
-var blocksTemplate = template.Must(template.New("blocks").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/blocks.html"))
+var blocksTemplate *template.Template
 
 // Blocks will return information about blocks using a go template
 func Blocks(w http.ResponseWriter, r *http.Request) {
@@ -79,13 +78,13 @@ func BlocksData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", 503)
 		return
 	}
-	if length > 100 {
-		length = 100
+	if length > int64(pageLimit) {
+		length = int64(pageLimit)
 	}
 
 	var blocksCount int64
 
-	err = db.DB.Get(&blocksCount, "SELECT MAX(height) FROM blocks")
+	err = db.DB.Get("handlers.BlocksData", &blocksCount, "SELECT MAX(height) FROM blocks")
 	if err != nil {
 		logger.Errorf("error retrieving max slot number: %v", err)
 		http.Error(w, "Internal server error", 503)
@@ -99,7 +98,7 @@ func BlocksData(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info(startHeight, endHeight)
 
-	err = db.DB.Select(&blocks, `SELECT *
+	err = db.DB.Select("handlers.BlocksData", &blocks, `SELECT *
 										FROM blocks 
 										WHERE blocks.height >= $1 AND blocks.height <= $2
 										ORDER BY blocks.height DESC, canonical DESC`, endHeight, startHeight)
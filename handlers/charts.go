@@ -19,7 +19,6 @@ package handlers
 import (
 	"coda-explorer/db"
 	"coda-explorer/services"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"fmt"
@@ -30,7 +29,7 @@ import (
 )
 
 // ChartBlocks will return information about the daily produced blocks using a go template
-var chartsTemplate = template.Must(template.New("blocks").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/charts.html"))
+var chartsTemplate *template.Template
 
 // Charts returns the main chart view
 func Charts(w http.ResponseWriter, r *http.Request) {
@@ -40,7 +39,7 @@ func Charts(w http.ResponseWriter, r *http.Request) {
 	pageData := &types.ChartsPageData{
 		Peers: make(map[string]*types.PeerInfoPageData),
 	}
-	err := db.DB.Select(&pageData.Statistics, "SELECT * FROM statistics ORDER BY ts, indicator")
+	err := db.DB.Select("handlers.Charts", &pageData.Statistics, "SELECT * FROM statistics ORDER BY ts, indicator")
 	if err != nil {
 		logger.Errorf("error retrieving statistcs data for route %v: %v", r.URL.String(), err)
 		http.Error(w, "Internal server error", 503)
@@ -48,7 +47,7 @@ func Charts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var peers pq.StringArray
-	err = db.DB.Get(&peers, "SELECT peers FROM daemonstatus ORDER BY ts DESC LIMIT 1")
+	err = db.DB.Get("handlers.Charts", &peers, "SELECT peers FROM daemonstatus ORDER BY ts DESC LIMIT 1")
 
 	for _, peer := range peers {
 		ip, _, err := net.SplitHostPort(peer)
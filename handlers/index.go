@@ -18,7 +18,6 @@ package handlers
 
 import (
 	"coda-explorer/services"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"encoding/json"
@@ -27,7 +26,7 @@ import (
 	"time"
 )
 
-var indexTemplate = template.Must(template.New("index").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/index.html"))
+var indexTemplate *template.Template
 
 // Index will return the main "index" page using a go template
 func Index(w http.ResponseWriter, r *http.Request) {
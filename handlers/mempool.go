@@ -0,0 +1,70 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package handlers
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/types"
+	"coda-explorer/version"
+	"html/template"
+	"net/http"
+)
+
+var mempoolTemplate *template.Template
+
+// Mempool will return the pending and recently dropped transactions of the mempool using a go template
+func Mempool(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+
+	data := &types.PageData{
+		Meta: &types.Meta{
+			Title:       "Mempool - Coda Blockchain Explorer by bitfly",
+			Description: "",
+			Path:        "",
+		},
+		ShowSyncingMessage: false,
+		Active:             "mempool",
+		Version:            version.Version,
+	}
+
+	pending, err := db.GetMempoolTransactions(types.MempoolStatusPending, 100)
+	if err != nil {
+		logger.Errorf("error retrieving pending transactions: %v", err)
+		http.Error(w, "Internal server error", 503)
+		return
+	}
+
+	dropped, err := db.GetMempoolTransactions(types.MempoolStatusDropped, 25)
+	if err != nil {
+		logger.Errorf("error retrieving dropped transactions: %v", err)
+		http.Error(w, "Internal server error", 503)
+		return
+	}
+
+	data.Data = &types.MempoolPageData{
+		Pending: pending,
+		Dropped: dropped,
+	}
+
+	err = mempoolTemplate.ExecuteTemplate(w, "layout", data)
+
+	if err != nil {
+		logger.Errorf("error executing template for %v route: %v", r.URL.String(), err)
+		http.Error(w, "Internal server error", 503)
+		return
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package handlers
+
+import (
+	"coda-explorer/metrics"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+)
+
+var (
+	httpRequestsCounter = metrics.NewCounterVec("coda_frontend_http_requests_total", "Number of http requests by route, method and status code", "route", "method", "status")
+	httpRequestDuration = metrics.NewHistogramVec("coda_frontend_http_request_duration_seconds", "Duration of http requests by route and method, streams are observed when they end", metrics.DurationBuckets, "route", "method")
+)
+
+// HTTPMetrics is a router middleware counting the requests and observing their duration labelled by the path
+// template of the matched route
+func HTTPMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		rw := negroni.NewResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		status := rw.Status()
+		if status == 0 {
+			// Hijacked connections, e.g. websocket streams
+			status = http.StatusSwitchingProtocols
+		}
+		httpRequestsCounter.Inc(route, r.Method, strconv.Itoa(status))
+		httpRequestDuration.ObserveSince(start, route, r.Method)
+	})
+}
@@ -0,0 +1,194 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package handlers
+
+import (
+	"coda-explorer/openapi"
+	"coda-explorer/types"
+	"coda-explorer/version"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+var openAPISpec = newOpenAPISpec()
+
+// OpenAPISpec returns the OpenAPI 3 document describing all json routes of the frontend
+func OpenAPISpec() *openapi.Document {
+	return openAPISpec
+}
+
+// OpenAPI will return the OpenAPI 3 document describing all json routes of the frontend
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(openAPISpec)
+	if err != nil {
+		logger.Errorf("error enconding json response for %v route: %v", r.URL.String(), err)
+		http.Error(w, "Internal server error", 503)
+		return
+	}
+}
+
+func newOpenAPISpec() *openapi.Document {
+	doc := openapi.New("Coda Blockchain Explorer", "JSON endpoints of the coda blockchain explorer. All coda amounts are encoded as strings in nanocoda.", version.Version)
+
+	gen := openapi.NewGenerator(doc)
+	gen.Define(types.Amount{}, &openapi.Schema{Type: "string", Pattern: "^-?[0-9]+$", Description: "Amount in nanocoda"})
+
+	dataTable := map[string]*openapi.Response{
+		"200": openapi.JSONResponse("DataTables server side processing response, rows contain the columns of the table as positional values", gen.SchemaOf(types.DataTableResponse{})),
+		"503": {Description: "Invalid request parameters or internal error"},
+	}
+	dataTableParams := []*openapi.Parameter{
+		queryParam("draw", "DataTables draw counter", true, &openapi.Schema{Type: "integer"}),
+		queryParam("start", "Index of the first row", true, &openapi.Schema{Type: "integer"}),
+		queryParam("length", fmt.Sprintf("Number of rows, at most %v", pageLimit), true, &openapi.Schema{Type: "integer"}),
+	}
+	pk := pathParam("pk", "Public key of the account")
+
+	doc.AddOperation("GET", "/openapi.json", &openapi.Operation{
+		OperationID: "getOpenAPISpec",
+		Summary:     "This document",
+		Responses: map[string]*openapi.Response{
+			"200": openapi.JSONResponse("OpenAPI 3 document", &openapi.Schema{Type: "object"}),
+		},
+	})
+	doc.AddOperation("GET", "/index/data", &openapi.Operation{
+		OperationID: "getIndexPageData",
+		Summary:     "Summary of the most recent blockchain information as shown on the index page",
+		Responses: map[string]*openapi.Response{
+			"200": openapi.JSONResponse("Index page data", gen.SchemaOf(types.IndexPageData{})),
+		},
+	})
+	doc.AddOperation("GET", "/blocks/data", &openapi.Operation{
+		OperationID: "getBlocksTable",
+		Summary:     "Rows of the blocks table: canonical, height, epoch, slot, unix timestamp, creator, state hash, user commands count, snark jobs count, coinbase",
+		Parameters:  dataTableParams,
+		Responses:   dataTable,
+	})
+	doc.AddOperation("GET", "/accounts/data", &openapi.Operation{
+		OperationID: "getAccountsTable",
+		Summary:     "Rows of the accounts table: public key, balance, first seen unix timestamp, last seen unix timestamp, blocks proposed, snark jobs, transactions sent, transactions received",
+		Parameters: append(dataTableParams,
+			queryParam("order[0][column]", "Index of the column to order by", false, &openapi.Schema{Type: "integer"}),
+			queryParam("order[0][dir]", "Order direction, asc or desc", false, &openapi.Schema{Type: "string"}),
+		),
+		Responses: dataTable,
+	})
+	doc.AddOperation("GET", "/account/{pk}/data_blocks", &openapi.Operation{
+		OperationID: "getAccountBlocksTable",
+		Summary:     "Rows of the table of blocks proposed by an account, with the same columns as the blocks table",
+		Parameters:  append([]*openapi.Parameter{pk}, dataTableParams...),
+		Responses:   dataTable,
+	})
+	doc.AddOperation("GET", "/account/{pk}/data_txs", &openapi.Operation{
+		OperationID: "getAccountTransactionsTable",
+		Summary:     "Rows of the table of transactions sent or received by an account: empty, id, unix timestamp, height, sender, recipient, amount, fee, delegation, block state hash",
+		Parameters:  append([]*openapi.Parameter{pk}, dataTableParams...),
+		Responses:   dataTable,
+	})
+	doc.AddOperation("GET", "/account/{pk}/data_snarkjobs", &openapi.Operation{
+		OperationID: "getAccountSnarkJobsTable",
+		Summary:     "Rows of the table of snark jobs performed by an account: job ids, prover, fee, unix timestamp, height, block state hash",
+		Parameters:  append([]*openapi.Parameter{pk}, dataTableParams...),
+		Responses:   dataTable,
+	})
+
+	apiError := gen.SchemaOf(types.APIErrorResponse{})
+	apiResponses := func(data *openapi.Schema, paginated bool) map[string]*openapi.Response {
+		envelope := &openapi.Schema{
+			Type:                 "object",
+			Properties:           map[string]*openapi.Schema{"data": data},
+			Required:             []string{"data"},
+			AdditionalProperties: false,
+		}
+		if paginated {
+			envelope.Properties["next"] = &openapi.Schema{Type: "string", Description: "Cursor of the following page, missing on the last page"}
+		}
+		return map[string]*openapi.Response{
+			"200": openapi.JSONResponse("Successful response", envelope),
+			"400": openapi.JSONResponse("Invalid request parameters", apiError),
+			"404": openapi.JSONResponse("Resource not found", apiError),
+			"500": openapi.JSONResponse("Internal error", apiError),
+		}
+	}
+	pageParams := []*openapi.Parameter{
+		queryParam("limit", fmt.Sprintf("Maximum number of results, at most %v", pageLimit), false, &openapi.Schema{Type: "integer"}),
+		queryParam("cursor", "Cursor returned as next by the previous page", false, &openapi.Schema{Type: "string"}),
+	}
+
+	doc.AddOperation("GET", "/api/v1/blocks", &openapi.Operation{
+		OperationID: "listBlocks",
+		Summary:     "Blocks within a height range, newest first",
+		Parameters: append([]*openapi.Parameter{
+			queryParam("from", "Lowest height (inclusive)", false, &openapi.Schema{Type: "integer"}),
+			queryParam("to", "Highest height (inclusive)", false, &openapi.Schema{Type: "integer"}),
+		}, pageParams...),
+		Responses: apiResponses(gen.SchemaOf([]*types.Block{}), true),
+	})
+	doc.AddOperation("GET", "/api/v1/blocks/{hashOrHeight}", &openapi.Operation{
+		OperationID: "getBlock",
+		Summary:     "A single block by state hash or canonical height including its transactions, snark jobs and fee transfers",
+		Parameters:  []*openapi.Parameter{pathParam("hashOrHeight", "State hash or canonical height of the block")},
+		Responses:   apiResponses(gen.SchemaOf(types.Block{}), false),
+	})
+	doc.AddOperation("GET", "/api/v1/transactions/{id}", &openapi.Operation{
+		OperationID: "getTransaction",
+		Summary:     "A single transaction",
+		Parameters:  []*openapi.Parameter{pathParam("id", "Id of the transaction")},
+		Responses:   apiResponses(gen.SchemaOf(types.TxPageData{}), false),
+	})
+	doc.AddOperation("GET", "/api/v1/accounts/{pk}", &openapi.Operation{
+		OperationID: "getAccount",
+		Summary:     "A single account",
+		Parameters:  []*openapi.Parameter{pk},
+		Responses:   apiResponses(gen.SchemaOf(types.Account{}), false),
+	})
+	doc.AddOperation("GET", "/api/v1/accounts/{pk}/transactions", &openapi.Operation{
+		OperationID: "listAccountTransactions",
+		Summary:     "Canonical transactions sent or received by an account, newest first",
+		Parameters:  append([]*openapi.Parameter{pk}, pageParams...),
+		Responses:   apiResponses(gen.SchemaOf([]*types.TxPageData{}), true),
+	})
+	timeRangeParams := []*openapi.Parameter{
+		queryParam("from", "Start of the time range as unix timestamp", false, &openapi.Schema{Type: "integer"}),
+		queryParam("to", "End of the time range as unix timestamp", false, &openapi.Schema{Type: "integer"}),
+	}
+	doc.AddOperation("GET", "/api/v1/accounts/{pk}/balances", &openapi.Operation{
+		OperationID: "listAccountBalances",
+		Summary:     "Balance of an account after each canonical block touching it, ordered by height",
+		Parameters:  append([]*openapi.Parameter{pk}, timeRangeParams...),
+		Responses:   apiResponses(gen.SchemaOf([]*types.AccountBalance{}), false),
+	})
+	doc.AddOperation("GET", "/api/v1/statistics/{indicator}", &openapi.Operation{
+		OperationID: "getStatistics",
+		Summary:     "Daily values of a statistics indicator",
+		Parameters:  append([]*openapi.Parameter{pathParam("indicator", "Name of the indicator, e.g. BLOCK_COUNT, unknown indicators are not found")}, timeRangeParams...),
+		Responses:   apiResponses(gen.SchemaOf([]*types.Statistic{}), false),
+	})
+
+	return doc
+}
+
+func pathParam(name, description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "path", Description: description, Required: true, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func queryParam(name, description string, required bool, schema *openapi.Schema) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Required: required, Schema: schema}
+}
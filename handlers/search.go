@@ -35,19 +35,19 @@ func Search(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var resCount int
-	err = db.DB.Get(&resCount, "SELECT COUNT(*) FROM blocks WHERE statehash = $1", search)
+	err = db.DB.Get("handlers.Search", &resCount, "SELECT COUNT(*) FROM blocks WHERE statehash = $1", search)
 	if resCount > 0 && err == nil {
 		http.Redirect(w, r, "/block/"+search, 301)
 		return
 	}
 
-	err = db.DB.Get(&resCount, "SELECT COUNT(*) FROM userjobs WHERE id = $1", search)
+	err = db.DB.Get("handlers.Search", &resCount, "SELECT COUNT(*) FROM userjobs WHERE id = $1", search)
 	if resCount > 0 && err == nil {
 		http.Redirect(w, r, "/tx/"+search, 301)
 		return
 	}
 
-	err = db.DB.Get(&resCount, "SELECT COUNT(*) FROM accounts WHERE publickey = $1", search)
+	err = db.DB.Get("handlers.Search", &resCount, "SELECT COUNT(*) FROM accounts WHERE publickey = $1", search)
 	if resCount > 0 && err == nil {
 		http.Redirect(w, r, "/account/"+search, 301)
 		return
@@ -18,14 +18,13 @@ package handlers
 
 import (
 	"coda-explorer/db"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
 	"html/template"
 	"net/http"
 )
 
-var statusTemplate = template.Must(template.New("index").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/status.html"))
+var statusTemplate *template.Template
 
 // Status will return the "status" page using a go template
 func Status(w http.ResponseWriter, r *http.Request) {
@@ -43,7 +42,7 @@ func Status(w http.ResponseWriter, r *http.Request) {
 	}
 
 	status := &types.DaemonStatus{}
-	err := db.DB.Get(status, "SELECT * FROM daemonstatus ORDER BY ts DESC limit 1")
+	err := db.DB.Get("handlers.Status", status, "SELECT * FROM daemonstatus ORDER BY ts DESC limit 1")
 	if err != nil {
 		logger.Errorf("error retrieving latest daemon status: %v", err)
 		http.Error(w, "Internal server error", 503)
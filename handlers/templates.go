@@ -0,0 +1,53 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package handlers
+
+import (
+	"coda-explorer/templates"
+	"fmt"
+	"html/template"
+)
+
+// LoadTemplates parses the templates of all html pages, it has to be called before the handlers are used
+func LoadTemplates() error {
+	var err error
+
+	pages := []struct {
+		tmpl  **template.Template
+		name  string
+		files []string
+	}{
+		{&indexTemplate, "index", []string{"templates/layout.html", "templates/index.html"}},
+		{&blockTemplate, "blocks", []string{"templates/layout.html", "templates/block.html"}},
+		{&blocksTemplate, "blocks", []string{"templates/layout.html", "templates/blocks.html"}},
+		{&txTemplate, "blocks", []string{"templates/layout.html", "templates/tx.html"}},
+		{&accountTemplate, "blocks", []string{"templates/layout.html", "templates/account.html"}},
+		{&accountsTemplate, "blocks", []string{"templates/layout.html", "templates/accounts.html"}},
+		{&chartsTemplate, "blocks", []string{"templates/layout.html", "templates/charts.html"}},
+		{&statusTemplate, "index", []string{"templates/layout.html", "templates/status.html"}},
+		{&mempoolTemplate, "index", []string{"templates/layout.html", "templates/mempool.html"}},
+	}
+
+	for _, p := range pages {
+		*p.tmpl, err = template.New(p.name).Funcs(templates.GetTemplateFuncs()).ParseFiles(p.files...)
+		if err != nil {
+			return fmt.Errorf("error parsing templates %v: %w", p.files, err)
+		}
+	}
+
+	return nil
+}
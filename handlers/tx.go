@@ -18,15 +18,17 @@ package handlers
 
 import (
 	"coda-explorer/db"
-	"coda-explorer/templates"
 	"coda-explorer/types"
 	"coda-explorer/version"
+	"database/sql"
+	"errors"
 	"github.com/gorilla/mux"
 	"html/template"
 	"net/http"
+	"time"
 )
 
-var txTemplate = template.Must(template.New("blocks").Funcs(templates.GetTemplateFuncs()).ParseFiles("templates/layout.html", "templates/tx.html"))
+var txTemplate *template.Template
 
 // Tx will return information about a transaction using a go template
 func Tx(w http.ResponseWriter, r *http.Request) {
@@ -49,12 +51,47 @@ func Tx(w http.ResponseWriter, r *http.Request) {
 	hash := vars["hash"]
 	tx := &types.TxPageData{}
 
-	err := db.DB.Get(tx, "SELECT userjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts FROM userjobs LEFT JOIN blocks ON userjobs.blockstatehash = blocks.statehash WHERE id = $1 AND userjobs.canonical", hash)
+	err := db.DB.Get("handlers.Tx", tx, "SELECT userjobs.*, blocks.height, blocks.slot, blocks.epoch, blocks.ts FROM userjobs LEFT JOIN blocks ON userjobs.blockstatehash = blocks.statehash WHERE id = $1 AND userjobs.canonical", hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The transaction has not been included in a canonical block, check whether it is known to the mempool
+		var mempoolTx *types.MempoolTransaction
+		mempoolTx, err = db.GetMempoolTransaction(hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			tx = &types.TxPageData{
+				ID:               mempoolTx.ID,
+				Sender:           mempoolTx.Sender,
+				Recipient:        mempoolTx.Recipient,
+				Memo:             mempoolTx.Memo,
+				Fee:              mempoolTx.Fee,
+				Amount:           mempoolTx.Amount,
+				Nonce:            mempoolTx.Nonce,
+				Delegation:       mempoolTx.Delegation,
+				MempoolStatus:    mempoolTx.Status,
+				MempoolFirstSeen: mempoolTx.FirstSeen,
+			}
+		}
+	}
 	if err != nil {
 		logger.Errorf("error retrieving tx data for tx %v: %v", hash, err)
 		http.Error(w, "Internal server error", 503)
 		return
 	}
+
+	if tx.MempoolStatus == "" {
+		latency, err := db.GetTransactionLatency(hash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logger.Errorf("error retrieving latency data for tx %v: %v", hash, err)
+			http.Error(w, "Internal server error", 503)
+			return
+		}
+		if err == nil {
+			setTxLatency(tx, latency)
+		}
+	}
 	data.Data = tx
 
 	err = txTemplate.ExecuteTemplate(w, "layout", data)
@@ -65,3 +102,22 @@ func Tx(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// Sets the inclusion and confirmation delays of a transaction, the mempool and block timestamps are taken from
+// different clocks so negative delays are reported as zero
+func setTxLatency(tx *types.TxPageData, latency *types.TxLatency) {
+	tx.MempoolFirstSeen = latency.FirstSeen
+	tx.ConfirmationDepth = types.TxConfirmationDepth
+	tx.Confirmations = latency.Confirmations
+	tx.InclusionDelay = nonNegative(latency.IncludedTs.Sub(latency.FirstSeen)).Round(time.Second)
+	if latency.ConfirmedTs.Valid {
+		tx.ConfirmationDelay = nonNegative(latency.ConfirmedTs.Time.Sub(latency.FirstSeen)).Round(time.Second)
+	}
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,130 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package indexer
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/ledger"
+	"coda-explorer/types"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Derives the state of the accounts touched by a block after the block has been applied. The state reported by the node
+// is used if it has been read while the block was the best tip, otherwise the state is derived by replaying the block
+// against the previous known state of each account on the chain of the block. Accounts without a previous known state
+// are skipped. Returns the states as well as the discrepancies between reported and derived states.
+func deriveAccountStates(block *types.Block, accounts map[string]*types.Account, atTip bool) ([]*types.AccountBalance, []*types.AccountDiscrepancy, error) {
+	publicKeys := make([]string, 0, len(accounts))
+	for pk := range accounts {
+		publicKeys = append(publicKeys, pk)
+	}
+	sort.Strings(publicKeys)
+
+	previous, err := db.GetPreviousAccountStates(publicKeys, block.PreviousStateHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	states, discrepancies := accountStates(block, publicKeys, accounts, previous, atTip)
+
+	for _, d := range discrepancies {
+		logger.Warnf("account %v differs from its derived state at block %v: %v is %v but has been derived as %v", d.PublicKey, d.BlockStateHash, d.Field, d.Node, d.Derived)
+	}
+
+	return states, discrepancies, nil
+}
+
+// Computes the states of the given accounts after a block from the previous states of the accounts. The reported
+// states are only used if they have been read while the block was the best tip, as they do not belong to the block otherwise.
+func accountStates(block *types.Block, publicKeys []string, accounts map[string]*types.Account, previous map[string]*types.AccountBalance, atTip bool) ([]*types.AccountBalance, []*types.AccountDiscrepancy) {
+	derived := replayBlock(block, previous)
+
+	var states []*types.AccountBalance
+	var discrepancies []*types.AccountDiscrepancy
+	for _, pk := range publicKeys {
+		if !atTip {
+			if derived[pk] != nil {
+				states = append(states, derived[pk])
+			}
+			continue
+		}
+
+		reported := accounts[pk]
+		state := &types.AccountBalance{
+			PublicKey:      pk,
+			BlockStateHash: block.StateHash,
+			Height:         block.Height,
+			Balance:        reported.Balance,
+			Nonce:          reported.Nonce,
+			Ts:             block.Ts,
+			Delegate:       reported.Delegate,
+		}
+		states = append(states, state)
+
+		if derived[pk] != nil {
+			discrepancies = append(discrepancies, compareAccountStates(derived[pk], state)...)
+		}
+	}
+	return states, discrepancies
+}
+
+// Applies a block to the given previous account states and returns the resulting states of the touched accounts.
+// Accounts missing from the previous states are not included in the result.
+func replayBlock(block *types.Block, previous map[string]*types.AccountBalance) map[string]*types.AccountBalance {
+	states := make(map[string]*types.AccountBalance)
+	state := func(pk string) *types.AccountBalance {
+		if states[pk] == nil && previous[pk] != nil {
+			s := *previous[pk]
+			s.BlockStateHash = block.StateHash
+			s.Height = block.Height
+			s.Canonical = false
+			s.Ts = block.Ts
+			s.Derived = true
+			states[pk] = &s
+		}
+		return states[pk]
+	}
+
+	ledger.ApplyBlock(block, state)
+
+	return states
+}
+
+// Returns a discrepancy for every field that differs between a derived and a reported account state
+func compareAccountStates(derived, reported *types.AccountBalance) []*types.AccountDiscrepancy {
+	var discrepancies []*types.AccountDiscrepancy
+	add := func(field, derivedValue, reportedValue string) {
+		if derivedValue == reportedValue {
+			return
+		}
+		discrepancies = append(discrepancies, &types.AccountDiscrepancy{
+			PublicKey:      reported.PublicKey,
+			BlockStateHash: reported.BlockStateHash,
+			Height:         reported.Height,
+			Field:          field,
+			Derived:        derivedValue,
+			Node:           reportedValue,
+			DetectedAt:     time.Now(),
+		})
+	}
+
+	add("balance", derived.Balance.String(), reported.Balance.String())
+	add("nonce", strconv.Itoa(derived.Nonce), strconv.Itoa(reported.Nonce))
+	add("delegate", derived.Delegate, reported.Delegate)
+	return discrepancies
+}
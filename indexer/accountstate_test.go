@@ -0,0 +1,90 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package indexer
+
+import (
+	"coda-explorer/rpc/fake"
+	"coda-explorer/types"
+	"testing"
+	"time"
+)
+
+func TestAccountStates(t *testing.T) {
+	parent := fake.NewBlock("fake-0", "1", 1, 1, time.Now(), "creator")
+	block := fake.NewBlock(parent.StateHash, "2", 2, 2, time.Now(), "creator")
+	block.UserJobs = []*types.UserJob{{ID: "tx", Sender: "sender", Recipient: "creator", Fee: types.NewAmount(10), Amount: types.NewAmount(1000), Nonce: 4}}
+	block.FeeTransfers = []*types.FeeTransfer{{Recipient: "creator", Fee: types.NewAmount(10)}}
+
+	// Current state of the accounts as reported by the node, the balance of the creator differs from the replayed state
+	accounts := map[string]*types.Account{
+		"creator": {PublicKey: "creator", Balance: types.NewAmount(999), Nonce: 0, Delegate: "creator"},
+		"sender":  {PublicKey: "sender", Balance: types.NewAmount(8990), Nonce: 5, Delegate: "sender"},
+	}
+	previous := map[string]*types.AccountBalance{
+		"sender": {PublicKey: "sender", BlockStateHash: parent.StateHash, Height: 1, Canonical: true, Balance: types.NewAmount(10000), Nonce: 4, Delegate: "sender"},
+	}
+	publicKeys := []string{"creator", "sender"}
+
+	t.Run("at tip", func(t *testing.T) {
+		states, discrepancies := accountStates(block, publicKeys, accounts, previous, true)
+		if len(states) != 2 {
+			t.Fatalf("%v states recorded, expected the reported state of both accounts", len(states))
+		}
+		for _, s := range states {
+			if s.Derived || s.BlockStateHash != block.StateHash || s.Height != block.Height || s.Balance.Cmp(accounts[s.PublicKey].Balance) != 0 {
+				t.Errorf("unexpected state %+v", s)
+			}
+		}
+		// The replayed nonce of the sender is 5, its balance 10000 - 10 - 1000
+		if len(discrepancies) != 0 {
+			t.Errorf("unexpected discrepancies %+v", discrepancies[0])
+		}
+	})
+
+	t.Run("below tip", func(t *testing.T) {
+		states, discrepancies := accountStates(block, publicKeys, accounts, previous, false)
+		// The reported state is the state after a later block, only the replayed state of the sender is recorded
+		if len(states) != 1 {
+			t.Fatalf("%v states recorded, expected the derived state of the sender only", len(states))
+		}
+		s := states[0]
+		if s.PublicKey != "sender" || !s.Derived || s.Height != block.Height || s.BlockStateHash != block.StateHash || s.Balance.String() != "8990" || s.Nonce != 5 {
+			t.Errorf("unexpected state %+v", s)
+		}
+		if len(discrepancies) != 0 {
+			t.Errorf("unexpected discrepancies below tip %+v", discrepancies)
+		}
+	})
+
+	t.Run("discrepancy", func(t *testing.T) {
+		reported := map[string]*types.Account{
+			"creator": accounts["creator"],
+			"sender":  {PublicKey: "sender", Balance: types.NewAmount(9000), Nonce: 5, Delegate: "other"},
+		}
+		_, discrepancies := accountStates(block, publicKeys, reported, previous, true)
+		fields := make(map[string]*types.AccountDiscrepancy)
+		for _, d := range discrepancies {
+			fields[d.Field] = d
+		}
+		if len(discrepancies) != 2 || fields["balance"] == nil || fields["delegate"] == nil {
+			t.Fatalf("unexpected discrepancies %+v", discrepancies)
+		}
+		if fields["balance"].Derived != "8990" || fields["balance"].Node != "9000" || fields["delegate"].Derived != "sender" || fields["delegate"].Node != "other" {
+			t.Errorf("unexpected discrepancies %+v, %+v", fields["balance"], fields["delegate"])
+		}
+	})
+}
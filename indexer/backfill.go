@@ -0,0 +1,151 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package indexer
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/rpc"
+	"coda-explorer/types"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Periodically searches the database for missing blocks and fills them in
+func backfillBlocks(ctx context.Context, client rpc.NodeClient, intv time.Duration) {
+	for {
+		err := backfill(ctx, client)
+		if err != nil && ctx.Err() == nil {
+			logger.Errorf("error backfilling blocks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(intv):
+		}
+	}
+}
+
+// Fills all gaps in the canonical chain by walking it backwards from the lowest canonical block above each gap.
+// A previously interrupted backfill is resumed from the persisted checkpoint.
+func backfill(ctx context.Context, client rpc.NodeClient) error {
+	checkpoint, err := db.GetBackfillCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	if checkpoint != nil {
+		logger.Infof("resuming backfill at block %v", checkpoint.StateHash)
+		err = walkBack(ctx, client, checkpoint.StateHash, checkpoint.Canonical)
+		if err != nil {
+			return err
+		}
+	}
+
+	blocks, err := db.GetBlocksWithMissingParent()
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		logger.Infof("backfilling chain below block %v at height %v", block.StateHash, block.Height)
+		err = walkBack(ctx, client, block.PreviousStateHash, block.Canonical)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("backfill completed")
+	return nil
+}
+
+// Exports blocks starting at the given state hash following the parent hashes until a block is reached that
+// is already present in the database, the genesis block has been exported or a block is unknown to the node. The
+// checkpoint is kept if the context is done before, so that the walk is resumed by the next backfill.
+func walkBack(ctx context.Context, client rpc.NodeClient, stateHash string, canonical bool) error {
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		exists, err := db.BlockExists(stateHash)
+		if err != nil {
+			return err
+		}
+		if exists {
+			break
+		}
+
+		block, err := client.GetBlock(ctx, stateHash)
+		if errors.Is(err, rpc.ErrNotFound) {
+			// Retrying would fail the same way on every backfill, the chain below the block cannot be retrieved
+			logger.Warnf("block %v is unknown to the rpc node, stopping backfill", stateHash)
+			break
+		}
+		var invalid *rpc.InvalidBlock
+		if errors.As(err, &invalid) {
+			quarantineBlock(invalid)
+			if invalid.Height == 1 || invalid.PreviousStateHash == "" {
+				break
+			}
+			// Skip the invalid block and continue with its parent
+			stateHash = invalid.PreviousStateHash
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error retrieving block %v from the rpc node: %w", stateHash, err)
+		}
+
+		checkBlockMux.Lock()
+		err = exportBackfilledBlock(block)
+		if err == nil && canonical {
+			err = db.MarkBlockCanonical(block)
+		}
+		checkBlockMux.Unlock()
+		if err != nil {
+			return fmt.Errorf("error exporting block %v at height %v: %w", block.StateHash, block.Height, err)
+		}
+
+		if block.Height <= 1 {
+			break
+		}
+		stateHash = block.PreviousStateHash
+
+		err = db.SaveBackfillCheckpoint(&types.BackfillCheckpoint{
+			StateHash: stateHash,
+			Canonical: canonical,
+			Updated:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.DeleteBackfillCheckpoint()
+}
+
+// Exports a block retrieved by the backfill without the accounts it touched. The node reports the current state of the
+// accounts only, which does not belong to a block below its best tip, and the states before the block are unknown
+// while the chain is walked backwards. Neither the accounts nor their states after the block are recorded therefore.
+func exportBackfilledBlock(block *types.Block) error {
+	exists, err := db.BlockExists(block.StateHash)
+	if err != nil {
+		return err
+	}
+	return commitBlock(&preparedBlock{block: block, exists: exists, backfilled: true})
+}
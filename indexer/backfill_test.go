@@ -0,0 +1,157 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package indexer
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/rpc"
+	"coda-explorer/rpc/fake"
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+// The backfill stops at the first block the node does not know and does not leave a checkpoint behind
+func TestBackfillStopsAtUnknownBlock(t *testing.T) {
+	setupTestDB(t)
+	node, client, blocks := newTestNode(t, 6)
+	defer node.Close()
+
+	ctx := context.Background()
+	checkBlocks(ctx, client, 3)
+	for _, b := range blocks[:3] {
+		exists, err := db.BlockExists(b.StateHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Fatalf("block %v at height %v has been exported by the startup check", b.StateHash, b.Height)
+		}
+	}
+
+	// A node that has been bootstrapped from the block at height 3
+	pruned := fake.NewNode()
+	defer pruned.Close()
+	for _, b := range blocks[2:] {
+		err := pruned.AddBlock(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := backfill(ctx, rpc.NewCodaClient(pruned.Host()))
+	if err != nil {
+		t.Fatalf("error backfilling blocks: %v", err)
+	}
+
+	assertBlock(t, blocks[2].StateHash, true)
+	for _, b := range blocks[:2] {
+		exists, err := db.BlockExists(b.StateHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Errorf("block %v at height %v is unknown to the node but has been exported", b.StateHash, b.Height)
+		}
+	}
+
+	checkpoint, err := db.GetBackfillCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint != nil {
+		t.Errorf("backfill checkpoint %v has not been removed", checkpoint.StateHash)
+	}
+}
+
+// Orphaned blocks with a missing parent are not backfilled
+func TestBackfillSkipsOrphanedBlocks(t *testing.T) {
+	setupTestDB(t)
+	node, client, blocks := newTestNode(t, 6)
+	defer node.Close()
+
+	ctx := context.Background()
+	fork, err := node.Extend(blocks[1].StateHash, 2, testCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Export the tip of the fork without its parent, it becomes orphaned once the best chain is checked
+	err = exportBlock(ctx, fork[1], client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(ctx, client, 10)
+	assertBlock(t, fork[1].StateHash, false)
+
+	err = backfill(ctx, client)
+	if err != nil {
+		t.Fatalf("error backfilling blocks: %v", err)
+	}
+
+	exists, err := db.BlockExists(fork[0].StateHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("parent %v of the orphaned block %v has been backfilled", fork[0].StateHash, fork[1].StateHash)
+	}
+}
+
+// Backfilled blocks are exported without the accounts they touched, neither the current account states reported by
+// the node nor states at the backfilled heights are recorded
+func TestBackfillSkipsAccounts(t *testing.T) {
+	setupTestDB(t)
+	node, nodeClient, blocks := newTestNode(t, 6)
+	defer node.Close()
+	client := &cancellingClient{NodeClient: nodeClient, cancel: func() {}}
+
+	ctx := context.Background()
+	checkBlocks(ctx, client, 2)
+	calls := atomic.LoadInt32(&client.calls)
+	account, err := db.GetAccount(testCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = backfill(ctx, client)
+	if err != nil {
+		t.Fatalf("error backfilling blocks: %v", err)
+	}
+	for _, b := range blocks {
+		assertBlock(t, b.StateHash, true)
+	}
+
+	if backfillCalls := atomic.LoadInt32(&client.calls) - calls; backfillCalls != 0 {
+		t.Errorf("expected no account requests by the backfill, got %v", backfillCalls)
+	}
+	backfilled, err := db.GetAccount(testCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backfilled.FirstSeen.Equal(account.FirstSeen) {
+		t.Errorf("expected account first seen at %v to be kept, got %v", account.FirstSeen, backfilled.FirstSeen)
+	}
+
+	var count int
+	err = db.DB.Get("TestBackfillSkipsAccounts", &count, "SELECT count(*) FROM account_balances WHERE height <= $1", blocks[3].Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no account states at backfilled heights, got %v", count)
+	}
+}
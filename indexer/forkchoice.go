@@ -0,0 +1,173 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package indexer
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/types"
+	"fmt"
+)
+
+// forkTree caches the blocks visited while walking from a tip back to the canonical chain, indexed by state hash.
+// Parent links are followed via the previous state hash of each block, blocks are loaded on demand.
+type forkTree struct {
+	nodes map[string]*types.BlockHashNumber
+	load  func(hash string) (*types.BlockHashNumber, error)
+}
+
+// Creates an empty fork tree loading blocks from the database
+func newForkTree() *forkTree {
+	return &forkTree{
+		nodes: make(map[string]*types.BlockHashNumber),
+		load:  db.GetBlockHashNumber,
+	}
+}
+
+func (t *forkTree) add(block *types.BlockHashNumber) {
+	t.nodes[block.StateHash] = block
+}
+
+// Returns the block with the given hash, loading it if it has not been visited yet
+func (t *forkTree) get(hash string) (*types.BlockHashNumber, error) {
+	block, exists := t.nodes[hash]
+	if exists {
+		return block, nil
+	}
+
+	block, err := t.load(hash)
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		t.add(block)
+	}
+	return block, nil
+}
+
+// Walks from the tip back to the first block that is already marked as canonical (the common ancestor of the
+// current and the new canonical chain). Returns all blocks of the new chain above the ancestor and the height of the ancestor.
+// If the chain below the tip is incomplete the height below the lowest known block is returned as ancestor height.
+func (t *forkTree) canonicalPath(tip string) ([]*types.BlockHashNumber, int, error) {
+	var path []*types.BlockHashNumber
+
+	block, err := t.get(tip)
+	if err != nil {
+		return nil, 0, err
+	}
+	if block == nil {
+		return nil, 0, fmt.Errorf("tip %v is not present in the database", tip)
+	}
+
+	for {
+		if block.Canonical {
+			return path, block.Height, nil
+		}
+		path = append(path, block)
+
+		parent, err := t.get(block.PreviousStateHash)
+		if err != nil {
+			return nil, 0, err
+		}
+		if parent == nil {
+			return path, block.Height - 1, nil
+		}
+		block = parent
+	}
+}
+
+// Decides whether the candidate tip should replace the current canonical tip. A higher tip always wins, a tip at the
+// same height only if the node selected it as its best tip.
+func isBetterTip(candidate, current *types.BlockHashNumber, selected bool) bool {
+	if candidate.StateHash == current.StateHash {
+		return true
+	}
+	if candidate.Height != current.Height {
+		return candidate.Height > current.Height
+	}
+	return selected
+}
+
+// Computes the canonical chain ending in the given tip and applies all canonical/orphaned status changes
+// required to switch to it in a single db transaction. The switch is skipped if the tip is not better than the
+// current canonical tip, selected marks tips chosen by the node's chain selection (its best tip).
+func updateCanonicalChain(tip string, selected bool) error {
+	tree := newForkTree()
+	path, ancestorHeight, err := tree.canonicalPath(tip)
+	if err != nil {
+		return err
+	}
+	tipBlock, err := tree.get(tip)
+	if err != nil {
+		return err
+	}
+
+	onPath := make(map[string]bool)
+	for _, b := range path {
+		onPath[b.StateHash] = true
+	}
+
+	currentCanonical, err := db.GetCanonicalBlockHashesAboveHeight(ancestorHeight)
+	if err != nil {
+		return err
+	}
+	if len(currentCanonical) > 0 && !isBetterTip(tipBlock, currentCanonical[0], selected) {
+		logger.Infof("keeping canonical tip %v at height %v over %v at height %v", currentCanonical[0].StateHash, currentCanonical[0].Height, tip, tipBlock.Height)
+		return nil
+	}
+
+	orphaned := make([]*types.Block, 0)
+	for _, b := range currentCanonical {
+		if onPath[b.StateHash] {
+			continue
+		}
+		block, err := db.GetBlockByHash(b.StateHash)
+		if err != nil {
+			return err
+		}
+		orphaned = append(orphaned, block)
+	}
+
+	canonical := make([]*types.Block, 0, len(path))
+	for i := len(path) - 1; i >= 0; i-- {
+		block, err := db.GetBlockByHash(path[i].StateHash)
+		if err != nil {
+			return err
+		}
+		canonical = append(canonical, block)
+	}
+
+	if len(orphaned) == 0 && len(canonical) == 0 {
+		indexedHeightGauge.Set(float64(tipBlock.Height))
+		return nil
+	}
+
+	if len(orphaned) > 0 {
+		logger.Infof("chain reorganization with depth %v detected at height %v", len(orphaned), ancestorHeight)
+	}
+
+	err = db.UpdateCanonicalChain(orphaned, canonical)
+	if err != nil {
+		return err
+	}
+
+	indexedHeightGauge.Set(float64(tipBlock.Height))
+	if len(orphaned) > 0 {
+		reorgDepth.Observe(float64(len(orphaned)))
+		orphanedBlockCounter.Add(float64(len(orphaned)))
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package indexer
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/rpc/fake"
+	"coda-explorer/types"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Builds a chain of <count> blocks on top of the parent, a nil parent starts a new chain at height 1
+func testChain(parent *types.Block, branch string, count int) []*types.Block {
+	parentHash := ""
+	height := 0
+	if parent != nil {
+		parentHash = parent.StateHash
+		height = parent.Height
+	}
+
+	blocks := make([]*types.Block, count)
+	for i := range blocks {
+		height++
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Minute * 3 * time.Duration(height))
+		blocks[i] = fake.NewBlock(parentHash, fmt.Sprintf("%v-%v", branch, height), height, height, ts, testCreator)
+		parentHash = blocks[i].StateHash
+	}
+	return blocks
+}
+
+// Creates a fork tree of the given blocks in which the canonical blocks are marked as such. Returns the tree together
+// with a pointer to the number of blocks loaded by it.
+func testForkTree(canonical []*types.Block, others ...[]*types.Block) (*forkTree, *int) {
+	stored := make(map[string]*types.BlockHashNumber)
+	add := func(blocks []*types.Block, isCanonical bool) {
+		for _, b := range blocks {
+			stored[b.StateHash] = &types.BlockHashNumber{
+				StateHash:         b.StateHash,
+				Canonical:         isCanonical,
+				PreviousStateHash: b.PreviousStateHash,
+				Height:            b.Height,
+			}
+		}
+	}
+	add(canonical, true)
+	for _, blocks := range others {
+		add(blocks, false)
+	}
+
+	loaded := 0
+	tree := &forkTree{
+		nodes: make(map[string]*types.BlockHashNumber),
+		load: func(hash string) (*types.BlockHashNumber, error) {
+			loaded++
+			return stored[hash], nil
+		},
+	}
+	return tree, &loaded
+}
+
+func TestCanonicalPath(t *testing.T) {
+	main := testChain(nil, "main", 10)
+	longer := testChain(main[5], "longer", 5)
+	equal := testChain(main[5], "equal", 4)
+	deep := testChain(main[1], "deep", 300)
+	extension := testChain(main[9], "extension", 2)
+	unconnected := testChain(fake.NewBlock("fake-missing", "missing", 20, 20, time.Now(), testCreator), "unconnected", 3)
+
+	tests := []struct {
+		name           string
+		others         [][]*types.Block
+		tip            string
+		path           []*types.Block
+		ancestorHeight int
+	}{
+		{"current tip", [][]*types.Block{longer}, main[9].StateHash, nil, 10},
+		{"longer fork", [][]*types.Block{longer}, longer[4].StateHash, longer, 6},
+		{"equal length fork", [][]*types.Block{equal}, equal[3].StateHash, equal, 6},
+		{"fork of a fork", [][]*types.Block{longer, equal}, equal[1].StateHash, equal[:2], 6},
+		{"fork deeper than the finality depth", [][]*types.Block{deep}, deep[299].StateHash, deep, 2},
+		{"new blocks on top of the tip", [][]*types.Block{extension}, extension[1].StateHash, extension, 10},
+		{"incomplete chain", [][]*types.Block{unconnected}, unconnected[2].StateHash, unconnected, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, loaded := testForkTree(main, tt.others...)
+			path, ancestorHeight, err := tree.canonicalPath(tt.tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := make([]string, len(tt.path))
+			for i, b := range tt.path {
+				// The path is ordered from the tip downwards
+				expected[len(tt.path)-1-i] = b.StateHash
+			}
+			if !equalHashes(hashNumbers(path), expected) {
+				t.Errorf("path is %v, expected %v", hashNumbers(path), expected)
+			}
+			if ancestorHeight != tt.ancestorHeight {
+				t.Errorf("ancestor height is %v, expected %v", ancestorHeight, tt.ancestorHeight)
+			}
+			// Only the path and the common ancestor (or the missing parent of an incomplete chain) are loaded
+			if *loaded != len(path)+1 {
+				t.Errorf("%v blocks have been loaded for a path of length %v", *loaded, len(path))
+			}
+		})
+	}
+}
+
+func TestCanonicalPathUnknownTip(t *testing.T) {
+	tree, _ := testForkTree(testChain(nil, "main", 3))
+	_, _, err := tree.canonicalPath("fake-unknown")
+	if err == nil {
+		t.Error("expected an error for an unknown tip")
+	}
+}
+
+func TestIsBetterTip(t *testing.T) {
+	current := &types.BlockHashNumber{StateHash: "fake-current", Height: 10}
+	tests := []struct {
+		name      string
+		candidate *types.BlockHashNumber
+		selected  bool
+		better    bool
+	}{
+		{"current tip", current, false, true},
+		{"higher tip", &types.BlockHashNumber{StateHash: "fake-higher", Height: 11}, false, true},
+		{"lower tip", &types.BlockHashNumber{StateHash: "fake-lower", Height: 9}, false, false},
+		{"lower best tip", &types.BlockHashNumber{StateHash: "fake-lower", Height: 9}, true, false},
+		{"equal height", &types.BlockHashNumber{StateHash: "fake-equal", Height: 10}, false, false},
+		{"equal height best tip", &types.BlockHashNumber{StateHash: "fake-equal", Height: 10}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			better := isBetterTip(tt.candidate, current, tt.selected)
+			if better != tt.better {
+				t.Errorf("candidate %v at height %v is better: %v, expected %v", tt.candidate.StateHash, tt.candidate.Height, better, tt.better)
+			}
+		})
+	}
+}
+
+func hashNumbers(blocks []*types.BlockHashNumber) []string {
+	hashes := make([]string, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = b.StateHash
+	}
+	return hashes
+}
+
+// Switching between forks marks the blocks of the previous chain above the common ancestor as orphaned
+func TestUpdateCanonicalChain(t *testing.T) {
+	setupTestDB(t)
+
+	main := testChain(nil, "main", 5)
+	equal := testChain(main[2], "equal", 2)
+	longer := testChain(main[1], "longer", 4)
+	for _, blocks := range [][]*types.Block{main, equal, longer} {
+		for _, b := range blocks {
+			err := db.SaveBlock(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	steps := []struct {
+		tip       string
+		selected  bool
+		canonical [][]*types.Block
+		orphaned  [][]*types.Block
+	}{
+		{main[4].StateHash, true, [][]*types.Block{main}, [][]*types.Block{equal, longer}},
+		// A side branch at the same height only replaces the canonical chain if the node selected it
+		{equal[1].StateHash, false, [][]*types.Block{main}, [][]*types.Block{equal, longer}},
+		{equal[1].StateHash, true, [][]*types.Block{main[:3], equal}, [][]*types.Block{main[3:], longer}},
+		{longer[3].StateHash, false, [][]*types.Block{main[:2], longer}, [][]*types.Block{main[2:], equal}},
+		// Lower side branch tips arriving after the higher canonical tip are ignored
+		{equal[1].StateHash, false, [][]*types.Block{main[:2], longer}, [][]*types.Block{main[2:], equal}},
+		{main[4].StateHash, true, [][]*types.Block{main[:2], longer}, [][]*types.Block{main[2:], equal}},
+	}
+	for _, step := range steps {
+		err := updateCanonicalChain(step.tip, step.selected)
+		if err != nil {
+			t.Fatalf("error updating canonical chain to tip %v: %v", step.tip, err)
+		}
+		for _, blocks := range step.canonical {
+			for _, b := range blocks {
+				assertBlock(t, b.StateHash, true)
+			}
+		}
+		for _, blocks := range step.orphaned {
+			for _, b := range blocks {
+				assertBlock(t, b.StateHash, false)
+			}
+		}
+	}
+}
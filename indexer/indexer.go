@@ -17,77 +17,157 @@
 package indexer
 
 import (
+	"coda-explorer/config"
 	"coda-explorer/db"
+	"coda-explorer/metrics"
 	"coda-explorer/rpc"
 	"coda-explorer/types"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"sort"
 	"sync"
 	"time"
 )
 
 var logger = logrus.New().WithField("module", "indexer")
 
-// Start starts the indexing process
-func Start(rpcEndpoint string, startupLookback int) {
-	client := rpc.NewCodaClient(rpcEndpoint)
+var (
+	indexedHeightGauge   = metrics.NewGaugeVec("coda_indexer_indexed_height", "Height of the canonical tip in the database")
+	exportDuration       = metrics.NewHistogramVec("coda_indexer_block_export_duration_seconds", "Duration of retrieving the accounts touched by a block (prepare) and saving the block (commit)", metrics.DurationBuckets, "stage")
+	reorgDepth           = metrics.NewHistogramVec("coda_indexer_reorg_depth", "Number of blocks orphaned by a chain reorganization", []float64{1, 2, 3, 5, 10, 20, 50, 100, 290})
+	orphanedBlockCounter = metrics.NewCounterVec("coda_indexer_orphaned_blocks_total", "Number of canonical blocks orphaned by chain reorganizations")
+)
+
+// Number of blocks whose touched accounts are retrieved from the node concurrently
+const exportWorkers = 8
+
+// Start runs the indexing process until the context is done, if backfill is set missing blocks down to the genesis block
+// are retrieved in the background. Returns once all background loops have stopped, blocks that are being saved when the
+// context is done are saved completely.
+func Start(ctx context.Context, client rpc.NodeClient, cfg *config.IndexerConfig) {
+	wg := &sync.WaitGroup{}
+	run := func(loop func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop()
+		}()
+	}
+
+	newBlocks := client.SubscribeNewBlocks(ctx)
+	reorganizations := client.SubscribeChainReorganizations(ctx)
 
-	newBlockChan := make(chan string)
+	run(func() { exportDaemonStatus(ctx, client, cfg.DaemonStatusInterval) })
 
-	go client.WatchNewBlocks(newBlockChan)
+	run(func() { exportMempool(ctx, client, cfg.MempoolInterval) })
 
-	go exportDaemonStatus(client, time.Minute*10)
+	run(func() { checkNewBlocks(ctx, newBlocks, reorganizations, client, cfg.CheckInterval, cfg.CheckLookback) })
 
-	go checkNewBlocks(newBlockChan, client, time.Minute)
+	run(func() { updateStatistics(ctx, cfg.StatisticsInterval) })
 
-	go updateStatistics(time.Hour)
+	checkBlocks(ctx, client, cfg.StartupLookback)
 
-	checkBlocks(client, startupLookback)
+	if cfg.Backfill {
+		run(func() { backfillBlocks(ctx, client, cfg.BackfillInterval) })
+	}
+
+	<-ctx.Done()
+	logger.Infof("shutting down indexer, waiting for in-flight exports to complete")
+	wg.Wait()
+	logger.Infof("indexer stopped")
 }
 
-func updateStatistics(intv time.Duration) {
+func updateStatistics(ctx context.Context, intv time.Duration) {
 	ticker := time.NewTicker(intv)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			err := db.GenerateAndSaveStatistics(time.Now().Add(time.Hour * 24 * -1))
 			if err != nil {
-				logger.Errorf("error generating statistics: %w", err)
+				logger.Errorf("error generating statistics: %v", err)
 			}
 			err = db.GenerateAndSaveStatistics(time.Now())
 			if err != nil {
-				logger.Errorf("error generating statistics: %w", err)
+				logger.Errorf("error generating statistics: %v", err)
 			}
 		}
 	}
 }
 
-// Periodically checks for forked or missing blocks
-func checkNewBlocks(newBlockChan chan string, client *rpc.CodaClient, intv time.Duration) {
+// Periodically checks for forked or missing blocks, new blocks reported by the node are indexed directly and chain
+// reorganizations trigger an immediate check
+func checkNewBlocks(ctx context.Context, newBlocks <-chan *rpc.NewBlockEvent, reorganizations <-chan *rpc.ChainReorganizationEvent, client rpc.NodeClient, intv time.Duration, lookback int) {
 	ticker := time.NewTicker(intv)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			checkBlocks(client, 10)
-		case <-newBlockChan:
-			checkBlocks(client, 10)
+			checkBlocks(ctx, client, lookback)
+		case e, ok := <-newBlocks:
+			if ok {
+				indexNewBlock(ctx, e, client, lookback)
+			}
+		case e, ok := <-reorganizations:
+			if ok {
+				logger.Infof("chain reorganization reported by node %v", e.Node)
+				checkBlocks(ctx, client, lookback)
+			}
 		}
 	}
 }
 
 var checkBlockMux = &sync.Mutex{}
 
-func checkBlocks(client *rpc.CodaClient, lookback int) {
+// Indexes a new best tip delivered by a subscription without querying the node for it. Falls back to checking the
+// last blocks of the node if the parent of the block has not been indexed yet.
+func indexNewBlock(ctx context.Context, e *rpc.NewBlockEvent, client rpc.NodeClient, lookback int) {
+	if e.Invalid != nil {
+		quarantineBlock(e.Invalid)
+		return
+	}
+	block := e.Block
+
+	checkBlockMux.Lock()
+	parentExists, err := db.BlockExists(block.PreviousStateHash)
+	if err != nil || !parentExists {
+		checkBlockMux.Unlock()
+		logger.Infof("parent %v of new block %v at height %v is missing, checking the last blocks", block.PreviousStateHash, block.StateHash, block.Height)
+		checkBlocks(ctx, client, lookback)
+		return
+	}
+	defer checkBlockMux.Unlock()
+
+	err = exportBlock(ctx, block, client)
+	if err != nil {
+		logger.Errorf("error exporting new block %v at height %v: %v", block.StateHash, block.Height, err)
+		return
+	}
+
+	// The subscription reports every new block, only the block check follows the node's best tip
+	err = updateCanonicalChain(block.StateHash, false)
+	if err != nil {
+		logger.Errorf("error updating canonical chain to tip %v at height %v: %v", block.StateHash, block.Height, err)
+		return
+	}
+	logger.Infof("new block %v at height %v indexed", block.StateHash, block.Height)
+}
+
+func checkBlocks(ctx context.Context, client rpc.NodeClient, lookback int) {
 	checkBlockMux.Lock()
 	defer checkBlockMux.Unlock()
 
 	dbBlocks, err := db.GetLastBlockHashes(lookback)
 	if err != nil {
-		logger.Errorf("error retrieving last %v blocks from the databases: %w", lookback, err)
+		logger.Errorf("error retrieving last %v blocks from the databases: %v", lookback, err)
 		return
 	}
 	dbBlocksMap := make(map[string]bool)
@@ -95,98 +175,161 @@ func checkBlocks(client *rpc.CodaClient, lookback int) {
 		dbBlocksMap[b.StateHash] = true
 	}
 
-	nodeBlocks, err := client.GetLastBlocks(lookback)
+	nodeBlocks, invalidBlocks, err := client.GetLastBlocks(ctx, lookback)
 	if err != nil {
-		logger.Errorf("error retrieving last %v blocks from the rpc node: %w", lookback, err)
+		logger.Errorf("error retrieving last %v blocks from the rpc node: %v", lookback, err)
 		return
 	}
+	for _, b := range invalidBlocks {
+		quarantineBlock(b)
+	}
+	var missing []*types.Block
 	for _, b := range nodeBlocks {
 		_, present := dbBlocksMap[b.StateHash]
 		if present {
 			// Block has already been properly indexed
 			continue
-		} else {
-			err := exportBlock(b, client)
-			if err != nil {
-				logger.Errorf("error exporting block %v at height %v: %w", b.StateHash, b.Height, err)
-			}
 		}
+		missing = append(missing, b)
+	}
+	sort.SliceStable(missing, func(i, j int) bool {
+		return missing[i].Height < missing[j].Height
+	})
+	exportBlocks(ctx, missing, client)
+	if ctx.Err() != nil {
+		return
 	}
 
-	dbBlocks, err = db.GetLastBlockHashes(lookback)
+	if len(nodeBlocks) > 0 {
+		tip := nodeBlocks[len(nodeBlocks)-1]
+		err = updateCanonicalChain(tip.StateHash, true)
+		if err != nil {
+			logger.Errorf("error updating canonical chain to tip %v at height %v: %v", tip.StateHash, tip.Height, err)
+			return
+		}
+	}
+
+	logger.Infof("block check completed")
+}
+
+// Saves a block that failed validation to the quarantined blocks table so that indexing can continue without it
+func quarantineBlock(invalid *rpc.InvalidBlock) {
+	logger.Errorf("quarantining block %v at height %v: %v", invalid.StateHash, invalid.Height, invalid)
+
+	validationErrors := make([]map[string]string, len(invalid.Errors))
+	for i, e := range invalid.Errors {
+		validationErrors[i] = map[string]string{"field": e.Field, "value": e.Value, "error": e.Err.Error()}
+	}
+	errorsJSON, err := json.Marshal(validationErrors)
 	if err != nil {
-		logger.Errorf("error retrieving last %v blocks from the databases: %w", lookback, err)
+		logger.Errorf("error encoding validation errors of block %v: %v", invalid.StateHash, err)
 		return
 	}
 
-	currentHash := ""
+	// The payload is stored as json string if it is not valid json itself
+	payload := string(invalid.Payload)
+	if !json.Valid(invalid.Payload) {
+		encoded, _ := json.Marshal(payload)
+		payload = string(encoded)
+	}
+
+	now := time.Now()
+	err = db.QuarantineBlock(&types.QuarantinedBlock{
+		StateHash:         invalid.StateHash,
+		PreviousStateHash: invalid.PreviousStateHash,
+		Height:            invalid.Height,
+		Errors:            string(errorsJSON),
+		Payload:           payload,
+		FirstSeen:         now,
+		LastSeen:          now,
+	})
+	if err != nil {
+		logger.Errorf("error quarantining block %v: %v", invalid.StateHash, err)
+	}
+}
 
-	for i, block := range dbBlocks {
-		if i == 0 {
-			currentHash = block.PreviousStateHash
+// preparedBlock is a block together with the current state of all accounts it touched
+type preparedBlock struct {
+	block    *types.Block
+	exists   bool
+	accounts map[string]*types.Account
+	// Best tip of the node at the time the account states have been retrieved
+	tip string
+	// Set for blocks exported by the backfill, whose accounts have not been retrieved
+	backfilled bool
+	err        error
+}
 
-			if !block.Canonical {
-				blockData, err := db.GetBlockByHash(block.StateHash)
-				if err != nil {
-					logger.Errorf("error retrieving data for block %v at height %v: %w", block.StateHash, block.Height, err)
-					return
-				}
+// Exports multiple blocks to the database. The accounts touched by the blocks are retrieved from the node by a bounded
+// pool of workers, the blocks are committed one by one in the given order so that parents are saved before their children.
+// Once the context is done no further blocks are committed.
+func exportBlocks(ctx context.Context, blocks []*types.Block, client rpc.NodeClient) {
+	results := make([]chan *preparedBlock, len(blocks))
+	for i := range results {
+		results[i] = make(chan *preparedBlock, 1)
+	}
 
-				err = db.MarkBlockCanonical(blockData)
-				if err != nil {
-					logger.Errorf("error marking block %v at height %v as canonical: %w", block.StateHash, block.Height, err)
-					return
-				}
+	jobs := make(chan int)
+	for w := 0; w < exportWorkers; w++ {
+		go func() {
+			for i := range jobs {
+				results[i] <- prepareBlock(ctx, blocks[i], client)
 			}
-		} else {
-			if block.StateHash == currentHash && !block.Canonical { // block is part of the canonical chain but currently not marked as canonical
-				logger.Infof("marking block %v at height %v as canonical", block.StateHash, block.Height)
-				blockData, err := db.GetBlockByHash(block.StateHash)
-				if err != nil {
-					logger.Errorf("error retrieving data for block %v at height %v: %w", block.StateHash, block.Height, err)
-					return
-				}
-
-				err = db.MarkBlockCanonical(blockData)
-				if err != nil {
-					logger.Errorf("error marking block %v at height %v as canonical: %w", block.StateHash, block.Height, err)
-					return
-				}
-				currentHash = block.PreviousStateHash
-			} else if block.StateHash != currentHash && block.Canonical { // block is not part of the canonical chain but currently marked as canonical
-				logger.Infof("marking block %v at height %v as orphaned", block.StateHash, block.Height)
-				blockData, err := db.GetBlockByHash(block.StateHash)
-				if err != nil {
-					logger.Errorf("error retrieving data for block %v at height %v: %w", block.StateHash, block.Height, err)
-					return
-				}
-
-				err = db.MarkBlockOrphaned(blockData)
-				if err != nil {
-					logger.Errorf("error marking block %v at height %v as canonical: %w", block.StateHash, block.Height, err)
-					return
-				}
-			} else if block.Canonical {
-				currentHash = block.PreviousStateHash
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range blocks {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
 			}
 		}
-	}
+	}()
 
-	logger.Infof("block check completed")
+	for i, result := range results {
+		// The block may never be prepared once the context is done, as the remaining jobs are not dispatched anymore
+		var p *preparedBlock
+		select {
+		case p = <-result:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			logger.Infof("export interrupted, %v blocks have not been exported", len(blocks)-i)
+			return
+		}
+
+		err := p.err
+		if err == nil {
+			err = commitBlock(p)
+		}
+		if err != nil {
+			logger.Errorf("error exporting block %v at height %v: %v", p.block.StateHash, p.block.Height, err)
+		}
+	}
 }
 
 // Exports a block to the database, does nothing if the block has already previously been exported
-func exportBlock(block *types.Block, client *rpc.CodaClient) error {
-	logger.Infof("exporting block %v at height %v", block.StateHash, block.Height)
-	exists, err := db.BlockExists(block.StateHash)
-
-	if err == nil && exists {
-		logger.Infof("block %v already exported", block.StateHash)
-		return nil
+func exportBlock(ctx context.Context, block *types.Block, client rpc.NodeClient) error {
+	p := prepareBlock(ctx, block, client)
+	if p.err != nil {
+		return p.err
 	}
+	return commitBlock(p)
+}
 
+// Retrieves the current state of all accounts touched by a block from the node
+func prepareBlock(ctx context.Context, block *types.Block, client rpc.NodeClient) *preparedBlock {
+	p := &preparedBlock{block: block}
 	start := time.Now()
 
+	exists, err := db.BlockExists(block.StateHash)
+	if err == nil && exists {
+		p.exists = true
+		return p
+	}
+
 	accountsInBlock := make(map[string]bool)
 	accountsInBlock[block.Creator] = true
 
@@ -202,56 +345,121 @@ func exportBlock(block *types.Block, client *rpc.CodaClient) error {
 	for _, sj := range block.SnarkJobs {
 		accountsInBlock[sj.Prover] = true
 	}
-	logger.Infof("block mutated %v accounts", len(accountsInBlock))
+	logger.Infof("block %v at height %v mutated %v accounts", block.StateHash, block.Height, len(accountsInBlock))
 
+	pubKeys := make([]string, 0, len(accountsInBlock))
 	for pubKey := range accountsInBlock {
-		//logger.Infof("exporting account %v", pubKey)
-		account, err := client.GetAccount(pubKey)
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	p.accounts, p.tip, err = client.GetAccounts(ctx, pubKeys)
+	exportDuration.ObserveSince(start, "prepare")
+	if err != nil {
+		p.err = fmt.Errorf("error retrieving account data for block %v via rpc: %w", block.StateHash, err)
+	}
+	return p
+}
+
+// Saves a prepared block together with the accounts it touched
+func commitBlock(p *preparedBlock) error {
+	block := p.block
+	if p.exists {
+		logger.Infof("block %v already exported", block.StateHash)
+		return nil
+	}
+
+	logger.Infof("exporting block %v at height %v", block.StateHash, block.Height)
+	start := time.Now()
+
+	var discrepancies []*types.AccountDiscrepancy
+	var err error
+	if !p.backfilled {
+		block.AccountBalances, discrepancies, err = deriveAccountStates(block, p.accounts, p.tip == block.StateHash)
 		if err != nil {
-			return fmt.Errorf("error retrieving account data for account %v via rpc: %w", pubKey, err)
+			return fmt.Errorf("error deriving account states at block %v: %w", block.StateHash, err)
 		}
-		//logger.Infof("account data retrieved")
 
-		account.FirstSeen = block.Ts
-		account.LastSeen = block.Ts
+		accounts := make([]*types.Account, 0, len(p.accounts))
+		for _, account := range p.accounts {
+			account.FirstSeen = block.Ts
+			account.LastSeen = block.Ts
+			accounts = append(accounts, account)
+		}
+		sort.Slice(accounts, func(i, j int) bool {
+			return accounts[i].PublicKey < accounts[j].PublicKey
+		})
 
-		err = db.SaveAccount(account)
+		err = db.SaveAccounts(accounts)
 		if err != nil {
-			return fmt.Errorf("error saving account data for account %v: %w", pubKey, err)
+			return fmt.Errorf("error saving account data for block %v: %w", block.StateHash, err)
 		}
-		//logger.Infof("account data exported to db")
+		logger.Infof("accounts updated, saving block to db")
 	}
-	logger.Infof("accounts updated, saving block to db")
 
 	err = db.SaveBlock(block)
 	if err != nil {
 		return fmt.Errorf("error saving block data for block %v: %w", block.StateHash, err)
 	}
+	if len(discrepancies) > 0 {
+		err = db.SaveAccountDiscrepancies(discrepancies)
+		if err != nil {
+			return fmt.Errorf("error saving account discrepancies of block %v: %w", block.StateHash, err)
+		}
+	}
+	exportDuration.ObserveSince(start, "commit")
 	logger.WithField("txs", block.UserCommandsCount).WithField("snarks", block.SnarkJobsCount).WithField("feeTransfers", block.FeeTransferCount).Infof("block data exported to db, took %v", time.Since(start))
 
 	return nil
 }
 
 // Exports the current daemon status in a specified interval to the database
-func exportDaemonStatus(client *rpc.CodaClient, intv time.Duration) {
+func exportDaemonStatus(ctx context.Context, client rpc.NodeClient, intv time.Duration) {
 	ticker := time.NewTicker(intv)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			status, err := client.GetDaemonStatus()
+			status, err := client.GetDaemonStatus(ctx)
 			if err != nil {
-				logger.Errorf("error retrieving daemon status: %w", err)
+				logger.Errorf("error retrieving daemon status: %v", err)
 				continue
 			}
 
 			err = db.SaveDaemonStatus(status)
 			if err != nil {
-				logger.Errorf("error saving daemon status: %w", err)
+				logger.Errorf("error saving daemon status: %v", err)
 				continue
 			}
 			logger.Infof("daemon status updated")
 		}
 	}
 }
+
+// Exports the transactions pending in the transaction pool of the node in a specified interval to the database
+func exportMempool(ctx context.Context, client rpc.NodeClient, intv time.Duration) {
+	ticker := time.NewTicker(intv)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			txs, err := client.GetPooledUserCommands(ctx)
+			if err != nil {
+				logger.Errorf("error retrieving pooled user commands: %v", err)
+				continue
+			}
+
+			err = db.UpdateMempool(txs, time.Now())
+			if err != nil {
+				logger.Errorf("error updating mempool: %v", err)
+				continue
+			}
+			logger.Infof("mempool updated, %v transactions pending", len(txs))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ledger
+
+import (
+	"coda-explorer/types"
+)
+
+// ApplyBlock applies the coinbase, user commands and fee transfers of a block to the account states returned by the
+// state function. The state function may return nil for accounts that should not be tracked.
+//
+// The coinbase is credited to the block creator, payments move the amount from sender to recipient, delegations change
+// the delegate of the sender, and the fees of all user commands are paid by the sender and redistributed through the
+// fee transfers of the block. Fee transfers to snark workers that exceed the fees of the user commands are paid out of
+// the coinbase, so the creator only receives the remainder of the coinbase.
+func ApplyBlock(block *types.Block, state func(publicKey string) *types.AccountBalance) {
+	feeExcess := types.Amount{}
+	for _, ft := range block.FeeTransfers {
+		feeExcess = feeExcess.Add(ft.Fee)
+	}
+	for _, uj := range block.UserJobs {
+		feeExcess = feeExcess.Sub(uj.Fee)
+	}
+
+	if s := state(block.Creator); s != nil {
+		s.Balance = s.Balance.Add(block.Coinbase)
+		if feeExcess.Sign() > 0 {
+			s.Balance = s.Balance.Sub(feeExcess)
+		}
+	}
+
+	for _, uj := range block.UserJobs {
+		if s := state(uj.Sender); s != nil {
+			s.Balance = s.Balance.Sub(uj.Fee)
+			if uj.Delegation {
+				s.Delegate = uj.Recipient
+			} else {
+				s.Balance = s.Balance.Sub(uj.Amount)
+			}
+			s.Nonce = uj.Nonce + 1
+		}
+		if uj.Delegation {
+			continue
+		}
+		if r := state(uj.Recipient); r != nil {
+			r.Balance = r.Balance.Add(uj.Amount)
+		}
+	}
+
+	for _, ft := range block.FeeTransfers {
+		if s := state(ft.Recipient); s != nil {
+			s.Balance = s.Balance.Add(ft.Fee)
+		}
+	}
+}
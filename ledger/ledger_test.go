@@ -0,0 +1,176 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ledger
+
+import (
+	"coda-explorer/types"
+	"testing"
+)
+
+func TestApplyBlock(t *testing.T) {
+	amount := types.NewAmount
+
+	tests := []struct {
+		name     string
+		block    *types.Block
+		previous map[string]*types.AccountBalance
+		// Expected balances, nonces and delegates after the block, accounts missing from previous are not tracked
+		balances  map[string]int64
+		nonces    map[string]int
+		delegates map[string]string
+	}{
+		{
+			name:     "coinbase",
+			block:    &types.Block{Creator: "creator", Coinbase: amount(20000)},
+			previous: map[string]*types.AccountBalance{"creator": {Balance: amount(5)}},
+			balances: map[string]int64{"creator": 20005},
+		},
+		{
+			name: "payment with fee transfer to the creator",
+			block: &types.Block{
+				Creator:      "creator",
+				Coinbase:     amount(20000),
+				UserJobs:     []*types.UserJob{{Sender: "alice", Recipient: "bob", Amount: amount(300), Fee: amount(10), Nonce: 2}},
+				FeeTransfers: []*types.FeeTransfer{{Recipient: "creator", Fee: amount(10)}},
+			},
+			previous: map[string]*types.AccountBalance{"creator": {}, "alice": {Balance: amount(1000), Nonce: 2}, "bob": {Balance: amount(1)}},
+			balances: map[string]int64{"creator": 20010, "alice": 690, "bob": 301},
+			nonces:   map[string]int{"alice": 3, "bob": 0},
+		},
+		{
+			name: "delegation",
+			block: &types.Block{
+				Creator:      "creator",
+				UserJobs:     []*types.UserJob{{Sender: "alice", Recipient: "bob", Amount: amount(300), Fee: amount(10), Nonce: 0, Delegation: true}},
+				FeeTransfers: []*types.FeeTransfer{{Recipient: "creator", Fee: amount(10)}},
+			},
+			// The amount of a delegation is not transferred, the state of the new delegate is not requested
+			previous:  map[string]*types.AccountBalance{"creator": {}, "alice": {Balance: amount(1000), Delegate: "alice"}},
+			balances:  map[string]int64{"creator": 10, "alice": 990},
+			nonces:    map[string]int{"alice": 1},
+			delegates: map[string]string{"alice": "bob"},
+		},
+		{
+			name: "snark fees paid from user command fees",
+			block: &types.Block{
+				Creator:      "creator",
+				Coinbase:     amount(20000),
+				UserJobs:     []*types.UserJob{{Sender: "alice", Recipient: "bob", Amount: amount(100), Fee: amount(50)}},
+				FeeTransfers: []*types.FeeTransfer{{Recipient: "prover", Fee: amount(30)}, {Recipient: "creator", Fee: amount(20)}},
+			},
+			previous: map[string]*types.AccountBalance{"creator": {}, "alice": {Balance: amount(1000)}, "prover": {}},
+			balances: map[string]int64{"creator": 20020, "alice": 850, "prover": 30},
+		},
+		{
+			name: "snark fees paid from the coinbase",
+			block: &types.Block{
+				Creator:      "creator",
+				Coinbase:     amount(20000),
+				FeeTransfers: []*types.FeeTransfer{{Recipient: "prover", Fee: amount(500)}},
+			},
+			previous: map[string]*types.AccountBalance{"creator": {}, "prover": {Balance: amount(7)}},
+			balances: map[string]int64{"creator": 19500, "prover": 507},
+		},
+		{
+			name: "snark fees partially paid from the coinbase",
+			block: &types.Block{
+				Creator:      "creator",
+				Coinbase:     amount(20000),
+				UserJobs:     []*types.UserJob{{Sender: "alice", Recipient: "bob", Amount: amount(100), Fee: amount(200)}},
+				FeeTransfers: []*types.FeeTransfer{{Recipient: "prover", Fee: amount(500)}},
+			},
+			previous: map[string]*types.AccountBalance{"creator": {}, "alice": {Balance: amount(1000)}, "bob": {}, "prover": {}},
+			balances: map[string]int64{"creator": 19700, "alice": 700, "bob": 100, "prover": 500},
+		},
+		{
+			name: "untracked accounts",
+			block: &types.Block{
+				Creator:      "creator",
+				Coinbase:     amount(20000),
+				UserJobs:     []*types.UserJob{{Sender: "alice", Recipient: "bob", Amount: amount(100), Fee: amount(10)}},
+				FeeTransfers: []*types.FeeTransfer{{Recipient: "creator", Fee: amount(10)}},
+			},
+			previous: map[string]*types.AccountBalance{"bob": {Balance: amount(1)}},
+			balances: map[string]int64{"bob": 101},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			touched := make(map[string]bool)
+			ApplyBlock(tt.block, func(pk string) *types.AccountBalance {
+				touched[pk] = true
+				return tt.previous[pk]
+			})
+
+			for pk, balance := range tt.balances {
+				if tt.previous[pk].Balance.Cmp(amount(balance)) != 0 {
+					t.Errorf("balance of %v is %v, expected %v", pk, tt.previous[pk].Balance, balance)
+				}
+			}
+			for pk, nonce := range tt.nonces {
+				if tt.previous[pk].Nonce != nonce {
+					t.Errorf("nonce of %v is %v, expected %v", pk, tt.previous[pk].Nonce, nonce)
+				}
+			}
+			for pk, delegate := range tt.delegates {
+				if tt.previous[pk].Delegate != delegate {
+					t.Errorf("delegate of %v is %v, expected %v", pk, tt.previous[pk].Delegate, delegate)
+				}
+			}
+			for pk := range tt.previous {
+				if !touched[pk] {
+					t.Errorf("state of %v has not been requested", pk)
+				}
+			}
+		})
+	}
+}
+
+// The total balance only changes by the coinbase, fees and snark fees are moved between accounts
+func TestApplyBlockConservesCurrency(t *testing.T) {
+	amount := types.NewAmount
+	block := &types.Block{
+		Creator:  "creator",
+		Coinbase: amount(20000),
+		UserJobs: []*types.UserJob{
+			{Sender: "alice", Recipient: "bob", Amount: amount(100), Fee: amount(40)},
+			{Sender: "bob", Recipient: "alice", Amount: amount(5), Fee: amount(20), Delegation: true},
+		},
+		FeeTransfers: []*types.FeeTransfer{{Recipient: "prover", Fee: amount(90)}, {Recipient: "creator", Fee: amount(5)}},
+	}
+	states := map[string]*types.AccountBalance{
+		"creator": {Balance: amount(0)},
+		"alice":   {Balance: amount(1000)},
+		"bob":     {Balance: amount(1000)},
+		"prover":  {Balance: amount(0)},
+	}
+
+	total := func() types.Amount {
+		sum := types.Amount{}
+		for _, s := range states {
+			sum = sum.Add(s.Balance)
+		}
+		return sum
+	}
+	before := total()
+	ApplyBlock(block, func(pk string) *types.AccountBalance { return states[pk] })
+
+	if total().Sub(before).Cmp(block.Coinbase) != 0 {
+		t.Errorf("total balance changed by %v, expected the coinbase %v", total().Sub(before), block.Coinbase)
+	}
+}
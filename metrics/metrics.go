@@ -0,0 +1,359 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package metrics provides counters, gauges and histograms partitioned by labels that are rendered in the prometheus
+// text exposition format
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.New().WithField("module", "metrics")
+
+// Types of the metrics
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DurationBuckets are histogram buckets suitable for latencies in seconds, from 5ms to 30s
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var (
+	registryMux sync.Mutex
+	registry    []*metric
+)
+
+// metric is a named value for each combination of label values
+type metric struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	// Upper bounds of the histogram buckets in increasing order, only set for histograms
+	buckets []float64
+
+	mux    sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Computes the value when the metrics are written, overrides value if set
+	fn func() float64
+	// Number of observations per histogram bucket (not cumulative) and their sum, value holds the total count
+	counts []uint64
+	sum    float64
+}
+
+func register(name, help, typ string, labels []string, buckets []float64) *metric {
+	m := &metric{name: name, help: help, typ: typ, labels: labels, buckets: buckets, series: make(map[string]*series)}
+
+	registryMux.Lock()
+	defer registryMux.Unlock()
+
+	for _, r := range registry {
+		if r.name == name {
+			panic(fmt.Sprintf("metric %v registered twice", name))
+		}
+	}
+	registry = append(registry, m)
+	return m
+}
+
+// Returns the series of the given label values, creating it if necessary. The mutex of the metric has to be held.
+func (m *metric) seriesOf(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %v expects %v label values, got %v", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	s, exists := m.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.typ == typeHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(value float64, set bool, labelValues []string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	s := m.seriesOf(labelValues)
+	s.fn = nil
+	if set {
+		s.value = value
+	} else {
+		s.value += value
+	}
+}
+
+func (m *metric) get(labelValues []string) float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	s, exists := m.series[strings.Join(labelValues, "\xff")]
+	if !exists {
+		return 0
+	}
+	if s.fn != nil {
+		return s.fn()
+	}
+	return s.value
+}
+
+// CounterVec is a monotonically increasing value for each combination of label values
+type CounterVec struct {
+	m *metric
+}
+
+// NewCounterVec creates and registers a counter with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{m: register(name, help, typeCounter, labels, nil)}
+}
+
+// Inc increments the counter of the given label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.m.add(1, false, labelValues)
+}
+
+// Add increments the counter of the given label values, negative values are ignored
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.m.add(value, false, labelValues)
+}
+
+// Value returns the current value of the counter of the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.m.get(labelValues)
+}
+
+// GaugeVec is a value that can go up and down for each combination of label values
+type GaugeVec struct {
+	m *metric
+}
+
+// NewGaugeVec creates and registers a gauge with the given label names
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{m: register(name, help, typeGauge, labels, nil)}
+}
+
+// Set sets the gauge of the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.m.add(value, true, labelValues)
+}
+
+// Add adds a possibly negative value to the gauge of the given label values
+func (g *GaugeVec) Add(value float64, labelValues ...string) {
+	g.m.add(value, false, labelValues)
+}
+
+// SetFunc sets the gauge of the given label values to the value returned by fn whenever the metrics are written,
+// until Set or Add is called
+func (g *GaugeVec) SetFunc(fn func() float64, labelValues ...string) {
+	g.m.mux.Lock()
+	defer g.m.mux.Unlock()
+
+	g.m.seriesOf(labelValues).fn = fn
+}
+
+// Value returns the current value of the gauge of the given label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.m.get(labelValues)
+}
+
+// HistogramVec counts observations in configurable buckets for each combination of label values
+type HistogramVec struct {
+	m *metric
+}
+
+// NewHistogramVec creates and registers a histogram with the given bucket upper bounds and label names, the +Inf
+// bucket is added implicitly
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{m: register(name, help, typeHistogram, labels, buckets)}
+}
+
+// Observe adds a single observation to the histogram of the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.m.mux.Lock()
+	defer h.m.mux.Unlock()
+
+	s := h.m.seriesOf(labelValues)
+	i := sort.SearchFloat64s(h.m.buckets, value)
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.value++
+	s.sum += value
+}
+
+// ObserveSince adds the seconds elapsed since start to the histogram of the given label values
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of observations of the histogram of the given label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	return uint64(h.m.get(labelValues))
+}
+
+// Handler returns a http handler serving all registered metrics in the prometheus text exposition format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := WritePrometheus(w)
+		if err != nil {
+			logger.Errorf("error writing metrics: %v", err)
+		}
+	})
+}
+
+// Serve serves the metrics on /metrics of a separate http server listening on the given port until the context is done
+func Serve(ctx context.Context, port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{
+		Addr:         fmt.Sprintf("0.0.0.0:%v", port),
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		Handler:      mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	logger.Printf("metrics server listening on %v", srv.Addr)
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorf("error running metrics server: %v", err)
+	}
+}
+
+// WritePrometheus writes all registered metrics in the prometheus text exposition format
+func WritePrometheus(w io.Writer) error {
+	registryMux.Lock()
+	metrics := append([]*metric(nil), registry...)
+	registryMux.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != typeHistogram {
+			value := s.value
+			if s.fn != nil {
+				value = s.fn()
+			}
+			m.writeSample(w, "", s.labelValues, "", value)
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			m.writeSample(w, "_bucket", s.labelValues, formatFloat(bound), float64(cumulative))
+		}
+		m.writeSample(w, "_bucket", s.labelValues, formatFloat(math.Inf(1)), s.value)
+		m.writeSample(w, "_sum", s.labelValues, "", s.sum)
+		m.writeSample(w, "_count", s.labelValues, "", s.value)
+	}
+}
+
+// Writes a single sample line, le is the bucket label of histograms and omitted if empty
+func (m *metric) writeSample(w *bufio.Writer, suffix string, labelValues []string, le string, value float64) {
+	w.WriteString(m.name)
+	w.WriteString(suffix)
+	if len(m.labels) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, label := range m.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+		if le != "" {
+			if len(m.labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "le=\"%s\"", le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,104 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	requests := NewCounterVec("test_requests_total", "Number of requests", "route", "status")
+	temperature := NewGaugeVec("test_temperature", "Current temperature\\n with \"quotes\"\nand a newline")
+	queue := NewGaugeVec("test_queue_length", "Length of the queue", "queue")
+	latency := NewHistogramVec("test_latency_seconds", "Request latency", []float64{1, 0.1}, "route")
+	NewCounterVec("test_unused_total", "Counter without observations", "route")
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Add(-1, "/a", "200")
+	requests.Inc("/a", "500")
+	requests.Inc(`C:\path "quoted"`+"\n", "404")
+	temperature.Set(21.5)
+	temperature.Add(-1)
+	queue.Set(7, "fast")
+	queue.SetFunc(func() float64 { return 42 }, "slow")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.1, "/a")
+	latency.Observe(1, "/a")
+	latency.Observe(2.5, "/a")
+	latency.Observe(0.25, "/b")
+
+	expected := `# HELP test_latency_seconds Request latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/a",le="0.1"} 2
+test_latency_seconds_bucket{route="/a",le="1"} 3
+test_latency_seconds_bucket{route="/a",le="+Inf"} 4
+test_latency_seconds_sum{route="/a"} 3.65
+test_latency_seconds_count{route="/a"} 4
+test_latency_seconds_bucket{route="/b",le="0.1"} 0
+test_latency_seconds_bucket{route="/b",le="1"} 1
+test_latency_seconds_bucket{route="/b",le="+Inf"} 1
+test_latency_seconds_sum{route="/b"} 0.25
+test_latency_seconds_count{route="/b"} 1
+# HELP test_queue_length Length of the queue
+# TYPE test_queue_length gauge
+test_queue_length{queue="fast"} 7
+test_queue_length{queue="slow"} 42
+# HELP test_requests_total Number of requests
+# TYPE test_requests_total counter
+test_requests_total{route="/a",status="200"} 2
+test_requests_total{route="/a",status="500"} 1
+test_requests_total{route="/b",status="200"} 1
+test_requests_total{route="C:\\path \"quoted\"\n",status="404"} 1
+# HELP test_temperature Current temperature\\n with "quotes"\nand a newline
+# TYPE test_temperature gauge
+test_temperature 20.5
+# HELP test_unused_total Counter without observations
+# TYPE test_unused_total counter
+`
+
+	buf := &bytes.Buffer{}
+	err := WritePrometheus(buf)
+	if err != nil {
+		t.Fatalf("error writing metrics: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, buf.String())
+	}
+
+	if got := requests.Value("/a", "200"); got != 2 {
+		t.Errorf("expected counter value 2, got %v", got)
+	}
+	if got := queue.Value("slow"); got != 42 {
+		t.Errorf("expected gauge value 42, got %v", got)
+	}
+	if got := latency.Count("/a"); got != 4 {
+		t.Errorf("expected 4 observations, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("expected status 200 with the text exposition content type, got %v %v", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != expected {
+		t.Errorf("expected handler to serve\n%v\ngot\n%v", expected, rec.Body.String())
+	}
+}
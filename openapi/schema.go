@@ -0,0 +1,139 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Generator derives schemas from go types using the same rules as encoding/json. Named struct types are
+// added to the components of the document and referenced from the returned schemas.
+type Generator struct {
+	doc    *Document
+	custom map[reflect.Type]*Schema
+}
+
+// NewGenerator returns a generator adding its component schemas to the given document
+func NewGenerator(doc *Document) *Generator {
+	g := &Generator{
+		doc:    doc,
+		custom: make(map[reflect.Type]*Schema),
+	}
+	g.Define(time.Time{}, &Schema{Type: "string", Format: "date-time"})
+	return g
+}
+
+// Define sets the schema used for all values of the type of v, required for types implementing json.Marshaler
+func (g *Generator) Define(v interface{}, schema *Schema) {
+	g.custom[reflect.TypeOf(v)] = schema
+}
+
+// SchemaOf returns the schema describing the json encoding of v
+func (g *Generator) SchemaOf(v interface{}) *Schema {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	if s, exists := g.custom[t]; exists {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return &Schema{AllOf: []*Schema{g.schemaOf(t.Elem())}, Nullable: true}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+
+	// Interfaces can hold any value
+	return &Schema{}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	name := t.Name()
+	if name != "" {
+		if _, exists := g.doc.Components.Schemas[name]; exists {
+			return &Schema{Ref: "#/components/schemas/" + name}
+		}
+	}
+
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+	if name != "" {
+		// Register the schema before resolving the fields to support recursive types
+		g.doc.Components.Schemas[name] = s
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		fieldName := parts[0]
+		if fieldName == "" {
+			fieldName = f.Name
+		}
+
+		omitEmpty := false
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				omitEmpty = true
+			}
+		}
+
+		s.Properties[fieldName] = g.schemaOf(f.Type)
+		if !omitEmpty {
+			s.Required = append(s.Required, fieldName)
+		}
+	}
+
+	if name != "" {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return s
+}
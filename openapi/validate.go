@@ -0,0 +1,185 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateResponse checks that a response of the operation at the given method and path matches the document
+func (d *Document) ValidateResponse(method, path string, status int, contentType string, body []byte) error {
+	op := d.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("operation %v %v is not documented", method, path)
+	}
+
+	resp, exists := op.Responses[strconv.Itoa(status)]
+	if !exists {
+		resp, exists = op.Responses["default"]
+	}
+	if !exists {
+		return fmt.Errorf("status %v of operation %v %v is not documented", status, method, path)
+	}
+
+	mediaType, exists := resp.Content[strings.Split(contentType, ";")[0]]
+	if !exists {
+		if len(resp.Content) > 0 {
+			return fmt.Errorf("content type %v of status %v of operation %v %v is not documented", contentType, status, method, path)
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return fmt.Errorf("error decoding response body of operation %v %v: %w", method, path, err)
+	}
+
+	return d.Validate(mediaType.Schema, value)
+}
+
+// Validate checks that a json value decoded using json.Decoder.UseNumber matches the schema
+func (d *Document) Validate(schema *Schema, value interface{}) error {
+	return d.validate(schema, value, "$")
+}
+
+func (d *Document) validate(schema *Schema, value interface{}, location string) error {
+	if schema.Ref != "" {
+		resolved, exists := d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !exists {
+			return fmt.Errorf("%v: unresolvable schema reference %v", location, schema.Ref)
+		}
+		return d.validate(resolved, value, location)
+	}
+
+	if value == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.AllOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%v: value must not be null", location)
+	}
+
+	for _, s := range schema.AllOf {
+		err := d.validate(s, value, location)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%v: expected boolean, got %T", location, value)
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%v: expected integer, got %T", location, value)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%v: expected integer, got %v", location, n)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%v: expected number, got %T", location, value)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%v: expected string, got %T", location, value)
+		}
+		if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(str) {
+			return fmt.Errorf("%v: %q does not match pattern %v", location, str, schema.Pattern)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v: expected array, got %T", location, value)
+		}
+		if schema.Items == nil {
+			return nil
+		}
+		for i, item := range items {
+			err := d.validate(schema.Items, item, fmt.Sprintf("%v[%v]", location, i))
+			if err != nil {
+				return err
+			}
+		}
+	case "object":
+		return d.validateObject(schema, value, location)
+	default:
+		return fmt.Errorf("%v: unsupported schema type %v", location, schema.Type)
+	}
+
+	return nil
+}
+
+func (d *Document) validateObject(schema *Schema, value interface{}, location string) error {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%v: expected object, got %T", location, value)
+	}
+
+	for _, name := range schema.Required {
+		if _, exists := object[name]; !exists {
+			return fmt.Errorf("%v: required property %v is missing", location, name)
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propertyLocation := location + "." + name
+
+		if property, exists := schema.Properties[name]; exists {
+			err := d.validate(property, object[name], propertyLocation)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch additional := schema.AdditionalProperties.(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%v: property is not documented", propertyLocation)
+			}
+		case *Schema:
+			err := d.validate(additional, object[name], propertyLocation)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -17,136 +17,214 @@
 package rpc
 
 import (
+	"bytes"
 	"coda-explorer/types"
-	"coda-explorer/util"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 var logger = logrus.New().WithField("module", "rpc")
 
+// NodeClient describes the node api methods required by the indexer
+type NodeClient interface {
+	GetLastBlocks(ctx context.Context, lookback int) ([]*types.Block, []*InvalidBlock, error)
+	GetBlock(ctx context.Context, stateHash string) (*types.Block, error)
+	GetAccounts(ctx context.Context, publicKeys []string) (map[string]*types.Account, string, error)
+	GetDaemonStatus(ctx context.Context) (*types.DaemonStatus, error)
+	GetPooledUserCommands(ctx context.Context) ([]*types.MempoolTransaction, error)
+	SubscribeNewBlocks(ctx context.Context) <-chan *NewBlockEvent
+	SubscribeChainReorganizations(ctx context.Context) <-chan *ChainReorganizationEvent
+}
+
+var _ NodeClient = (*CodaClient)(nil)
+
+// ErrNotFound is returned when the node does not know a requested block or account
+var ErrNotFound = errors.New("not found")
+
+// Maximum number of accounts retrieved by a single graphql query
+const accountsPerQuery = 100
+
 // CodaClient encapsulates all methods required to communicate with a Coda blockchain node via the graphql api
 type CodaClient struct {
 	httpClient *http.Client
 	host       string
+	retry      *RetryPolicy
+	breaker    *CircuitBreaker
+
+	subscriptions *SubscriptionManager
 }
 
+// Number of consecutive failed requests after which requests to a node are paused
+const breakerFailureThreshold = 5
+
+// Duration for which requests to a node are paused before it is probed again
+const breakerOpenTimeout = time.Second * 30
+
 // NewCodaClient creates a new rpc client
 func NewCodaClient(host string) *CodaClient {
 
 	cc := &CodaClient{
 		httpClient: &http.Client{Timeout: time.Second * 60},
 		host:       host,
+		retry:      DefaultRetryPolicy,
+		breaker:    NewCircuitBreaker(host, breakerFailureThreshold, breakerOpenTimeout),
 	}
+	cc.subscriptions = NewSubscriptionManager(host, cc.retry)
 
 	return cc
 }
 
-// Helper function for executing a graphql query and parsing its result
-func (cc *CodaClient) getData(query string, target interface{}) error {
-	req := "http://" + cc.host + "?query=" + url.QueryEscape(query)
+// Helper function for executing a graphql query with the given variables, the data of the response is decoded into target.
+// Errors reported by the node are returned as *GraphQLError, unexpected http status codes as *StatusError. Failed
+// requests are retried according to the retry policy of the client unless the circuit breaker of the node is open.
+// The name identifies the query in the request metrics.
+func (cc *CodaClient) getData(ctx context.Context, name string, query string, variables map[string]interface{}, target interface{}) error {
+	reqBody, err := json.Marshal(&graphqlRequest{Query: query, Variables: variables})
+	if err != nil {
+		return fmt.Errorf("error encoding graphql query request: %w", err)
+	}
+
+	return cc.retry.Do(ctx, cc.host, func() error {
+		err := cc.breaker.Allow()
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		err = cc.post(ctx, reqBody, target)
+		requestDuration.ObserveSince(start, cc.host, name)
+		if err != nil {
+			requestErrorsCounter.Inc(cc.host, name)
+		}
+		cc.breaker.Record(err)
+		return err
+	})
+}
+
+// Sends a single graphql query request
+func (cc *CodaClient) post(ctx context.Context, reqBody []byte, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+cc.host, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("error creating graphql query request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
 
-	resp, err := cc.httpClient.Get(req)
+	resp, err := cc.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("error retrieving graphql query response: %w", err)
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("error reading graphql query response body: %w", err)
 	}
 
-	err = json.Unmarshal(body, target)
+	result := &graphqlResponse{}
+	decodeErr := json.Unmarshal(body, result)
+	if decodeErr == nil && len(result.Errors) > 0 {
+		return &GraphQLError{StatusCode: resp.StatusCode, Errors: result.Errors}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode, Body: truncate(string(body), 512)}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("error decoding graphql query response: %w", decodeErr)
+	}
+	if len(result.Data) == 0 || string(result.Data) == "null" {
+		return fmt.Errorf("error decoding graphql query response: response contains no data")
+	}
+
+	err = json.Unmarshal(result.Data, target)
 	if err != nil {
-		return fmt.Errorf("error decoding graphql query response: %w", err)
+		return fmt.Errorf("error decoding graphql query response data: %w", err)
 	}
 
 	return nil
 }
 
-// WatchNewBlocks subscription for watching for new blocks, uses the websocket protocol and will automatically reconnect on disconnects
-func (cc *CodaClient) WatchNewBlocks(newBlockChan chan string) {
-	for {
-		time.Sleep(time.Second * 5)
-		graphqlWsHeader := http.Header{}
-		graphqlWsHeader["Sec-WebSocket-Protocol"] = []string{"graphql-ws"}
+// Body of a graphql query request
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
 
-		c, _, err := websocket.DefaultDialer.Dial("ws://"+cc.host, graphqlWsHeader)
-		if err != nil {
-			logger.Errorf("error connecting to websocket at %v: %v", cc.host, err)
-			continue
-		}
+// Envelope of a graphql query response
+type graphqlResponse struct {
+	Data   json.RawMessage       `json:"data"`
+	Errors []GraphQLErrorMessage `json:"errors"`
+}
 
-		subscriptionName := "newBlock"
+// GraphQLErrorMessage is a single entry of the errors array of a graphql response
+type GraphQLErrorMessage struct {
+	Message   string        `json:"message"`
+	Path      []interface{} `json:"path,omitempty"`
+	Locations []struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"locations,omitempty"`
+}
 
-		queryMessage := fmt.Sprintf(`{
-	  "id": "1",
-	  "type": "start",
-	  "payload": {
-		"variables": {},
-		"extensions": {},
-		"operationName": null,
-		"query": "subscription { %s { stateHash } }"
-	  }
-	}`, subscriptionName)
+// GraphQLError is returned when the node answers a query with a non empty errors array
+type GraphQLError struct {
+	StatusCode int
+	Errors     []GraphQLErrorMessage
+}
 
-		err = c.WriteMessage(websocket.TextMessage, []byte(queryMessage))
-		if err != nil {
-			logger.Errorf("error subscribing to newBlock events: %v", err)
-			c.Close()
-			continue
+func (e *GraphQLError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, m := range e.Errors {
+		messages[i] = m.Message
+		if len(m.Path) > 0 {
+			messages[i] = fmt.Sprintf("%v (path %v)", m.Message, m.Path)
 		}
-		logger.Infof("subscribed to %s events", subscriptionName)
+	}
+	return "graphql error: " + strings.Join(messages, "; ")
+}
 
-		for {
-			_, message, err := c.ReadMessage()
-			if err != nil {
-				logger.Errorf("error reading from websocket subscription: %v:", err)
-				break
-			}
-			messageStr := strings.Replace(string(message), "\\", "", -1)
-			logger.Infof("received %v via websocket subscriptions", messageStr)
+// StatusError is returned when the node answers a query with an unexpected http status code and no graphql errors
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
 
-			parsedNotification := &newBlockNotification{}
-			err = json.Unmarshal([]byte(messageStr), parsedNotification)
-			if err != nil {
-				logger.Errorf("error parsing new block notification: %v:", err)
-				break
-			}
-			newBlockChan <- parsedNotification.Payload.Data.NewBlock.StateHash
-		}
-		c.Close()
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected http status %v: %v", e.StatusCode, e.Body)
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
 	}
+	return s[:length] + "..."
 }
 
-type newBlockNotification struct {
-	ID      string `json:"id"`
-	Payload struct {
-		Data struct {
-			NewBlock struct {
-				StateHash string `json:"stateHash"`
-			} `json:"newBlock"`
-		} `json:"data"`
-	} `json:"payload"`
-	Type string `json:"type"`
+// SubscribeNewBlocks delivers the new blocks of the node until the context is done
+func (cc *CodaClient) SubscribeNewBlocks(ctx context.Context) <-chan *NewBlockEvent {
+	return cc.subscriptions.SubscribeNewBlocks(ctx)
 }
 
-// GetLastBlocks retrieves the last <lookback> block hashes
-func (cc *CodaClient) GetLastBlocks(lookback int) ([]*types.Block, error) {
+// SubscribeChainReorganizations delivers the chain reorganizations of the node until the context is done
+func (cc *CodaClient) SubscribeChainReorganizations(ctx context.Context) <-chan *ChainReorganizationEvent {
+	return cc.subscriptions.SubscribeChainReorganizations(ctx)
+}
+
+// SubscribeSyncUpdates delivers the sync status changes of the node until the context is done
+func (cc *CodaClient) SubscribeSyncUpdates(ctx context.Context) <-chan *SyncUpdateEvent {
+	return cc.subscriptions.SubscribeSyncUpdates(ctx)
+}
 
-	query := `{
-				blocks(last: ` + strconv.Itoa(lookback) + `) {
-					nodes {
+// Selection set of all block fields required for indexing a block
+const blockFields = `
 						stateHash
 						protocolState {
 							previousStateHash
@@ -186,141 +264,263 @@ func (cc *CodaClient) GetLastBlocks(lookback int) ([]*types.Block, error) {
 						}
 						creatorAccount {
 							publicKey
-						}
+						}`
+
+// GetLastBlocks retrieves the last <lookback> blocks, blocks failing validation are returned separately
+func (cc *CodaClient) GetLastBlocks(ctx context.Context, lookback int) ([]*types.Block, []*InvalidBlock, error) {
+
+	query := `query ($last: Int!) {
+				blocks(last: $last) {
+					nodes {` + blockFields + `
 					}
 				}
 			}`
 
 	var resp getBlocksResponse
-	err := cc.getData(query, &resp)
+	err := cc.getData(ctx, "blocks", query, map[string]interface{}{"last": lookback}, &resp)
 	if err != nil {
-		return nil, fmt.Errorf("error executing last block hashes graphql query: %w", err)
+		return nil, nil, fmt.Errorf("error executing last block hashes graphql query: %w", err)
 	}
 
-	blocks := make([]*types.Block, len(resp.Data.Blocks.Nodes))
+	blocks := make([]*types.Block, 0, len(resp.Blocks.Nodes))
+	var invalid []*InvalidBlock
 
-	for i, b := range resp.Data.Blocks.Nodes {
-
-		block := &types.Block{
-			StateHash:         b.StateHash,
-			PreviousStateHash: b.ProtocolState.PreviousStateHash,
-			SnarkedLedgerHash: b.ProtocolState.BlockchainState.SnarkedLedgerHash,
-			StagedLedgerHash:  b.ProtocolState.BlockchainState.StagedLedgerHash,
-			Coinbase:          util.MustParseInt(b.Transactions.Coinbase),
-			Creator:           b.CreatorAccount.PublicKey,
-			Slot:              util.MustParseInt(b.ProtocolState.ConsensusState.Slot),
-			Height:            util.MustParseInt(b.ProtocolState.ConsensusState.BlockchainLength),
-			Epoch:             util.MustParseInt(b.ProtocolState.ConsensusState.Epoch),
-			Ts:                util.MustParseJsTimestamp(b.ProtocolState.BlockchainState.Date),
-			TotalCurrency:     util.MustParseInt(b.ProtocolState.ConsensusState.TotalCurrency),
-			UserCommandsCount: len(b.Transactions.UserCommands),
-			SnarkJobsCount:    len(b.SnarkJobs),
-			FeeTransferCount:  len(b.Transactions.FeeTransfer),
-			UserJobs:          make([]*types.UserJob, len(b.Transactions.UserCommands)),
-			SnarkJobs:         make([]*types.SnarkJob, len(b.SnarkJobs)),
-			FeeTransfers:      make([]*types.FeeTransfer, len(b.Transactions.FeeTransfer)),
+	for _, raw := range resp.Blocks.Nodes {
+		block, invalidBlock := decodeBlock(raw)
+		if invalidBlock != nil {
+			invalid = append(invalid, invalidBlock)
+			continue
 		}
+		blocks = append(blocks, block)
+	}
 
-		for i, job := range b.Transactions.UserCommands {
-			block.UserJobs[i] = &types.UserJob{
-				BlockStateHash: b.StateHash,
-				Index:          i,
-				ID:             job.ID,
-				Sender:         job.From,
-				Recipient:      job.To,
-				Memo:           job.Memo,
-				Fee:            util.MustParseInt(job.Fee),
-				Amount:         util.MustParseInt(job.Amount),
-				Nonce:          job.Nonce,
-				Delegation:     job.IsDelegation,
-			}
-		}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Height < blocks[j].Height
+	})
+	return blocks, invalid, nil
+}
 
-		for i, sj := range b.SnarkJobs {
-			block.SnarkJobs[i] = &types.SnarkJob{
-				BlockStateHash: b.StateHash,
-				Index:          i,
-				Jobids:         sj.WorkIds,
-				Prover:         sj.Prover,
-				Fee:            util.MustParseInt(sj.Fee),
-			}
-		}
+// GetBlock retrieves a single block by its state hash, a block failing validation is returned as *InvalidBlock error
+func (cc *CodaClient) GetBlock(ctx context.Context, stateHash string) (*types.Block, error) {
 
-		for i, ft := range b.Transactions.FeeTransfer {
-			block.FeeTransfers[i] = &types.FeeTransfer{
-				BlockStateHash: b.StateHash,
-				Index:          i,
-				Recipient:      ft.Recipient,
-				Fee:            util.MustParseInt(ft.Fee),
-			}
-		}
+	query := `query ($stateHash: String!) {
+				block(stateHash: $stateHash) {` + blockFields + `
+				}
+			}`
 
-		blocks[i] = block
+	var resp getBlockResponse
+	err := cc.getData(ctx, "block", query, map[string]interface{}{"stateHash": stateHash}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error executing get block graphql query: %w", err)
 	}
 
-	sort.Slice(blocks, func(i, j int) bool {
-		return blocks[i].Height < blocks[j].Height
-	})
+	if len(resp.Block) == 0 || string(resp.Block) == "null" {
+		return nil, fmt.Errorf("block %v %w", stateHash, ErrNotFound)
+	}
+
+	block, invalid := decodeBlock(resp.Block)
+	if invalid != nil {
+		return nil, invalid
+	}
+	return block, nil
+}
+
+// GetBestChainHashes retrieves the state hashes, parent hashes and heights of the last <maxLength> blocks of the best chain
+func (cc *CodaClient) GetBestChainHashes(ctx context.Context, maxLength int) ([]*types.BlockHashNumber, error) {
+	query := `query ($maxLength: Int!) {
+				bestChain(maxLength: $maxLength) {
+					stateHash
+					protocolState {
+						previousStateHash
+						consensusState {
+							blockchainLength
+						}
+					}
+				}
+			}`
+
+	var resp getBestChainHashesResponse
+	err := cc.getData(ctx, "bestChain", query, map[string]interface{}{"maxLength": maxLength}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error executing best chain hashes graphql query: %w", err)
+	}
+
+	blocks := make([]*types.BlockHashNumber, len(resp.BestChain))
+	for i, b := range resp.BestChain {
+		height, err := strconv.Atoi(b.ProtocolState.ConsensusState.BlockchainLength)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing height of block %v: %w", b.StateHash, err)
+		}
+		blocks[i] = &types.BlockHashNumber{
+			StateHash:         b.StateHash,
+			PreviousStateHash: b.ProtocolState.PreviousStateHash,
+			Height:            height,
+		}
+	}
 	return blocks, nil
 }
 
+// Type for parsing the best chain hashes graphql query response
+type getBestChainHashesResponse struct {
+	BestChain []struct {
+		StateHash     string `json:"stateHash"`
+		ProtocolState struct {
+			PreviousStateHash string `json:"previousStateHash"`
+			ConsensusState    struct {
+				BlockchainLength string `json:"blockchainLength"`
+			} `json:"consensusState"`
+		} `json:"protocolState"`
+	} `json:"bestChain"`
+}
+
 // Type for parsing the last block hashes graphql query response
 type getBlocksResponse struct {
-	Data struct {
-		Blocks struct {
-			Nodes []struct {
-				StateHash     string `json:"stateHash"`
-				ProtocolState struct {
-					BlockchainState struct {
-						Date              string `json:"date"`
-						SnarkedLedgerHash string `json:"snarkedLedgerHash"`
-						StagedLedgerHash  string `json:"stagedLedgerHash"`
-					} `json:"blockchainState"`
-					ConsensusState struct {
-						BlockchainLength string `json:"blockchainLength"`
-						Epoch            string `json:"epoch"`
-						Slot             string `json:"slot"`
-						TotalCurrency    string `json:"totalCurrency"`
-					} `json:"consensusState"`
-					PreviousStateHash string `json:"previousStateHash"`
-				} `json:"protocolState"`
-				SnarkJobs []struct {
-					Fee     string  `json:"fee"`
-					Prover  string  `json:"prover"`
-					WorkIds []int64 `json:"workIds"`
-				} `json:"snarkJobs"`
-				Transactions struct {
-					Coinbase    string `json:"coinbase"`
-					FeeTransfer []struct {
-						Fee       string `json:"fee"`
-						Recipient string `json:"recipient"`
-					} `json:"feeTransfer"`
-					UserCommands []struct {
-						Amount       string `json:"amount"`
-						Fee          string `json:"fee"`
-						From         string `json:"from"`
-						ID           string `json:"id"`
-						IsDelegation bool   `json:"isDelegation"`
-						Memo         string `json:"memo"`
-						Nonce        int    `json:"nonce"`
-						To           string `json:"to"`
-					} `json:"userCommands"`
-				} `json:"transactions"`
-				CreatorAccount struct {
-					PublicKey string `json:"publicKey"`
-				} `json:"creatorAccount"`
-			} `json:"nodes"`
-		} `json:"blocks"`
-	} `json:"data"`
+	Blocks struct {
+		Nodes []json.RawMessage `json:"nodes"`
+	} `json:"blocks"`
+}
+
+// Type for parsing the get block graphql query response
+type getBlockResponse struct {
+	Block json.RawMessage `json:"block"`
+}
+
+// Type for parsing a single block of a graphql query response
+type graphqlBlock struct {
+	StateHash     string `json:"stateHash"`
+	ProtocolState struct {
+		BlockchainState struct {
+			Date              string `json:"date"`
+			SnarkedLedgerHash string `json:"snarkedLedgerHash"`
+			StagedLedgerHash  string `json:"stagedLedgerHash"`
+		} `json:"blockchainState"`
+		ConsensusState struct {
+			BlockchainLength string `json:"blockchainLength"`
+			Epoch            string `json:"epoch"`
+			Slot             string `json:"slot"`
+			TotalCurrency    string `json:"totalCurrency"`
+		} `json:"consensusState"`
+		PreviousStateHash string `json:"previousStateHash"`
+	} `json:"protocolState"`
+	SnarkJobs []struct {
+		Fee     string  `json:"fee"`
+		Prover  string  `json:"prover"`
+		WorkIds []int64 `json:"workIds"`
+	} `json:"snarkJobs"`
+	Transactions struct {
+		Coinbase    string `json:"coinbase"`
+		FeeTransfer []struct {
+			Fee       string `json:"fee"`
+			Recipient string `json:"recipient"`
+		} `json:"feeTransfer"`
+		UserCommands []*graphqlUserCommand `json:"userCommands"`
+	} `json:"transactions"`
+	CreatorAccount struct {
+		PublicKey string `json:"publicKey"`
+	} `json:"creatorAccount"`
+}
+
+// Type for parsing a single user command of a graphql query response
+type graphqlUserCommand struct {
+	Amount       string `json:"amount"`
+	Fee          string `json:"fee"`
+	From         string `json:"from"`
+	ID           string `json:"id"`
+	IsDelegation bool   `json:"isDelegation"`
+	Memo         string `json:"memo"`
+	Nonce        int    `json:"nonce"`
+	To           string `json:"to"`
 }
 
 // GetAccount retrieves account information by the account public key
-func (cc *CodaClient) GetAccount(publicKey string) (*types.Account, error) {
+func (cc *CodaClient) GetAccount(ctx context.Context, publicKey string) (*types.Account, error) {
 
 	logger.Printf("receiving data for account %v", publicKey)
-	query := `query {
-  				account(publicKey: "` + publicKey + `") {
-					balance {
+	query := `query ($publicKey: PublicKey!) {
+  				account(publicKey: $publicKey) {
+					` + accountFields + `
+				  }
+				}`
+
+	var resp getAccountResponse
+	err := cc.getData(ctx, "account", query, map[string]interface{}{"publicKey": publicKey}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error executing get account graphql query: %w", err)
+	}
+	if resp.Account == nil {
+		return nil, fmt.Errorf("account %v %w", publicKey, ErrNotFound)
+	}
+
+	return parseAccount(publicKey, resp.Account)
+}
+
+// GetAccounts retrieves the current state of multiple accounts using aliased account queries. Also returns the state
+// hash of the best tip the states have been read at, the state hash is empty if the best tip changed in between queries.
+func (cc *CodaClient) GetAccounts(ctx context.Context, publicKeys []string) (map[string]*types.Account, string, error) {
+	accounts := make(map[string]*types.Account, len(publicKeys))
+	tip := ""
+
+	for start := 0; start < len(publicKeys); start += accountsPerQuery {
+		end := start + accountsPerQuery
+		if end > len(publicKeys) {
+			end = len(publicKeys)
+		}
+		chunk := publicKeys[start:end]
+
+		logger.Printf("receiving data for %v accounts", len(chunk))
+		declarations := make([]string, len(chunk))
+		selections := &strings.Builder{}
+		variables := make(map[string]interface{}, len(chunk))
+		for i, publicKey := range chunk {
+			declarations[i] = fmt.Sprintf("$pk%d: PublicKey!", i)
+			fmt.Fprintf(selections, "account%d: account(publicKey: $pk%d) {\n%s\n}\n", i, i, accountFields)
+			variables["pk"+strconv.Itoa(i)] = publicKey
+		}
+		query := "query (" + strings.Join(declarations, ", ") + ") {\n" + selections.String() + "bestChain(maxLength: 1) {\nstateHash\n}\n}"
+
+		var resp getAccountsResponse
+		err := cc.getData(ctx, "accounts", query, variables, &resp)
+		if err != nil {
+			return nil, "", fmt.Errorf("error executing get accounts graphql query: %w", err)
+		}
+
+		var bestChain []struct {
+			StateHash string `json:"stateHash"`
+		}
+		err = json.Unmarshal(resp["bestChain"], &bestChain)
+		if err != nil {
+			return nil, "", fmt.Errorf("error decoding best chain of get accounts graphql query response: %w", err)
+		}
+		if len(bestChain) == 0 {
+			return nil, "", fmt.Errorf("error decoding get accounts graphql query response: best chain is empty")
+		}
+		if start == 0 {
+			tip = bestChain[0].StateHash
+		} else if tip != bestChain[0].StateHash {
+			tip = ""
+		}
+
+		for i, publicKey := range chunk {
+			var account *graphqlAccount
+			err = json.Unmarshal(resp["account"+strconv.Itoa(i)], &account)
+			if err != nil {
+				return nil, "", fmt.Errorf("error decoding account %v of get accounts graphql query response: %w", publicKey, err)
+			}
+			if account == nil {
+				return nil, "", fmt.Errorf("account %v %w", publicKey, ErrNotFound)
+			}
+			accounts[publicKey], err = parseAccount(publicKey, account)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	return accounts, tip, nil
+}
+
+// Fields of the account graphql type queried by the client
+const accountFields = `balance {
 					  total
 					}
 					nonce
@@ -328,27 +528,41 @@ func (cc *CodaClient) GetAccount(publicKey string) (*types.Account, error) {
 					delegateAccount {
 					  publicKey
 					}
-					votingFor
-				  }
-				}`
+					votingFor`
 
-	var resp getAccountResponse
-	err := cc.getData(query, &resp)
+// Parses an amount reported by the node, amounts of accounts and user commands must not be negative
+func parseNodeAmount(value string) (types.Amount, error) {
+	amount, err := types.ParseAmount(value)
 	if err != nil {
-		return nil, fmt.Errorf("error executing get account graphql query: %w", err)
+		return types.Amount{}, err
+	}
+	if amount.Sign() < 0 {
+		return types.Amount{}, fmt.Errorf("invalid amount %q: %w", value, errNegative)
+	}
+	return amount, nil
+}
+
+func parseAccount(publicKey string, resp *graphqlAccount) (*types.Account, error) {
+	if resp.Nonce == "" { // For some accounts the node returns NULL as nonce
+		resp.Nonce = "0"
 	}
 
-	if resp.Data.Account.Nonce == "" { // For some accounts the node returns NULL as nonce
-		resp.Data.Account.Nonce = "0"
+	balance, err := parseNodeAmount(resp.Balance.Total)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing balance of account %v: %w", publicKey, err)
+	}
+	nonce, err := strconv.Atoi(resp.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing nonce of account %v: %w", publicKey, err)
 	}
 
 	account := &types.Account{
 		PublicKey:        publicKey,
-		Balance:          util.MustParseInt(resp.Data.Account.Balance.Total),
-		Nonce:            util.MustParseInt(resp.Data.Account.Nonce),
-		ReceiptChainHash: resp.Data.Account.ReceiptChainHash,
-		Delegate:         resp.Data.Account.DelegateAccount.PublicKey,
-		VotingFor:        resp.Data.Account.VotingFor,
+		Balance:          balance,
+		Nonce:            nonce,
+		ReceiptChainHash: resp.ReceiptChainHash,
+		Delegate:         resp.DelegateAccount.PublicKey,
+		VotingFor:        resp.VotingFor,
 		TxSent:           0,
 		TxReceived:       0,
 		BlocksProposed:   0,
@@ -361,23 +575,26 @@ func (cc *CodaClient) GetAccount(publicKey string) (*types.Account, error) {
 
 // Type for parsing the account information graphql query response
 type getAccountResponse struct {
-	Data struct {
-		Account struct {
-			Balance struct {
-				Total string `json:"total"`
-			} `json:"balance"`
-			DelegateAccount struct {
-				PublicKey string `json:"publicKey"`
-			} `json:"delegateAccount"`
-			Nonce            string `json:"nonce"`
-			ReceiptChainHash string `json:"receiptChainHash"`
-			VotingFor        string `json:"votingFor"`
-		} `json:"account"`
-	} `json:"data"`
+	Account *graphqlAccount `json:"account"`
+}
+
+// Type for parsing the aliased multi account graphql query response
+type getAccountsResponse map[string]json.RawMessage
+
+type graphqlAccount struct {
+	Balance struct {
+		Total string `json:"total"`
+	} `json:"balance"`
+	DelegateAccount struct {
+		PublicKey string `json:"publicKey"`
+	} `json:"delegateAccount"`
+	Nonce            string `json:"nonce"`
+	ReceiptChainHash string `json:"receiptChainHash"`
+	VotingFor        string `json:"votingFor"`
 }
 
 // GetDaemonStatus retrieves the current daemon status
-func (cc *CodaClient) GetDaemonStatus() (*types.DaemonStatus, error) {
+func (cc *CodaClient) GetDaemonStatus(ctx context.Context) (*types.DaemonStatus, error) {
 	query := `query {
 			  daemonStatus {
 				blockchainLength
@@ -400,50 +617,100 @@ func (cc *CodaClient) GetDaemonStatus() (*types.DaemonStatus, error) {
 			`
 
 	var resp getDaemonStatusResponse
-	err := cc.getData(query, &resp)
+	err := cc.getData(ctx, "daemonStatus", query, nil, &resp)
 	if err != nil {
 		return nil, fmt.Errorf("error executing get daemon status graphql query: %w", err)
 	}
 
 	daemonStatus := &types.DaemonStatus{
 		Ts:                         time.Now(),
-		BlockchainLength:           resp.Data.DaemonStatus.BlockchainLength,
-		CommitID:                   resp.Data.DaemonStatus.CommitID,
-		EpochDuration:              resp.Data.DaemonStatus.ConsensusConfiguration.EpochDuration,
-		SlotDuration:               resp.Data.DaemonStatus.ConsensusConfiguration.SlotDuration,
-		SlotsPerEpoch:              resp.Data.DaemonStatus.ConsensusConfiguration.SlotsPerEpoch,
-		ConsensusMechanism:         resp.Data.DaemonStatus.ConsensusMechanism,
-		HighestBlockLengthReceived: resp.Data.DaemonStatus.HighestBlockLengthReceived,
-		LedgerMerkleRoot:           resp.Data.DaemonStatus.LedgerMerkleRoot,
-		NumAccounts:                resp.Data.DaemonStatus.NumAccounts,
-		Peers:                      resp.Data.DaemonStatus.Peers,
-		PeersCount:                 len(resp.Data.DaemonStatus.Peers),
-		StateHash:                  resp.Data.DaemonStatus.StateHash,
-		SyncStatus:                 resp.Data.DaemonStatus.SyncStatus,
-		Uptime:                     resp.Data.DaemonStatus.UptimeSecs,
+		BlockchainLength:           resp.DaemonStatus.BlockchainLength,
+		CommitID:                   resp.DaemonStatus.CommitID,
+		EpochDuration:              resp.DaemonStatus.ConsensusConfiguration.EpochDuration,
+		SlotDuration:               resp.DaemonStatus.ConsensusConfiguration.SlotDuration,
+		SlotsPerEpoch:              resp.DaemonStatus.ConsensusConfiguration.SlotsPerEpoch,
+		ConsensusMechanism:         resp.DaemonStatus.ConsensusMechanism,
+		HighestBlockLengthReceived: resp.DaemonStatus.HighestBlockLengthReceived,
+		LedgerMerkleRoot:           resp.DaemonStatus.LedgerMerkleRoot,
+		NumAccounts:                resp.DaemonStatus.NumAccounts,
+		Peers:                      resp.DaemonStatus.Peers,
+		PeersCount:                 len(resp.DaemonStatus.Peers),
+		StateHash:                  resp.DaemonStatus.StateHash,
+		SyncStatus:                 resp.DaemonStatus.SyncStatus,
+		Uptime:                     resp.DaemonStatus.UptimeSecs,
 	}
 	return daemonStatus, nil
 }
 
 // Type for parsing the daemon status graphql query response
 type getDaemonStatusResponse struct {
-	Data struct {
-		DaemonStatus struct {
-			BlockchainLength       int    `json:"blockchainLength"`
-			CommitID               string `json:"commitId"`
-			ConsensusConfiguration struct {
-				EpochDuration int `json:"epochDuration"`
-				SlotDuration  int `json:"slotDuration"`
-				SlotsPerEpoch int `json:"slotsPerEpoch"`
-			} `json:"consensusConfiguration"`
-			ConsensusMechanism         string   `json:"consensusMechanism"`
-			HighestBlockLengthReceived int      `json:"highestBlockLengthReceived"`
-			LedgerMerkleRoot           string   `json:"ledgerMerkleRoot"`
-			NumAccounts                int      `json:"numAccounts"`
-			Peers                      []string `json:"peers"`
-			StateHash                  string   `json:"stateHash"`
-			SyncStatus                 string   `json:"syncStatus"`
-			UptimeSecs                 int      `json:"uptimeSecs"`
-		} `json:"daemonStatus"`
-	} `json:"data"`
+	DaemonStatus struct {
+		BlockchainLength       int    `json:"blockchainLength"`
+		CommitID               string `json:"commitId"`
+		ConsensusConfiguration struct {
+			EpochDuration int `json:"epochDuration"`
+			SlotDuration  int `json:"slotDuration"`
+			SlotsPerEpoch int `json:"slotsPerEpoch"`
+		} `json:"consensusConfiguration"`
+		ConsensusMechanism         string   `json:"consensusMechanism"`
+		HighestBlockLengthReceived int      `json:"highestBlockLengthReceived"`
+		LedgerMerkleRoot           string   `json:"ledgerMerkleRoot"`
+		NumAccounts                int      `json:"numAccounts"`
+		Peers                      []string `json:"peers"`
+		StateHash                  string   `json:"stateHash"`
+		SyncStatus                 string   `json:"syncStatus"`
+		UptimeSecs                 int      `json:"uptimeSecs"`
+	} `json:"daemonStatus"`
+}
+
+// GetPooledUserCommands retrieves all user commands currently pending in the transaction pool of the node
+func (cc *CodaClient) GetPooledUserCommands(ctx context.Context) ([]*types.MempoolTransaction, error) {
+	query := `query {
+			  pooledUserCommands {
+				amount
+				fee
+				from
+				id
+				isDelegation
+				memo
+				nonce
+				to
+			  }
+			}
+			`
+
+	var resp getPooledUserCommandsResponse
+	err := cc.getData(ctx, "pooledUserCommands", query, nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error executing get pooled user commands graphql query: %w", err)
+	}
+
+	txs := make([]*types.MempoolTransaction, len(resp.PooledUserCommands))
+	for i, uc := range resp.PooledUserCommands {
+		fee, err := parseNodeAmount(uc.Fee)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fee of pooled user command %v: %w", uc.ID, err)
+		}
+		amount, err := parseNodeAmount(uc.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing amount of pooled user command %v: %w", uc.ID, err)
+		}
+
+		txs[i] = &types.MempoolTransaction{
+			ID:         uc.ID,
+			Sender:     uc.From,
+			Recipient:  uc.To,
+			Memo:       uc.Memo,
+			Fee:        fee,
+			Amount:     amount,
+			Nonce:      uc.Nonce,
+			Delegation: uc.IsDelegation,
+		}
+	}
+	return txs, nil
+}
+
+// Type for parsing the pooled user commands graphql query response
+type getPooledUserCommandsResponse struct {
+	PooledUserCommands []*graphqlUserCommand `json:"pooledUserCommands"`
 }
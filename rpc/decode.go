@@ -0,0 +1,220 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"coda-explorer/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError describes a single field of a block returned by the node that could not be converted
+type ValidationError struct {
+	BlockStateHash string
+	Field          string
+	Value          string
+	Err            error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value %q of field %v in block %v: %v", e.Value, e.Field, e.BlockStateHash, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidBlock is a block returned by the node that failed validation together with its raw payload. The state hash,
+// parent hash and height are taken from the payload as far as they could be read, the height is zero if it is unknown.
+type InvalidBlock struct {
+	StateHash         string
+	PreviousStateHash string
+	Height            int
+	Payload           json.RawMessage
+	Errors            []*ValidationError
+}
+
+func (b *InvalidBlock) Error() string {
+	messages := make([]string, len(b.Errors))
+	for i, e := range b.Errors {
+		messages[i] = e.Error()
+	}
+	return fmt.Sprintf("block %v failed validation: %v", b.StateHash, strings.Join(messages, "; "))
+}
+
+var (
+	errEmpty    = errors.New("value must not be empty")
+	errNegative = errors.New("value must not be negative")
+)
+
+// Collects the validation errors of a single block
+type blockValidator struct {
+	stateHash string
+	errors    []*ValidationError
+}
+
+func (v *blockValidator) fail(field, value string, err error) {
+	v.errors = append(v.errors, &ValidationError{BlockStateHash: v.stateHash, Field: field, Value: value, Err: err})
+}
+
+func (v *blockValidator) nonEmpty(field, value string) string {
+	if value == "" {
+		v.fail(field, value, errEmpty)
+	}
+	return value
+}
+
+func (v *blockValidator) int(field, value string) int {
+	res, err := strconv.Atoi(value)
+	if err != nil {
+		v.fail(field, value, err)
+		return 0
+	}
+	if res < 0 {
+		v.fail(field, value, errNegative)
+		return 0
+	}
+	return res
+}
+
+func (v *blockValidator) amount(field, value string) types.Amount {
+	res, err := types.ParseAmount(value)
+	if err != nil {
+		v.fail(field, value, err)
+		return types.Amount{}
+	}
+	if res.Sign() < 0 {
+		v.fail(field, value, errNegative)
+		return types.Amount{}
+	}
+	return res
+}
+
+// Parses a timestamp in js timestamp format
+func (v *blockValidator) jsTimestamp(field, value string) time.Time {
+	res, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		v.fail(field, value, err)
+		return time.Time{}
+	}
+	if res <= 0 {
+		v.fail(field, value, errors.New("value must be positive"))
+		return time.Time{}
+	}
+	return time.Unix(res/1000, 0)
+}
+
+// Decodes and validates a single block of a graphql query response. All validation errors of the block are collected,
+// a block failing validation is returned as InvalidBlock.
+func decodeBlock(raw json.RawMessage) (*types.Block, *InvalidBlock) {
+	b := &graphqlBlock{}
+	// Unmarshal continues decoding after a type mismatch, the state hash is thus available in most cases
+	err := json.Unmarshal(raw, b)
+
+	v := &blockValidator{stateHash: b.StateHash}
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			v.fail("", string(raw), err)
+			return nil, &InvalidBlock{StateHash: b.StateHash, Payload: raw, Errors: v.errors}
+		}
+		v.fail(typeErr.Field, typeErr.Value, err)
+	}
+
+	consensusState := b.ProtocolState.ConsensusState
+	blockchainState := b.ProtocolState.BlockchainState
+
+	block := &types.Block{
+		StateHash:         v.nonEmpty("stateHash", b.StateHash),
+		PreviousStateHash: b.ProtocolState.PreviousStateHash,
+		SnarkedLedgerHash: blockchainState.SnarkedLedgerHash,
+		StagedLedgerHash:  blockchainState.StagedLedgerHash,
+		Coinbase:          v.amount("transactions.coinbase", b.Transactions.Coinbase),
+		Creator:           v.nonEmpty("creatorAccount.publicKey", b.CreatorAccount.PublicKey),
+		Slot:              v.int("protocolState.consensusState.slot", consensusState.Slot),
+		Height:            v.int("protocolState.consensusState.blockchainLength", consensusState.BlockchainLength),
+		Epoch:             v.int("protocolState.consensusState.epoch", consensusState.Epoch),
+		Ts:                v.jsTimestamp("protocolState.blockchainState.date", blockchainState.Date),
+		TotalCurrency:     v.amount("protocolState.consensusState.totalCurrency", consensusState.TotalCurrency),
+		UserCommandsCount: len(b.Transactions.UserCommands),
+		SnarkJobsCount:    len(b.SnarkJobs),
+		FeeTransferCount:  len(b.Transactions.FeeTransfer),
+		UserJobs:          make([]*types.UserJob, len(b.Transactions.UserCommands)),
+		SnarkJobs:         make([]*types.SnarkJob, len(b.SnarkJobs)),
+		FeeTransfers:      make([]*types.FeeTransfer, len(b.Transactions.FeeTransfer)),
+	}
+
+	for i, job := range b.Transactions.UserCommands {
+		field := fmt.Sprintf("transactions.userCommands[%d].", i)
+		if job == nil {
+			v.fail(field[:len(field)-1], "null", errEmpty)
+			continue
+		}
+		if job.Nonce < 0 {
+			v.fail(field+"nonce", strconv.Itoa(job.Nonce), errNegative)
+		}
+
+		block.UserJobs[i] = &types.UserJob{
+			BlockStateHash: b.StateHash,
+			Index:          i,
+			ID:             v.nonEmpty(field+"id", job.ID),
+			Sender:         v.nonEmpty(field+"from", job.From),
+			Recipient:      v.nonEmpty(field+"to", job.To),
+			Memo:           job.Memo,
+			Fee:            v.amount(field+"fee", job.Fee),
+			Amount:         v.amount(field+"amount", job.Amount),
+			Nonce:          job.Nonce,
+			Delegation:     job.IsDelegation,
+		}
+	}
+
+	for i, sj := range b.SnarkJobs {
+		field := fmt.Sprintf("snarkJobs[%d].", i)
+		block.SnarkJobs[i] = &types.SnarkJob{
+			BlockStateHash: b.StateHash,
+			Index:          i,
+			Jobids:         sj.WorkIds,
+			Prover:         v.nonEmpty(field+"prover", sj.Prover),
+			Fee:            v.amount(field+"fee", sj.Fee),
+		}
+	}
+
+	for i, ft := range b.Transactions.FeeTransfer {
+		field := fmt.Sprintf("transactions.feeTransfer[%d].", i)
+		block.FeeTransfers[i] = &types.FeeTransfer{
+			BlockStateHash: b.StateHash,
+			Index:          i,
+			Recipient:      v.nonEmpty(field+"recipient", ft.Recipient),
+			Fee:            v.amount(field+"fee", ft.Fee),
+		}
+	}
+
+	if len(v.errors) > 0 {
+		return nil, &InvalidBlock{
+			StateHash:         b.StateHash,
+			PreviousStateHash: b.ProtocolState.PreviousStateHash,
+			Height:            block.Height,
+			Payload:           raw,
+			Errors:            v.errors,
+		}
+	}
+	return block, nil
+}
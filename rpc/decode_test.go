@@ -0,0 +1,198 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validBlockPayload = `{
+	"stateHash": "state-2",
+	"protocolState": {
+		"previousStateHash": "state-1",
+		"blockchainState": {"date": "1577836980000", "snarkedLedgerHash": "snarked", "stagedLedgerHash": "staged"},
+		"consensusState": {"blockchainLength": "2", "epoch": "0", "slot": "3", "totalCurrency": "40000000000"}
+	},
+	"snarkJobs": [{"fee": "5", "prover": "prover", "workIds": [1, 2]}],
+	"transactions": {
+		"coinbase": "20000000000",
+		"feeTransfer": [{"fee": "10", "recipient": "creator"}],
+		"userCommands": [{"amount": "1000", "fee": "10", "from": "alice", "id": "tx-1", "isDelegation": false, "memo": "memo", "nonce": 4, "to": "bob"}]
+	},
+	"creatorAccount": {"publicKey": "creator"}
+}`
+
+// Returns the valid block payload with the value at the dot separated path replaced. Array elements are addressed by
+// their index, a nil value removes an object field and sets an array element to null.
+func blockPayload(t *testing.T, path string, value interface{}) json.RawMessage {
+	var payload interface{}
+	err := json.Unmarshal([]byte(validBlockPayload), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "" {
+		keys := strings.Split(path, ".")
+		parent := payload
+		for _, key := range keys {
+			last := key == keys[len(keys)-1]
+			switch p := parent.(type) {
+			case map[string]interface{}:
+				if !last {
+					parent = p[key]
+				} else if value == nil {
+					delete(p, key)
+				} else {
+					p[key] = value
+				}
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil {
+					t.Fatalf("invalid index %v of path %v", key, path)
+				}
+				if last {
+					p[i] = value
+				} else {
+					parent = p[i]
+				}
+			}
+		}
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeBlock(t *testing.T) {
+	block, invalid := decodeBlock(blockPayload(t, "", nil))
+	if invalid != nil {
+		t.Fatalf("valid block failed validation: %v", invalid)
+	}
+
+	if block.StateHash != "state-2" || block.PreviousStateHash != "state-1" || block.Height != 2 || block.Slot != 3 || block.Epoch != 0 ||
+		block.Creator != "creator" || block.SnarkedLedgerHash != "snarked" || block.StagedLedgerHash != "staged" {
+		t.Errorf("unexpected block %+v", block)
+	}
+	if block.Coinbase.String() != "20000000000" || block.TotalCurrency.String() != "40000000000" {
+		t.Errorf("unexpected amounts %v, %v", block.Coinbase, block.TotalCurrency)
+	}
+	if !block.Ts.Equal(time.Date(2020, 1, 1, 0, 3, 0, 0, time.UTC)) {
+		t.Errorf("timestamp is %v", block.Ts)
+	}
+	if block.UserCommandsCount != 1 || block.SnarkJobsCount != 1 || block.FeeTransferCount != 1 {
+		t.Fatalf("unexpected counts %v, %v, %v", block.UserCommandsCount, block.SnarkJobsCount, block.FeeTransferCount)
+	}
+
+	uj := block.UserJobs[0]
+	if uj.BlockStateHash != "state-2" || uj.ID != "tx-1" || uj.Sender != "alice" || uj.Recipient != "bob" || uj.Amount.String() != "1000" || uj.Fee.String() != "10" || uj.Nonce != 4 || uj.Memo != "memo" || uj.Delegation {
+		t.Errorf("unexpected user job %+v", uj)
+	}
+	sj := block.SnarkJobs[0]
+	if sj.Prover != "prover" || sj.Fee.String() != "5" || len(sj.Jobids) != 2 || sj.Jobids[1] != 2 {
+		t.Errorf("unexpected snark job %+v", sj)
+	}
+	ft := block.FeeTransfers[0]
+	if ft.Recipient != "creator" || ft.Fee.String() != "10" {
+		t.Errorf("unexpected fee transfer %+v", ft)
+	}
+}
+
+func TestDecodeBlockValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload json.RawMessage
+		// Fields expected to be reported as invalid
+		fields []string
+	}{
+		// Malformed json
+		{"truncated json", json.RawMessage(`{"stateHash": "state-2", "protocolState": {`), []string{""}},
+		{"not an object", json.RawMessage(`"state-2"`), []string{"", "stateHash", "creatorAccount.publicKey"}},
+
+		// Missing fields
+		{"missing state hash", blockPayload(t, "stateHash", nil), []string{"stateHash"}},
+		{"missing creator", blockPayload(t, "creatorAccount", nil), []string{"creatorAccount.publicKey"}},
+		{"missing coinbase", blockPayload(t, "transactions.coinbase", nil), []string{"transactions.coinbase"}},
+		{"missing height", blockPayload(t, "protocolState.consensusState.blockchainLength", nil), []string{"protocolState.consensusState.blockchainLength"}},
+		{"missing date", blockPayload(t, "protocolState.blockchainState.date", nil), []string{"protocolState.blockchainState.date"}},
+		{"missing sender", blockPayload(t, "transactions.userCommands.0.from", nil), []string{"transactions.userCommands[0].from"}},
+		{"missing prover", blockPayload(t, "snarkJobs.0.prover", nil), []string{"snarkJobs[0].prover"}},
+		{"missing fee transfer fee", blockPayload(t, "transactions.feeTransfer.0.fee", nil), []string{"transactions.feeTransfer[0].fee"}},
+		{"null user command", blockPayload(t, "transactions.userCommands.0", nil), []string{"transactions.userCommands[0]"}},
+		{"empty user command", blockPayload(t, "transactions.userCommands.0", map[string]interface{}{}), []string{"transactions.userCommands[0].id", "transactions.userCommands[0].from", "transactions.userCommands[0].to", "transactions.userCommands[0].fee", "transactions.userCommands[0].amount"}},
+
+		// Wrong types and invalid values
+		{"numeric height", blockPayload(t, "protocolState.consensusState.blockchainLength", 2), []string{"protocolState.consensusState.blockchainLength"}},
+		{"string nonce", blockPayload(t, "transactions.userCommands.0.nonce", "4"), []string{"transactions.userCommands.0.nonce"}},
+		{"object coinbase", blockPayload(t, "transactions.coinbase", map[string]interface{}{"amount": "1"}), []string{"transactions.coinbase"}},
+		{"non numeric slot", blockPayload(t, "protocolState.consensusState.slot", "three"), []string{"protocolState.consensusState.slot"}},
+		{"negative epoch", blockPayload(t, "protocolState.consensusState.epoch", "-1"), []string{"protocolState.consensusState.epoch"}},
+		{"negative nonce", blockPayload(t, "transactions.userCommands.0.nonce", -1), []string{"transactions.userCommands[0].nonce"}},
+		{"negative fee", blockPayload(t, "transactions.userCommands.0.fee", "-10"), []string{"transactions.userCommands[0].fee"}},
+		{"decimal amount", blockPayload(t, "transactions.userCommands.0.amount", "1.5"), []string{"transactions.userCommands[0].amount"}},
+		{"zero date", blockPayload(t, "protocolState.blockchainState.date", "0"), []string{"protocolState.blockchainState.date"}},
+		{"iso date", blockPayload(t, "protocolState.blockchainState.date", "2020-01-01T00:00:00Z"), []string{"protocolState.blockchainState.date"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, invalid := decodeBlock(tt.payload)
+			if invalid == nil {
+				t.Fatalf("invalid block passed validation: %+v", block)
+			}
+			if block != nil {
+				t.Errorf("a block has been returned together with the validation errors")
+			}
+			if string(invalid.Payload) != string(tt.payload) {
+				t.Errorf("payload has not been retained")
+			}
+
+			for _, field := range tt.fields {
+				reported := false
+				for _, e := range invalid.Errors {
+					// Older go versions only report the name of the innermost field of type errors
+					if e.Field == field || (e.Field != "" && strings.HasSuffix(field, "."+e.Field)) {
+						reported = true
+					}
+				}
+				if !reported {
+					t.Errorf("field %q has not been reported as invalid, errors: %v", field, invalid)
+				}
+			}
+		})
+	}
+}
+
+// The state hash, parent hash and height of an invalid block are taken from the payload as far as they are valid
+func TestDecodeBlockInvalidMetadata(t *testing.T) {
+	_, invalid := decodeBlock(blockPayload(t, "transactions.coinbase", "-1"))
+	if invalid == nil {
+		t.Fatal("invalid block passed validation")
+	}
+	if invalid.StateHash != "state-2" || invalid.PreviousStateHash != "state-1" || invalid.Height != 2 {
+		t.Errorf("unexpected invalid block %v %v %v", invalid.StateHash, invalid.PreviousStateHash, invalid.Height)
+	}
+	if len(invalid.Errors) != 1 || invalid.Errors[0].BlockStateHash != "state-2" || invalid.Errors[0].Value != "-1" {
+		t.Errorf("unexpected validation errors %v", invalid)
+	}
+}
@@ -0,0 +1,388 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package fake
+
+import (
+	"coda-explorer/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Arguments are either literals or references to variables
+const argumentPattern = `("[^"]*"|\d+|\$\w+)`
+
+var (
+	blocksQueryRegex  = regexp.MustCompile(`blocks\s*\(\s*last\s*:\s*` + argumentPattern + `\s*\)`)
+	blockQueryRegex   = regexp.MustCompile(`block\s*\(\s*stateHash\s*:\s*` + argumentPattern + `\s*\)`)
+	accountQueryRegex = regexp.MustCompile(`(?:(\w+)\s*:\s*)?account\s*\(\s*publicKey\s*:\s*` + argumentPattern + `\s*\)`)
+	bestChainRegex    = regexp.MustCompile(`bestChain\s*\(\s*maxLength\s*:\s*` + argumentPattern + `\s*\)`)
+	daemonStatusRegex = regexp.MustCompile(`daemonStatus\s*{`)
+	pooledQueryRegex  = regexp.MustCompile(`pooledUserCommands\s*{`)
+)
+
+// graphqlRequest is the body of a graphql query sent via POST
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// Answers the subset of graphql queries issued by rpc.CodaClient, queries are accepted as json POST body or as GET parameters
+func (n *Node) serveQuery(w http.ResponseWriter, r *http.Request) {
+	req := &graphqlRequest{}
+	switch r.Method {
+	case http.MethodPost:
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &req.Variables)
+			if err != nil {
+				writeErrors(w, http.StatusBadRequest, "invalid variables parameter: "+err.Error())
+				return
+			}
+		}
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed, "unsupported method "+r.Method)
+		return
+	}
+
+	query := req.Query
+	arg := func(token string) string {
+		if strings.HasPrefix(token, "$") {
+			value, exists := req.Variables[token[1:]]
+			if !exists {
+				return ""
+			}
+			return fmt.Sprint(value)
+		}
+		return strings.Trim(token, `"`)
+	}
+
+	var data map[string]interface{}
+
+	n.mux.Lock()
+	if m := blocksQueryRegex.FindStringSubmatch(query); m != nil {
+		lookback, _ := strconv.Atoi(arg(m[1]))
+		data = map[string]interface{}{"blocks": map[string]interface{}{"nodes": encodeBlocks(n.bestChain(lookback))}}
+	} else if m := blockQueryRegex.FindStringSubmatch(query); m != nil {
+		var block interface{}
+		if b, exists := n.blocks[arg(m[1])]; exists {
+			block = encodeBlock(b)
+		}
+		data = map[string]interface{}{"block": block}
+	} else if ms := accountQueryRegex.FindAllStringSubmatch(query, -1); ms != nil {
+		// Multiple accounts are queried using aliases
+		data = make(map[string]interface{})
+		for _, m := range ms {
+			alias := m[1]
+			if alias == "" {
+				alias = "account"
+			}
+			data[alias] = encodeAccount(n.accounts[arg(m[2])])
+		}
+		if m := bestChainRegex.FindStringSubmatch(query); m != nil {
+			maxLength, _ := strconv.Atoi(arg(m[1]))
+			data["bestChain"] = encodeBlocks(n.bestChain(maxLength))
+		}
+	} else if m := bestChainRegex.FindStringSubmatch(query); m != nil {
+		maxLength, _ := strconv.Atoi(arg(m[1]))
+		data = map[string]interface{}{"bestChain": encodeBlocks(n.bestChain(maxLength))}
+	} else if daemonStatusRegex.MatchString(query) {
+		data = map[string]interface{}{"daemonStatus": encodeDaemonStatus(n.status)}
+	} else if pooledQueryRegex.MatchString(query) {
+		data = map[string]interface{}{"pooledUserCommands": encodePooledUserCommands(n.pool)}
+	}
+	n.mux.Unlock()
+
+	if data == nil {
+		writeErrors(w, http.StatusBadRequest, "unsupported query")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeErrors(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{{"message": message}},
+	})
+}
+
+func encodeBlocks(blocks []*types.Block) []interface{} {
+	nodes := make([]interface{}, len(blocks))
+	for i, b := range blocks {
+		nodes[i] = encodeBlock(b)
+	}
+	return nodes
+}
+
+func encodeBlock(b *types.Block) map[string]interface{} {
+	userCommands := make([]interface{}, len(b.UserJobs))
+	for i, uj := range b.UserJobs {
+		userCommands[i] = map[string]interface{}{
+			"amount":       uj.Amount.String(),
+			"fee":          uj.Fee.String(),
+			"from":         uj.Sender,
+			"id":           uj.ID,
+			"isDelegation": uj.Delegation,
+			"memo":         uj.Memo,
+			"nonce":        uj.Nonce,
+			"to":           uj.Recipient,
+		}
+	}
+
+	feeTransfers := make([]interface{}, len(b.FeeTransfers))
+	for i, ft := range b.FeeTransfers {
+		feeTransfers[i] = map[string]interface{}{
+			"fee":       ft.Fee.String(),
+			"recipient": ft.Recipient,
+		}
+	}
+
+	snarkJobs := make([]interface{}, len(b.SnarkJobs))
+	for i, sj := range b.SnarkJobs {
+		snarkJobs[i] = map[string]interface{}{
+			"fee":     sj.Fee.String(),
+			"prover":  sj.Prover,
+			"workIds": []int64(sj.Jobids),
+		}
+	}
+
+	return map[string]interface{}{
+		"stateHash": b.StateHash,
+		"protocolState": map[string]interface{}{
+			"previousStateHash": b.PreviousStateHash,
+			"consensusState": map[string]interface{}{
+				"blockchainLength": strconv.Itoa(b.Height),
+				"epoch":            strconv.Itoa(b.Epoch),
+				"slot":             strconv.Itoa(b.Slot),
+				"totalCurrency":    b.TotalCurrency.String(),
+			},
+			"blockchainState": map[string]interface{}{
+				"snarkedLedgerHash": b.SnarkedLedgerHash,
+				"stagedLedgerHash":  b.StagedLedgerHash,
+				"date":              strconv.FormatInt(b.Ts.Unix()*1000, 10),
+			},
+		},
+		"transactions": map[string]interface{}{
+			"coinbase":     b.Coinbase.String(),
+			"feeTransfer":  feeTransfers,
+			"userCommands": userCommands,
+		},
+		"snarkJobs": snarkJobs,
+		"creatorAccount": map[string]interface{}{
+			"publicKey": b.Creator,
+		},
+	}
+}
+
+// Unknown accounts are reported with an empty balance, mirroring the behaviour of the daemon for new accounts
+func encodeAccount(a *types.Account) map[string]interface{} {
+	if a == nil {
+		a = &types.Account{}
+	}
+	return map[string]interface{}{
+		"balance": map[string]interface{}{
+			"total": a.Balance.String(),
+		},
+		"nonce":            strconv.Itoa(a.Nonce),
+		"receiptChainHash": a.ReceiptChainHash,
+		"delegateAccount": map[string]interface{}{
+			"publicKey": a.Delegate,
+		},
+		"votingFor": a.VotingFor,
+	}
+}
+
+func encodePooledUserCommands(txs []*types.MempoolTransaction) []interface{} {
+	userCommands := make([]interface{}, len(txs))
+	for i, tx := range txs {
+		userCommands[i] = map[string]interface{}{
+			"amount":       tx.Amount.String(),
+			"fee":          tx.Fee.String(),
+			"from":         tx.Sender,
+			"id":           tx.ID,
+			"isDelegation": tx.Delegation,
+			"memo":         tx.Memo,
+			"nonce":        tx.Nonce,
+			"to":           tx.Recipient,
+		}
+	}
+	return userCommands
+}
+
+func encodeDaemonStatus(s *types.DaemonStatus) map[string]interface{} {
+	return map[string]interface{}{
+		"blockchainLength": s.BlockchainLength,
+		"commitId":         s.CommitID,
+		"consensusConfiguration": map[string]interface{}{
+			"epochDuration": s.EpochDuration,
+			"slotDuration":  s.SlotDuration,
+			"slotsPerEpoch": s.SlotsPerEpoch,
+		},
+		"consensusMechanism":         s.ConsensusMechanism,
+		"highestBlockLengthReceived": s.HighestBlockLengthReceived,
+		"ledgerMerkleRoot":           s.LedgerMerkleRoot,
+		"numAccounts":                s.NumAccounts,
+		"peers":                      s.Peers,
+		"stateHash":                  s.StateHash,
+		"syncStatus":                 s.SyncStatus,
+		"uptimeSecs":                 s.Uptime,
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{"graphql-ws"},
+	CheckOrigin:  func(r *http.Request) bool { return true },
+}
+
+// Kinds of subscriptions supported by the fake
+const (
+	subscriptionNewBlock            = "newBlock"
+	subscriptionChainReorganization = "chainReorganization"
+	subscriptionSyncUpdate          = "newSyncUpdate"
+)
+
+var subscriptionRegex = regexp.MustCompile(`subscription\s*{\s*(newBlock|chainReorganization|newSyncUpdate)\b`)
+
+// subscriber is a single graphql-ws connection with any number of active subscriptions by id
+type subscriber struct {
+	conn     *websocket.Conn
+	writeMux sync.Mutex
+	subs     map[string]string
+}
+
+// graphqlWsMessage is a frame of the graphql-ws protocol
+type graphqlWsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func (s *subscriber) write(msg interface{}) {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	s.conn.WriteJSON(msg)
+}
+
+// Sends keep alive frames in the given interval until done is closed
+func (s *subscriber) keepAlive(intv time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(intv)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.write(&graphqlWsMessage{Type: "ka"})
+		}
+	}
+}
+
+// Sends the data to all subscriptions of the given kind, must be called with the node mutex held
+func (s *subscriber) notify(kind string, data interface{}) {
+	for id, k := range s.subs {
+		if k != kind {
+			continue
+		}
+		s.write(map[string]interface{}{
+			"id":   id,
+			"type": "data",
+			"payload": map[string]interface{}{
+				"data": map[string]interface{}{kind: data},
+			},
+		})
+	}
+}
+
+// Implements the server side of the graphql-ws protocol for newBlock, chainReorganization and newSyncUpdate subscriptions
+func (n *Node) serveSubscriptions(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s := &subscriber{conn: conn, subs: make(map[string]string)}
+	n.mux.Lock()
+	n.subscribers[s] = true
+	n.mux.Unlock()
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		n.mux.Lock()
+		delete(n.subscribers, s)
+		n.mux.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		msg := &graphqlWsMessage{}
+		err := conn.ReadJSON(msg)
+		if err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			s.write(&graphqlWsMessage{Type: "connection_ack"})
+			s.write(&graphqlWsMessage{Type: "ka"})
+			n.mux.Lock()
+			intv := n.keepAlive
+			n.mux.Unlock()
+			if intv > 0 {
+				go s.keepAlive(intv, done)
+			}
+		case "start":
+			req := &graphqlRequest{}
+			json.Unmarshal(msg.Payload, req)
+			m := subscriptionRegex.FindStringSubmatch(req.Query)
+			if m == nil {
+				payload, _ := json.Marshal(map[string]interface{}{"message": "unsupported subscription"})
+				s.write(&graphqlWsMessage{ID: msg.ID, Type: "error", Payload: payload})
+				continue
+			}
+			n.mux.Lock()
+			s.subs[msg.ID] = m[1]
+			n.mux.Unlock()
+		case "stop":
+			n.mux.Lock()
+			delete(s.subs, msg.ID)
+			n.mux.Unlock()
+			s.write(&graphqlWsMessage{ID: msg.ID, Type: "complete"})
+		case "connection_terminate":
+			return
+		}
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package fake provides an in-process fake of the Coda daemon graphql api.
+// It serves scripted chains (including forks and reorgs) via plain http and the graphql-ws
+// websocket protocol so the indexer can be run end-to-end without a live node.
+package fake
+
+import (
+	"coda-explorer/types"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Node is a fake Coda daemon holding a tree of blocks of which one branch is the best chain
+type Node struct {
+	mux sync.Mutex
+
+	blocks   map[string]*types.Block
+	bestTip  string
+	accounts map[string]*types.Account
+	status   *types.DaemonStatus
+	pool     []*types.MempoolTransaction
+
+	subscribers map[*subscriber]bool
+	keepAlive   time.Duration
+
+	server *httptest.Server
+}
+
+// NewNode creates and starts a new fake node listening on a random local port
+func NewNode() *Node {
+	n := &Node{
+		blocks:      make(map[string]*types.Block),
+		accounts:    make(map[string]*types.Account),
+		subscribers: make(map[*subscriber]bool),
+		status: &types.DaemonStatus{
+			CommitID:           "fake",
+			ConsensusMechanism: "proof_of_stake",
+			SyncStatus:         "SYNCED",
+			Peers:              []string{},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", n.handle)
+	n.server = httptest.NewServer(mux)
+
+	return n
+}
+
+// Host returns the graphql endpoint of the node in the format expected by rpc.NewCodaClient
+func (n *Node) Host() string {
+	return strings.TrimPrefix(n.server.URL, "http://") + "/graphql"
+}
+
+// Close shuts down the node and disconnects all subscribers
+func (n *Node) Close() {
+	n.DropSubscribers()
+	n.server.Close()
+}
+
+// AddBlock adds a block to the block tree. The parent of the block must already be known unless the tree is empty.
+// If the block is higher than the current best tip it becomes the new best tip and subscribers are notified.
+func (n *Node) AddBlock(block *types.Block) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if _, exists := n.blocks[block.StateHash]; exists {
+		return fmt.Errorf("block %v already exists", block.StateHash)
+	}
+	if len(n.blocks) > 0 {
+		if _, exists := n.blocks[block.PreviousStateHash]; !exists {
+			return fmt.Errorf("parent %v of block %v is unknown", block.PreviousStateHash, block.StateHash)
+		}
+	}
+
+	n.blocks[block.StateHash] = block
+
+	if n.bestTip == "" || block.Height > n.blocks[n.bestTip].Height {
+		n.setBestTip(block.StateHash)
+	}
+	return nil
+}
+
+// SetBestTip switches the best chain to the branch ending in the given block, notifying all subscribers
+func (n *Node) SetBestTip(stateHash string) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if _, exists := n.blocks[stateHash]; !exists {
+		return fmt.Errorf("block %v is unknown", stateHash)
+	}
+	n.setBestTip(stateHash)
+	return nil
+}
+
+func (n *Node) setBestTip(stateHash string) {
+	previous := n.bestTip
+	n.bestTip = stateHash
+	tip := n.blocks[stateHash]
+	n.status.StateHash = tip.StateHash
+	n.status.BlockchainLength = tip.Height
+	if tip.Height > n.status.HighestBlockLengthReceived {
+		n.status.HighestBlockLengthReceived = tip.Height
+	}
+
+	for s := range n.subscribers {
+		s.notify(subscriptionNewBlock, encodeBlock(tip))
+		if previous != "" && previous != tip.PreviousStateHash {
+			s.notify(subscriptionChainReorganization, "CHANGED")
+		}
+	}
+}
+
+// BestTip returns the current best tip of the node
+func (n *Node) BestTip() *types.Block {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.blocks[n.bestTip]
+}
+
+// BestChain returns the last <lookback> blocks of the best chain, ordered by height ascending
+func (n *Node) BestChain(lookback int) []*types.Block {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.bestChain(lookback)
+}
+
+func (n *Node) bestChain(lookback int) []*types.Block {
+	var chain []*types.Block
+
+	current, exists := n.blocks[n.bestTip]
+	for exists && len(chain) < lookback {
+		chain = append([]*types.Block{current}, chain...)
+		current, exists = n.blocks[current.PreviousStateHash]
+	}
+	return chain
+}
+
+// SetAccount sets the account state returned for the accounts public key
+func (n *Node) SetAccount(account *types.Account) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.accounts[account.PublicKey] = account
+}
+
+// SetPooledUserCommands replaces the user commands reported as pending in the transaction pool
+func (n *Node) SetPooledUserCommands(txs []*types.MempoolTransaction) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.pool = txs
+}
+
+// SetSyncStatus sets the sync status reported by the daemon status query, notifying all subscribers of changes
+func (n *Node) SetSyncStatus(syncStatus string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.status.SyncStatus == syncStatus {
+		return
+	}
+	n.status.SyncStatus = syncStatus
+	for s := range n.subscribers {
+		s.notify(subscriptionSyncUpdate, syncStatus)
+	}
+}
+
+// SetPeers sets the peers reported by the daemon status query
+func (n *Node) SetPeers(peers []string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.status.Peers = peers
+}
+
+// SetKeepAliveInterval sets the interval in which keep alive frames are sent to new websocket connections. By
+// default a single keep alive frame is sent after the connection has been acknowledged.
+func (n *Node) SetKeepAliveInterval(intv time.Duration) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.keepAlive = intv
+}
+
+// DropSubscribers closes all open websocket connections to simulate a node restart
+func (n *Node) DropSubscribers() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	for s := range n.subscribers {
+		s.conn.Close()
+		delete(n.subscribers, s)
+	}
+}
+
+// Subscribers returns the number of active websocket subscriptions
+func (n *Node) Subscribers() int {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	count := 0
+	for s := range n.subscribers {
+		count += len(s.subs)
+	}
+	return count
+}
+
+// Handles both plain graphql queries and graphql-ws websocket upgrade requests
+func (n *Node) handle(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		n.serveSubscriptions(w, r)
+		return
+	}
+	n.serveQuery(w, r)
+}
+
+// Extend appends <count> generated blocks on top of the block with the given parent hash and returns them.
+// An empty parent hash starts a new chain at height 1. Creating a branch from an older block simulates a fork.
+func (n *Node) Extend(parentHash string, count int, creator string) ([]*types.Block, error) {
+	n.mux.Lock()
+	height := 0
+	slot := 0
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if parentHash != "" {
+		parent, exists := n.blocks[parentHash]
+		if !exists {
+			n.mux.Unlock()
+			return nil, fmt.Errorf("parent %v is unknown", parentHash)
+		}
+		height = parent.Height
+		slot = parent.Slot
+		ts = parent.Ts
+	}
+	branch := len(n.blocks)
+	n.mux.Unlock()
+
+	blocks := make([]*types.Block, count)
+	for i := range blocks {
+		height++
+		slot++
+		ts = ts.Add(time.Minute * 3)
+		block := NewBlock(parentHash, fmt.Sprintf("%v-%v", branch, height), height, slot, ts, creator)
+		err := n.AddBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+		parentHash = block.StateHash
+	}
+	return blocks, nil
+}
+
+// NewBlock creates a block without any transactions. The seed is used to derive a unique state hash.
+func NewBlock(parentHash, seed string, height, slot int, ts time.Time, creator string) *types.Block {
+	return &types.Block{
+		StateHash:         "fake-" + seed,
+		PreviousStateHash: parentHash,
+		SnarkedLedgerHash: "snarked-" + seed,
+		StagedLedgerHash:  "staged-" + seed,
+		Coinbase:          types.NewAmount(20000000000),
+		Creator:           creator,
+		Slot:              slot,
+		Height:            height,
+		Epoch:             slot / 1000,
+		Ts:                ts.Truncate(time.Second),
+		TotalCurrency:     types.NewAmount(int64(height) * 20000000000),
+		UserJobs:          []*types.UserJob{},
+		SnarkJobs:         []*types.SnarkJob{},
+		FeeTransfers:      []*types.FeeTransfer{},
+	}
+}
@@ -0,0 +1,375 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"coda-explorer/metrics"
+	"coda-explorer/types"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sync status reported by a node that is in sync with the network
+const syncStatusSynced = "SYNCED"
+
+// Number of best chain blocks compared between the nodes when checking for divergences
+const divergenceLookback = 10
+
+// Blocks less than this number of blocks below the lowest best tip are not compared, the nodes might still be
+// switching between short lived forks at the tip
+const divergenceMinDepth = 2
+
+// Timeout of the daemon status and best chain queries of a health check
+const healthCheckTimeout = time.Second * 10
+
+// ErrNoHealthyNode is returned when none of the nodes of a pool is reachable and synced
+var ErrNoHealthyNode = errors.New("no healthy node available")
+
+var _ NodeClient = (*NodePool)(nil)
+
+var nodeHeightGauge = metrics.NewGaugeVec("coda_rpc_node_height", "Blockchain length reported by a node during the last health check", "node")
+
+// NodePool distributes the requests of the indexer over multiple nodes. The nodes are health checked periodically,
+// requests are routed to the synced node with the highest best tip and fail over to the next best node on errors.
+type NodePool struct {
+	nodes        []*poolNode
+	onDivergence func([]*types.NodeDivergence) error
+
+	mux sync.RWMutex
+	// Healthy nodes ordered by preference
+	ranked []*poolNode
+}
+
+// poolNode is a single node of a pool together with the result of its last health check
+type poolNode struct {
+	host   string
+	client *CodaClient
+	status *types.DaemonStatus
+	err    error
+}
+
+// NewNodePool creates a pool for the given graphql endpoints, onDivergence is called with the blocks of all nodes at
+// heights where their best chains disagree. The pool contains no healthy node until CheckHealth has been called.
+func NewNodePool(hosts []string, onDivergence func([]*types.NodeDivergence) error) *NodePool {
+	p := &NodePool{onDivergence: onDivergence}
+	for _, host := range hosts {
+		p.nodes = append(p.nodes, &poolNode{host: host, client: NewCodaClient(host)})
+	}
+	return p
+}
+
+// MonitorHealth checks the health of all nodes in the specified interval and whenever the sync status of a node
+// changes until the context is done
+func (p *NodePool) MonitorHealth(ctx context.Context, intv time.Duration) {
+	ticker := time.NewTicker(intv)
+	defer ticker.Stop()
+
+	syncUpdates := make(chan *SyncUpdateEvent)
+	for _, n := range p.nodes {
+		go func(events <-chan *SyncUpdateEvent) {
+			for e := range events {
+				select {
+				case syncUpdates <- e:
+				case <-ctx.Done():
+				}
+			}
+		}(n.client.SubscribeSyncUpdates(ctx))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-syncUpdates:
+			logger.Infof("sync status of node %v changed to %v", e.Node, e.Status)
+		case <-ticker.C:
+		}
+		p.CheckHealth(ctx)
+	}
+}
+
+// CheckHealth retrieves the daemon status of all nodes and ranks the synced nodes by the height of their best tip.
+// The best chains of the synced nodes are compared afterwards, disagreements are logged and passed to onDivergence.
+func (p *NodePool) CheckHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	statuses := make([]*types.DaemonStatus, len(p.nodes))
+	errs := make([]error, len(p.nodes))
+	for i, n := range p.nodes {
+		wg.Add(1)
+		go func(i int, n *poolNode) {
+			defer wg.Done()
+			statuses[i], errs[i] = n.client.GetDaemonStatus(ctx)
+		}(i, n)
+	}
+	wg.Wait()
+
+	var ranked []*poolNode
+	p.mux.Lock()
+	for i, n := range p.nodes {
+		n.status, n.err = statuses[i], errs[i]
+		if n.status != nil {
+			nodeHeightGauge.Set(float64(n.status.BlockchainLength), n.host)
+		}
+		if n.err == nil && n.status.SyncStatus != syncStatusSynced {
+			n.err = fmt.Errorf("node is not synced, sync status is %v", n.status.SyncStatus)
+		}
+		if n.err != nil {
+			logger.Warnf("node %v is unhealthy: %v", n.host, n.err)
+			continue
+		}
+		ranked = append(ranked, n)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].status.BlockchainLength > ranked[j].status.BlockchainLength
+	})
+	if len(ranked) > 0 && (len(p.ranked) == 0 || p.ranked[0] != ranked[0]) {
+		logger.Infof("routing requests to node %v at height %v", ranked[0].host, ranked[0].status.BlockchainLength)
+	}
+	if len(ranked) == 0 {
+		logger.Errorf("none of the %v nodes is healthy", len(p.nodes))
+	}
+	p.ranked = ranked
+	p.mux.Unlock()
+
+	if len(ranked) > 1 {
+		p.checkDivergence(ctx, ranked)
+	}
+}
+
+// Compares the best chains of the given nodes and reports the blocks of all nodes at heights where they disagree
+func (p *NodePool) checkDivergence(ctx context.Context, nodes []*poolNode) {
+	chains := make(map[*poolNode][]*types.BlockHashNumber, len(nodes))
+	lowestTip := 0
+	for _, n := range nodes {
+		chain, err := n.client.GetBestChainHashes(ctx, divergenceLookback)
+		if err != nil {
+			logger.Errorf("error retrieving best chain of node %v: %v", n.host, err)
+			continue
+		}
+		if len(chain) == 0 {
+			continue
+		}
+		chains[n] = chain
+		if tip := chain[len(chain)-1].Height; lowestTip == 0 || tip < lowestTip {
+			lowestTip = tip
+		}
+	}
+	if len(chains) < 2 {
+		return
+	}
+
+	blocksAtHeight := make(map[int]map[string][]*poolNode)
+	for n, chain := range chains {
+		for _, b := range chain {
+			if b.Height > lowestTip-divergenceMinDepth {
+				continue
+			}
+			if blocksAtHeight[b.Height] == nil {
+				blocksAtHeight[b.Height] = make(map[string][]*poolNode)
+			}
+			blocksAtHeight[b.Height][b.StateHash] = append(blocksAtHeight[b.Height][b.StateHash], n)
+		}
+	}
+
+	now := time.Now()
+	var divergences []*types.NodeDivergence
+	for height, blocks := range blocksAtHeight {
+		if len(blocks) < 2 {
+			continue
+		}
+		for stateHash, nodes := range blocks {
+			for _, n := range nodes {
+				logger.Warnf("nodes disagree on the block at height %v, node %v has block %v", height, n.host, stateHash)
+				divergences = append(divergences, &types.NodeDivergence{
+					Height:    height,
+					Node:      n.host,
+					StateHash: stateHash,
+					FirstSeen: now,
+					LastSeen:  now,
+				})
+			}
+		}
+	}
+
+	if len(divergences) > 0 && p.onDivergence != nil {
+		sort.Slice(divergences, func(i, j int) bool {
+			if divergences[i].Height != divergences[j].Height {
+				return divergences[i].Height < divergences[j].Height
+			}
+			return divergences[i].Node < divergences[j].Node
+		})
+		err := p.onDivergence(divergences)
+		if err != nil {
+			logger.Errorf("error reporting node divergences: %v", err)
+		}
+	}
+}
+
+// Reports whether requests are currently routed to the node
+func (p *NodePool) isBest(node *poolNode) bool {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	return len(p.ranked) > 0 && p.ranked[0] == node
+}
+
+// Marks a node as unhealthy until the next health check
+func (p *NodePool) markUnhealthy(node *poolNode, err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	node.err = err
+	for i, n := range p.ranked {
+		if n == node {
+			p.ranked = append(p.ranked[:i:i], p.ranked[i+1:]...)
+			break
+		}
+	}
+}
+
+// Errors caused by the requested data rather than by the node do not mark a node as unhealthy
+func isNodeFailure(err error) bool {
+	var graphqlErr *GraphQLError
+	var invalid *InvalidBlock
+	return !errors.As(err, &graphqlErr) && !errors.As(err, &invalid) && !errors.Is(err, ErrNotFound)
+}
+
+// Executes a request on the best healthy node, failing over to the next best node if the request fails
+func (p *NodePool) do(ctx context.Context, request func(client *CodaClient) error) error {
+	p.mux.RLock()
+	candidates := append([]*poolNode(nil), p.ranked...)
+	p.mux.RUnlock()
+
+	if len(candidates) == 0 {
+		return ErrNoHealthyNode
+	}
+
+	var err error
+	for _, n := range candidates {
+		err = request(n.client)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if isNodeFailure(err) {
+			p.markUnhealthy(n, err)
+		}
+		logger.Warnf("request to node %v failed: %v", n.host, err)
+	}
+	return err
+}
+
+// GetLastBlocks retrieves the last <lookback> blocks from the best healthy node
+func (p *NodePool) GetLastBlocks(ctx context.Context, lookback int) (blocks []*types.Block, invalid []*InvalidBlock, err error) {
+	err = p.do(ctx, func(client *CodaClient) error {
+		blocks, invalid, err = client.GetLastBlocks(ctx, lookback)
+		return err
+	})
+	return blocks, invalid, err
+}
+
+// GetBlock retrieves a single block by its state hash from the best healthy node
+func (p *NodePool) GetBlock(ctx context.Context, stateHash string) (block *types.Block, err error) {
+	err = p.do(ctx, func(client *CodaClient) error {
+		block, err = client.GetBlock(ctx, stateHash)
+		return err
+	})
+	return block, err
+}
+
+// GetAccounts retrieves the current state of multiple accounts from the best healthy node
+func (p *NodePool) GetAccounts(ctx context.Context, publicKeys []string) (accounts map[string]*types.Account, tip string, err error) {
+	err = p.do(ctx, func(client *CodaClient) error {
+		accounts, tip, err = client.GetAccounts(ctx, publicKeys)
+		return err
+	})
+	return accounts, tip, err
+}
+
+// GetDaemonStatus retrieves the daemon status of the best healthy node
+func (p *NodePool) GetDaemonStatus(ctx context.Context) (status *types.DaemonStatus, err error) {
+	err = p.do(ctx, func(client *CodaClient) error {
+		status, err = client.GetDaemonStatus(ctx)
+		return err
+	})
+	return status, err
+}
+
+// GetPooledUserCommands retrieves the pending user commands from the transaction pool of the best healthy node
+func (p *NodePool) GetPooledUserCommands(ctx context.Context) (txs []*types.MempoolTransaction, err error) {
+	err = p.do(ctx, func(client *CodaClient) error {
+		txs, err = client.GetPooledUserCommands(ctx)
+		return err
+	})
+	return txs, err
+}
+
+// SubscribeNewBlocks delivers the new blocks of the best healthy node of the pool until the context is done, so that
+// every delivered block is the best tip of the node requests are routed to. New blocks of other nodes are dropped.
+func (p *NodePool) SubscribeNewBlocks(ctx context.Context) <-chan *NewBlockEvent {
+	ch := make(chan *NewBlockEvent)
+	wg := &sync.WaitGroup{}
+	for _, n := range p.nodes {
+		wg.Add(1)
+		go func(n *poolNode, events <-chan *NewBlockEvent) {
+			defer wg.Done()
+			for e := range events {
+				if !p.isBest(n) {
+					continue
+				}
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+				}
+			}
+		}(n, n.client.SubscribeNewBlocks(ctx))
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// SubscribeChainReorganizations delivers the chain reorganizations of all nodes of the pool until the context is done
+func (p *NodePool) SubscribeChainReorganizations(ctx context.Context) <-chan *ChainReorganizationEvent {
+	ch := make(chan *ChainReorganizationEvent)
+	wg := &sync.WaitGroup{}
+	for _, n := range p.nodes {
+		wg.Add(1)
+		go func(events <-chan *ChainReorganizationEvent) {
+			defer wg.Done()
+			for e := range events {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+				}
+			}
+		}(n.client.SubscribeChainReorganizations(ctx))
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,229 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"coda-explorer/rpc/fake"
+	"coda-explorer/types"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Creates a pool of the given fake nodes whose clients do not retry failed requests
+func newTestPool(t *testing.T, nodes []*fake.Node, onDivergence func([]*types.NodeDivergence) error) *NodePool {
+	t.Helper()
+
+	hosts := make([]string, len(nodes))
+	for i, n := range nodes {
+		hosts[i] = n.Host()
+	}
+	p := NewNodePool(hosts, onDivergence)
+	for _, n := range p.nodes {
+		n.client.retry = &RetryPolicy{MaxAttempts: 1}
+	}
+	return p
+}
+
+// Creates a fake node whose best chain consists of <length> blocks, the caller has to close the node
+func newTestNode(t *testing.T, length int) *fake.Node {
+	t.Helper()
+
+	node := fake.NewNode()
+	_, err := node.Extend("", length, "creator")
+	if err != nil {
+		node.Close()
+		t.Fatalf("error creating chain: %v", err)
+	}
+	return node
+}
+
+func rankedHosts(p *NodePool) []string {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	hosts := make([]string, len(p.ranked))
+	for i, n := range p.ranked {
+		hosts[i] = n.host
+	}
+	return hosts
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCheckHealthRanksNodes(t *testing.T) {
+	low := newTestNode(t, 3)
+	defer low.Close()
+	high := newTestNode(t, 5)
+	defer high.Close()
+	unsynced := newTestNode(t, 7)
+	defer unsynced.Close()
+	unsynced.SetSyncStatus("BOOTSTRAP")
+	down := newTestNode(t, 9)
+	down.Close()
+
+	p := newTestPool(t, []*fake.Node{low, high, unsynced, down}, nil)
+	if _, err := p.GetDaemonStatus(context.Background()); !errors.Is(err, ErrNoHealthyNode) {
+		t.Errorf("expected %v before the first health check, got %v", ErrNoHealthyNode, err)
+	}
+
+	p.CheckHealth(context.Background())
+
+	expected := []string{high.Host(), low.Host()}
+	if got := rankedHosts(p); !equalStrings(got, expected) {
+		t.Fatalf("expected nodes to be ranked %v, got %v", expected, got)
+	}
+	if !p.isBest(p.nodes[1]) {
+		t.Errorf("expected requests to be routed to %v", high.Host())
+	}
+
+	status, err := p.GetDaemonStatus(context.Background())
+	if err != nil {
+		t.Fatalf("error retrieving daemon status: %v", err)
+	}
+	if status.BlockchainLength != 5 {
+		t.Errorf("expected daemon status of the best node at height 5, got height %v", status.BlockchainLength)
+	}
+
+	// Once the node has caught up it is ranked again
+	unsynced.SetSyncStatus(syncStatusSynced)
+	p.CheckHealth(context.Background())
+
+	expected = []string{unsynced.Host(), high.Host(), low.Host()}
+	if got := rankedHosts(p); !equalStrings(got, expected) {
+		t.Errorf("expected nodes to be ranked %v, got %v", expected, got)
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	best := newTestNode(t, 5)
+	defer best.Close()
+	next := newTestNode(t, 4)
+	defer next.Close()
+	last := newTestNode(t, 3)
+	defer last.Close()
+
+	p := newTestPool(t, []*fake.Node{best, next, last}, nil)
+	p.CheckHealth(context.Background())
+
+	best.Close()
+
+	status, err := p.GetDaemonStatus(context.Background())
+	if err != nil {
+		t.Fatalf("error retrieving daemon status: %v", err)
+	}
+	if status.BlockchainLength != 4 {
+		t.Errorf("expected daemon status of the next best node at height 4, got height %v", status.BlockchainLength)
+	}
+	expected := []string{next.Host(), last.Host()}
+	if got := rankedHosts(p); !equalStrings(got, expected) {
+		t.Errorf("expected failed node to be marked unhealthy leaving %v, got %v", expected, got)
+	}
+	if p.nodes[0].err == nil {
+		t.Errorf("expected the error of the failed node to be recorded")
+	}
+
+	// Blocks unknown to a node do not mark it as unhealthy
+	_, err = p.GetBlock(context.Background(), "fake-unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v for an unknown block, got %v", ErrNotFound, err)
+	}
+	if got := rankedHosts(p); !equalStrings(got, expected) {
+		t.Errorf("expected nodes %v to remain healthy, got %v", expected, got)
+	}
+
+	next.Close()
+	last.Close()
+
+	_, err = p.GetDaemonStatus(context.Background())
+	if err == nil {
+		t.Fatalf("expected an error once all nodes are down")
+	}
+	if got := rankedHosts(p); len(got) != 0 {
+		t.Errorf("expected all nodes to be marked unhealthy, got %v", got)
+	}
+	if _, err = p.GetDaemonStatus(context.Background()); !errors.Is(err, ErrNoHealthyNode) {
+		t.Errorf("expected %v, got %v", ErrNoHealthyNode, err)
+	}
+}
+
+func TestCheckHealthReportsDivergence(t *testing.T) {
+	a := newTestNode(t, 8)
+	defer a.Close()
+	b := fake.NewNode()
+	defer b.Close()
+	common, err := b.Extend("", 3, "creator")
+	if err != nil {
+		t.Fatalf("error creating chain: %v", err)
+	}
+	if _, err := b.Extend(common[2].StateHash, 5, "creator"); err != nil {
+		t.Fatalf("error creating fork: %v", err)
+	}
+
+	var reported []*types.NodeDivergence
+	p := newTestPool(t, []*fake.Node{a, b}, func(divergences []*types.NodeDivergence) error {
+		reported = divergences
+		return nil
+	})
+	p.CheckHealth(context.Background())
+
+	// Both chains end at height 8, the blocks at heights 4 to 6 differ and the two blocks at the tip are too recent
+	if len(reported) != 6 {
+		t.Fatalf("expected 6 divergences, got %v", len(reported))
+	}
+	for i, d := range reported {
+		height := 4 + i/2
+		if d.Height != height {
+			t.Errorf("expected divergence %v at height %v, got height %v", i, height, d.Height)
+		}
+		var node *fake.Node
+		for _, n := range []*fake.Node{a, b} {
+			if n.Host() == d.Node {
+				node = n
+			}
+		}
+		if node == nil {
+			t.Fatalf("divergence reported for unknown node %v", d.Node)
+		}
+		if expected := node.BestChain(8)[height-1].StateHash; d.StateHash != expected {
+			t.Errorf("expected block %v of node %v at height %v, got %v", expected, d.Node, height, d.StateHash)
+		}
+	}
+
+	// Nodes agreeing on their best chains are not reported
+	reported = nil
+	if err := a.SetBestTip(a.BestChain(8)[2].StateHash); err != nil {
+		t.Fatalf("error switching best tip: %v", err)
+	}
+	if err := b.SetBestTip(common[2].StateHash); err != nil {
+		t.Fatalf("error switching best tip: %v", err)
+	}
+	p.CheckHealth(context.Background())
+	if len(reported) != 0 {
+		t.Errorf("expected no divergences, got %v", len(reported))
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"coda-explorer/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	retriesCounter          = metrics.NewCounterVec("coda_rpc_retries_total", "Number of retried node requests", "node")
+	retriesExhaustedCounter = metrics.NewCounterVec("coda_rpc_retries_exhausted_total", "Number of node requests that failed after all retry attempts", "node")
+	reconnectsCounter       = metrics.NewCounterVec("coda_rpc_websocket_reconnects_total", "Number of websocket subscription reconnect attempts", "node")
+	droppedEventsCounter    = metrics.NewCounterVec("coda_rpc_subscription_dropped_events_total", "Number of subscription events dropped because the consumer fell too far behind", "node")
+	breakerStateGauge       = metrics.NewGaugeVec("coda_rpc_circuit_breaker_state", "State of the circuit breaker of a node, 0 is closed, 1 is open and 2 is half open", "node")
+	breakerOpenedCounter    = metrics.NewCounterVec("coda_rpc_circuit_breaker_opened_total", "Number of times the circuit breaker of a node has been opened", "node")
+	breakerRejectedCounter  = metrics.NewCounterVec("coda_rpc_circuit_breaker_rejected_total", "Number of node requests rejected by an open circuit breaker", "node")
+	requestDuration         = metrics.NewHistogramVec("coda_rpc_request_duration_seconds", "Duration of node graphql query requests, every attempt is observed", metrics.DurationBuckets, "node", "query")
+	requestErrorsCounter    = metrics.NewCounterVec("coda_rpc_request_errors_total", "Number of failed node graphql query requests, every attempt is counted", "node", "query")
+)
+
+// RetryPolicy describes how often and after which delay failed node requests are retried
+type RetryPolicy struct {
+	// Maximum number of attempts including the first one, zero or less retries forever
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Fraction of the backoff by which the actual delay is randomly varied in both directions
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy used for node queries and websocket reconnects
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond * 500,
+	MaxBackoff:     time.Second * 30,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Backoff returns the delay before the given retry, the first retry has number zero
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry))
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(backoff)
+}
+
+// Do executes fn until it succeeds, returns an error that is not retryable or the maximum number of attempts has
+// been reached. Waiting for the next attempt is aborted if the context is done.
+func (p *RetryPolicy) Do(ctx context.Context, node string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			retriesExhaustedCounter.Inc(node)
+			return fmt.Errorf("giving up after %v attempts: %w", attempt, err)
+		}
+
+		backoff := p.Backoff(attempt - 1)
+		logger.Warnf("request to node %v failed, retrying in %v: %v", node, backoff, err)
+		retriesCounter.Inc(node)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// IsRetryable reports whether an error is caused by a node that is unreachable or temporarily unable to answer
+// requests. Errors concerning the requested data, errors of the caller's context and rejections by an open circuit
+// breaker are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusRequestTimeout
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ErrCircuitOpen is returned for requests to a node whose circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// States of a circuit breaker
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker stops sending requests to a node after a number of consecutive failures. After the open timeout
+// has elapsed a single probe request is let through, the breaker closes again once the probe succeeds.
+type CircuitBreaker struct {
+	node             string
+	failureThreshold int
+	openTimeout      time.Duration
+
+	mux      sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for a node
+func NewCircuitBreaker(node string, failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	breakerStateGauge.Set(breakerClosed, node)
+	return &CircuitBreaker{node: node, failureThreshold: failureThreshold, openTimeout: openTimeout}
+}
+
+// Allow returns ErrCircuitOpen if a request to the node must not be sent
+func (b *CircuitBreaker) Allow() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			breakerRejectedCounter.Inc(b.node)
+			return ErrCircuitOpen
+		}
+		logger.Infof("circuit breaker of node %v is half open, probing node", b.node)
+		b.setState(breakerHalfOpen)
+		return nil
+	case breakerHalfOpen:
+		// Only a single probe request is in flight while half open
+		breakerRejectedCounter.Inc(b.node)
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Record records the result of a request allowed by the breaker, only retryable errors count as failures. Requests
+// aborted by the caller's context say nothing about the node and are ignored, an aborted probe is repeated by the
+// next request.
+func (b *CircuitBreaker) Record(err error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if b.state == breakerHalfOpen {
+			b.setState(breakerOpen)
+		}
+		return
+	}
+
+	if b.state == breakerHalfOpen {
+		if err != nil {
+			logger.Errorf("probe of node %v failed, pausing requests for %v: %v", b.node, b.openTimeout, err)
+			b.open()
+			return
+		}
+		logger.Infof("node %v recovered, closing circuit breaker", b.node)
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	if !IsRetryable(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerClosed && b.failures >= b.failureThreshold {
+		logger.Errorf("node %v is down after %v consecutive failures, pausing requests for %v", b.node, b.failures, b.openTimeout)
+		b.open()
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(breakerOpen)
+	breakerOpenedCounter.Inc(b.node)
+}
+
+func (b *CircuitBreaker) setState(state int) {
+	b.state = state
+	breakerStateGauge.Set(float64(state), b.node)
+}
@@ -0,0 +1,233 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Second * 10, Multiplier: 2}
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 8, time.Second * 10, time.Second * 10}
+	for retry, backoff := range expected {
+		if got := p.Backoff(retry); got != backoff {
+			t.Errorf("expected backoff %v before retry %v, got %v", backoff, retry, got)
+		}
+	}
+
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		got := p.Backoff(1)
+		if got < time.Millisecond*1600 || got > time.Millisecond*2400 {
+			t.Fatalf("expected backoff with jitter between 1.6s and 2.4s, got %v", got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		got := p.Backoff(10)
+		if got < time.Second*8 || got > time.Second*12 {
+			t.Fatalf("expected capped backoff with jitter between 8s and 12s, got %v", got)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"no error", nil, false},
+		{"network error", netErr, true},
+		{"wrapped network error", fmt.Errorf("error retrieving graphql query response: %w", netErr), true},
+		{"internal server error", &StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{"bad gateway", &StatusError{StatusCode: http.StatusBadGateway}, true},
+		{"too many requests", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"request timeout", &StatusError{StatusCode: http.StatusRequestTimeout}, true},
+		{"bad request", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"not found", &StatusError{StatusCode: http.StatusNotFound}, false},
+		{"graphql error", &GraphQLError{StatusCode: http.StatusOK, Errors: []GraphQLErrorMessage{{Message: "invalid"}}}, false},
+		{"unknown block", ErrNotFound, false},
+		{"decoding error", fmt.Errorf("error decoding graphql query response: %w", errors.New("unexpected end of JSON input")), false},
+		{"context canceled", context.Canceled, false},
+		{"deadline exceeded", fmt.Errorf("error retrieving graphql query response: %w", context.DeadlineExceeded), false},
+		{"circuit open", ErrCircuitOpen, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("expected retryable %v, got %v", tt.retryable, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1}
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"success after retries", []error{netErr, netErr, nil}, 3, nil},
+		{"attempts exhausted", []error{netErr, netErr, netErr, nil}, 3, netErr},
+		{"not retryable", []error{netErr, ErrNotFound, nil}, 2, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := p.Do(context.Background(), "node", func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if attempts != tt.attempts {
+				t.Errorf("expected %v attempts, got %v", tt.attempts, attempts)
+			}
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	t.Run("context done", func(t *testing.T) {
+		p := &RetryPolicy{InitialBackoff: time.Hour, MaxBackoff: time.Hour, Multiplier: 1}
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		err := p.Do(ctx, "node", func() error {
+			attempts++
+			cancel()
+			return netErr
+		})
+		if attempts != 1 || !errors.Is(err, netErr) {
+			t.Errorf("expected a single attempt returning %v, got %v attempts returning %v", netErr, attempts, err)
+		}
+	})
+}
+
+// Lets the open timeout of a breaker elapse
+func expireBreaker(b *CircuitBreaker) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.openedAt = time.Now().Add(-b.openTimeout)
+}
+
+func breakerState(b *CircuitBreaker) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.state
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	graphqlErr := &GraphQLError{StatusCode: http.StatusOK, Errors: []GraphQLErrorMessage{{Message: "invalid"}}}
+
+	// request sends a request through the breaker and records its result
+	request := func(t *testing.T, b *CircuitBreaker, result error, allowed bool, state int) {
+		t.Helper()
+		err := b.Allow()
+		if allowed != (err == nil) {
+			t.Fatalf("expected request allowed %v, got error %v", allowed, err)
+		}
+		if err != nil && !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected %v, got %v", ErrCircuitOpen, err)
+		}
+		if allowed {
+			b.Record(result)
+		}
+		if got := breakerState(b); got != state {
+			t.Fatalf("expected breaker state %v, got %v", state, got)
+		}
+	}
+
+	t.Run("closed to open to half open to closed", func(t *testing.T) {
+		b := NewCircuitBreaker("node", 3, time.Minute)
+		request(t, b, netErr, true, breakerClosed)
+		request(t, b, netErr, true, breakerClosed)
+		request(t, b, netErr, true, breakerOpen)
+		request(t, b, nil, false, breakerOpen)
+
+		expireBreaker(b)
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected probe to be allowed, got %v", err)
+		}
+		if got := breakerState(b); got != breakerHalfOpen {
+			t.Fatalf("expected breaker state %v, got %v", breakerHalfOpen, got)
+		}
+		// Only a single probe is in flight
+		request(t, b, nil, false, breakerHalfOpen)
+		b.Record(nil)
+		if got := breakerState(b); got != breakerClosed {
+			t.Fatalf("expected breaker state %v, got %v", breakerClosed, got)
+		}
+
+		// Consecutive failures start over after the breaker closed
+		request(t, b, netErr, true, breakerClosed)
+		request(t, b, netErr, true, breakerClosed)
+	})
+
+	t.Run("failures must be consecutive", func(t *testing.T) {
+		b := NewCircuitBreaker("node", 2, time.Minute)
+		request(t, b, netErr, true, breakerClosed)
+		request(t, b, nil, true, breakerClosed)
+		request(t, b, netErr, true, breakerClosed)
+		request(t, b, graphqlErr, true, breakerClosed)
+		request(t, b, netErr, true, breakerClosed)
+		request(t, b, context.Canceled, true, breakerClosed)
+		request(t, b, netErr, true, breakerOpen)
+	})
+
+	t.Run("failed probe", func(t *testing.T) {
+		b := NewCircuitBreaker("node", 1, time.Minute)
+		request(t, b, netErr, true, breakerOpen)
+		expireBreaker(b)
+		request(t, b, netErr, true, breakerOpen)
+		request(t, b, nil, false, breakerOpen)
+	})
+
+	t.Run("probe answered with an error", func(t *testing.T) {
+		b := NewCircuitBreaker("node", 1, time.Minute)
+		request(t, b, netErr, true, breakerOpen)
+		expireBreaker(b)
+		request(t, b, graphqlErr, true, breakerOpen)
+		request(t, b, nil, false, breakerOpen)
+	})
+
+	t.Run("aborted probe", func(t *testing.T) {
+		b := NewCircuitBreaker("node", 1, time.Minute)
+		request(t, b, netErr, true, breakerOpen)
+		expireBreaker(b)
+		request(t, b, context.Canceled, true, breakerOpen)
+		request(t, b, context.DeadlineExceeded, true, breakerOpen)
+		// The node is probed again right away
+		request(t, b, nil, true, breakerClosed)
+	})
+}
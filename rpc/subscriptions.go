@@ -0,0 +1,435 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"coda-explorer/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Time to wait for the node to acknowledge a new websocket connection
+const connectionAckTimeout = time.Second * 10
+
+// Time without a keep alive or data frame from the node after which the connection is considered broken and
+// reestablished
+const keepAliveTimeout = time.Minute * 5
+
+// Number of events buffered by the channel of a subscription. Further events are queued by the subscription until
+// its consumer catches up, so that a slow consumer does not delay the events of the other subscriptions.
+const subscriptionBuffer = 32
+
+// Maximum number of events queued by a subscription in addition to its channel buffer. Once the queue is full the
+// oldest queued event is dropped, consumers of new blocks recover from dropped blocks through the missing parent check.
+const maxPendingEvents = 1000
+
+// Frame types of the graphql-ws protocol
+const (
+	gqlConnectionInit      = "connection_init"
+	gqlConnectionAck       = "connection_ack"
+	gqlConnectionError     = "connection_error"
+	gqlConnectionKeepAlive = "ka"
+	gqlConnectionTerminate = "connection_terminate"
+	gqlStart               = "start"
+	gqlStop                = "stop"
+	gqlData                = "data"
+	gqlError               = "error"
+	gqlComplete            = "complete"
+)
+
+// NewBlockEvent is delivered for every new block of a node, exactly one of Block and Invalid is set
+type NewBlockEvent struct {
+	Node    string
+	Block   *types.Block
+	Invalid *InvalidBlock
+}
+
+// ChainReorganizationEvent is delivered whenever the best tip of a node switches to a different branch
+type ChainReorganizationEvent struct {
+	Node   string
+	Status string
+}
+
+// SyncUpdateEvent is delivered whenever the sync status of a node changes
+type SyncUpdateEvent struct {
+	Node   string
+	Status string
+}
+
+// gqlMessage is a frame of the graphql-ws protocol
+type gqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscriptionManager multiplexes any number of graphql subscriptions over a single websocket connection to a node.
+// The connection is established with the first subscription and closed once the last subscription has ended, on
+// disconnects it is reestablished according to the retry policy and all subscriptions are restarted.
+type SubscriptionManager struct {
+	host             string
+	retry            *RetryPolicy
+	keepAliveTimeout time.Duration
+	maxPending       int
+
+	mux     sync.Mutex
+	subs    map[string]*subscription
+	nextID  int
+	conn    *websocket.Conn
+	running bool
+	// Done once the last subscription has ended, aborts waiting for the next reconnect attempt
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMux sync.Mutex
+}
+
+// subscription is a single graphql subscription, its channel is closed once the subscription context is done
+type subscription struct {
+	id    string
+	query string
+
+	mux sync.Mutex
+	// Events received from the node that have not been passed to the handler yet, at most maxPending
+	pending    []json.RawMessage
+	maxPending int
+	notify     chan struct{}
+}
+
+// NewSubscriptionManager creates a subscription manager for the graphql endpoint of a node
+func NewSubscriptionManager(host string, retry *RetryPolicy) *SubscriptionManager {
+	return &SubscriptionManager{
+		host:             host,
+		retry:            retry,
+		keepAliveTimeout: keepAliveTimeout,
+		maxPending:       maxPendingEvents,
+		subs:             make(map[string]*subscription),
+	}
+}
+
+// Subscription selecting all block fields required for indexing a block
+const newBlockSubscription = `subscription {
+				newBlock {` + blockFields + `
+				}
+			}`
+
+// SubscribeNewBlocks delivers the new blocks of the node including their full payload until the context is done
+func (m *SubscriptionManager) SubscribeNewBlocks(ctx context.Context) <-chan *NewBlockEvent {
+	ch := make(chan *NewBlockEvent, subscriptionBuffer)
+	m.subscribe(ctx, newBlockSubscription, func(data json.RawMessage) {
+		var resp struct {
+			NewBlock json.RawMessage `json:"newBlock"`
+		}
+		err := json.Unmarshal(data, &resp)
+		if err != nil {
+			logger.Errorf("error decoding new block event of node %v: %v", m.host, err)
+			return
+		}
+		event := &NewBlockEvent{Node: m.host}
+		event.Block, event.Invalid = decodeBlock(resp.NewBlock)
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+		}
+	}, func() { close(ch) })
+	return ch
+}
+
+// SubscribeChainReorganizations delivers the chain reorganizations of the node until the context is done
+func (m *SubscriptionManager) SubscribeChainReorganizations(ctx context.Context) <-chan *ChainReorganizationEvent {
+	ch := make(chan *ChainReorganizationEvent, subscriptionBuffer)
+	m.subscribe(ctx, "subscription { chainReorganization }", func(data json.RawMessage) {
+		var resp struct {
+			ChainReorganization string `json:"chainReorganization"`
+		}
+		err := json.Unmarshal(data, &resp)
+		if err != nil {
+			logger.Errorf("error decoding chain reorganization event of node %v: %v", m.host, err)
+			return
+		}
+		select {
+		case ch <- &ChainReorganizationEvent{Node: m.host, Status: resp.ChainReorganization}:
+		case <-ctx.Done():
+		}
+	}, func() { close(ch) })
+	return ch
+}
+
+// SubscribeSyncUpdates delivers the sync status changes of the node until the context is done
+func (m *SubscriptionManager) SubscribeSyncUpdates(ctx context.Context) <-chan *SyncUpdateEvent {
+	ch := make(chan *SyncUpdateEvent, subscriptionBuffer)
+	m.subscribe(ctx, "subscription { newSyncUpdate }", func(data json.RawMessage) {
+		var resp struct {
+			NewSyncUpdate string `json:"newSyncUpdate"`
+		}
+		err := json.Unmarshal(data, &resp)
+		if err != nil {
+			logger.Errorf("error decoding sync update event of node %v: %v", m.host, err)
+			return
+		}
+		select {
+		case ch <- &SyncUpdateEvent{Node: m.host, Status: resp.NewSyncUpdate}:
+		case <-ctx.Done():
+		}
+	}, func() { close(ch) })
+	return ch
+}
+
+// Registers a subscription and starts it if the node is connected. Until the context is done handle is called with
+// the data of every event, afterwards closeCh is called.
+func (m *SubscriptionManager) subscribe(ctx context.Context, query string, handle func(data json.RawMessage), closeCh func()) {
+	m.mux.Lock()
+	m.nextID++
+	sub := &subscription{id: strconv.Itoa(m.nextID), query: query, maxPending: m.maxPending, notify: make(chan struct{}, 1)}
+	m.subs[sub.id] = sub
+	if m.conn != nil {
+		m.start(m.conn, sub)
+	}
+	if m.ctx == nil || m.ctx.Err() != nil {
+		m.ctx, m.cancel = context.WithCancel(context.Background())
+	}
+	if !m.running {
+		m.running = true
+		go m.run()
+	}
+	m.mux.Unlock()
+
+	go func() {
+		sub.deliver(ctx, handle)
+		m.unsubscribe(sub)
+		closeCh()
+	}()
+}
+
+// Stops a subscription, the connection is closed once no subscriptions are left
+func (m *SubscriptionManager) unsubscribe(sub *subscription) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	delete(m.subs, sub.id)
+	if len(m.subs) == 0 {
+		m.cancel()
+	}
+	if m.conn == nil {
+		return
+	}
+	// Errors are not logged, a broken connection is already reported by the read loop
+	m.write(m.conn, &gqlMessage{ID: sub.id, Type: gqlStop})
+	if len(m.subs) == 0 {
+		m.write(m.conn, &gqlMessage{Type: gqlConnectionTerminate})
+		m.conn.Close()
+	}
+}
+
+// Passes the queued events to the handler until the context is done
+func (sub *subscription) deliver(ctx context.Context, handle func(data json.RawMessage)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.notify:
+		}
+
+		for ctx.Err() == nil {
+			sub.mux.Lock()
+			if len(sub.pending) == 0 {
+				sub.mux.Unlock()
+				break
+			}
+			data := sub.pending[0]
+			sub.pending[0] = nil
+			sub.pending = sub.pending[1:]
+			sub.mux.Unlock()
+
+			handle(data)
+		}
+	}
+}
+
+// Queues an event for delivery without waiting for the consumer. Drops the oldest queued event if the queue is full,
+// returns true if an event has been dropped.
+func (sub *subscription) enqueue(data json.RawMessage) bool {
+	sub.mux.Lock()
+	dropped := len(sub.pending) >= sub.maxPending
+	if dropped {
+		sub.pending[0] = nil
+		sub.pending = sub.pending[1:]
+	}
+	sub.pending = append(sub.pending, data)
+	sub.mux.Unlock()
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// Sends the start frame of a subscription, must be called with m.mux held
+func (m *SubscriptionManager) start(conn *websocket.Conn, sub *subscription) {
+	payload, _ := json.Marshal(&graphqlRequest{Query: sub.query})
+	err := m.write(conn, &gqlMessage{ID: sub.id, Type: gqlStart, Payload: payload})
+	if err != nil {
+		logger.Errorf("error starting subscription %v of node %v: %v", sub.id, m.host, err)
+	}
+}
+
+func (m *SubscriptionManager) write(conn *websocket.Conn, msg *gqlMessage) error {
+	m.writeMux.Lock()
+	defer m.writeMux.Unlock()
+
+	err := conn.WriteJSON(msg)
+	if err != nil {
+		return fmt.Errorf("error sending %v frame: %w", msg.Type, err)
+	}
+	return nil
+}
+
+// Maintains the websocket connection as long as there are subscriptions
+func (m *SubscriptionManager) run() {
+	retry := 0
+	for {
+		m.mux.Lock()
+		if len(m.subs) == 0 {
+			m.running = false
+			m.mux.Unlock()
+			return
+		}
+		ctx := m.ctx
+		m.mux.Unlock()
+
+		if retry > 0 {
+			select {
+			case <-time.After(m.retry.Backoff(retry - 1)):
+			case <-ctx.Done():
+				// The last subscription has ended while waiting
+				continue
+			}
+			reconnectsCounter.Inc(m.host)
+		}
+
+		received, err := m.serve()
+		m.mux.Lock()
+		// The connection is closed deliberately once the last subscription has ended
+		if err != nil && len(m.subs) > 0 {
+			logger.Errorf("error in websocket connection to node %v: %v", m.host, err)
+		}
+		m.mux.Unlock()
+		if received {
+			retry = 0
+		}
+		retry++
+	}
+}
+
+// Connects to the node and processes incoming frames until the connection is closed. Reports whether any event has
+// been received so that the reconnect backoff can be reset.
+func (m *SubscriptionManager) serve() (bool, error) {
+	header := http.Header{}
+	header.Set("Sec-WebSocket-Protocol", "graphql-ws")
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+m.host, header)
+	if err != nil {
+		return false, fmt.Errorf("error connecting to websocket: %w", err)
+	}
+	defer func() {
+		m.mux.Lock()
+		m.conn = nil
+		m.mux.Unlock()
+		conn.Close()
+	}()
+
+	err = m.write(conn, &gqlMessage{Type: gqlConnectionInit, Payload: json.RawMessage("{}")})
+	if err != nil {
+		return false, err
+	}
+	conn.SetReadDeadline(time.Now().Add(connectionAckTimeout))
+
+	received := false
+	for {
+		msg := &gqlMessage{}
+		err := conn.ReadJSON(msg)
+		if err != nil {
+			return received, fmt.Errorf("error reading from websocket: %w", err)
+		}
+
+		switch msg.Type {
+		case gqlConnectionAck:
+			conn.SetReadDeadline(time.Now().Add(m.keepAliveTimeout))
+			m.mux.Lock()
+			m.conn = conn
+			for _, sub := range m.subs {
+				m.start(conn, sub)
+			}
+			logger.Infof("connected to node %v, started %v subscriptions", m.host, len(m.subs))
+			m.mux.Unlock()
+		case gqlConnectionError:
+			return received, fmt.Errorf("node rejected the connection: %s", msg.Payload)
+		case gqlConnectionKeepAlive:
+			conn.SetReadDeadline(time.Now().Add(m.keepAliveTimeout))
+		case gqlData:
+			conn.SetReadDeadline(time.Now().Add(m.keepAliveTimeout))
+			received = true
+			m.dispatch(msg)
+		case gqlError:
+			logger.Errorf("node %v rejected subscription %v: %s", m.host, msg.ID, msg.Payload)
+		case gqlComplete:
+			logger.Warnf("node %v completed subscription %v, it is restarted on reconnect", m.host, msg.ID)
+		default:
+			logger.Warnf("unexpected %v frame received from node %v", msg.Type, m.host)
+		}
+	}
+}
+
+// Queues the data of an event for delivery to the handler of its subscription
+func (m *SubscriptionManager) dispatch(msg *gqlMessage) {
+	m.mux.Lock()
+	sub, exists := m.subs[msg.ID]
+	m.mux.Unlock()
+	if !exists {
+		return
+	}
+
+	payload := msg.Payload
+	// Some node versions send the payload as json encoded string
+	var encoded string
+	if json.Unmarshal(payload, &encoded) == nil {
+		payload = json.RawMessage(encoded)
+	}
+
+	resp := &graphqlResponse{}
+	err := json.Unmarshal(payload, resp)
+	if err != nil {
+		logger.Errorf("error decoding event of subscription %v of node %v: %v", msg.ID, m.host, err)
+		return
+	}
+	if len(resp.Errors) > 0 {
+		logger.Errorf("error event of subscription %v of node %v: %v", msg.ID, m.host, &GraphQLError{Errors: resp.Errors})
+		return
+	}
+
+	if sub.enqueue(resp.Data) {
+		droppedEventsCounter.Inc(m.host)
+		logger.Warnf("subscription %v of node %v is not consumed fast enough, dropped its oldest queued event", msg.ID, m.host)
+	}
+}
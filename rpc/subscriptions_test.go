@@ -0,0 +1,303 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package rpc
+
+import (
+	"coda-explorer/rpc/fake"
+	"context"
+	"testing"
+	"time"
+)
+
+// Retry policy reconnecting right away
+var testRetryPolicy = &RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 1}
+
+// Time to wait for events and state changes of the subscription manager
+const testTimeout = time.Second * 5
+
+func waitFor(t *testing.T, description string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", description)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func isRunning(m *SubscriptionManager) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.running
+}
+
+func receiveBlock(t *testing.T, events <-chan *NewBlockEvent) *NewBlockEvent {
+	t.Helper()
+
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatalf("new block channel closed unexpectedly")
+		}
+		return e
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for new block event")
+	}
+	return nil
+}
+
+func TestSubscriptions(t *testing.T) {
+	node := newTestNode(t, 4)
+	defer node.Close()
+
+	m := NewSubscriptionManager(node.Host(), testRetryPolicy)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocks := m.SubscribeNewBlocks(ctx)
+	reorgs := m.SubscribeChainReorganizations(ctx)
+	syncUpdates := m.SubscribeSyncUpdates(ctx)
+	waitFor(t, "subscriptions to be started", func() bool { return node.Subscribers() == 3 })
+
+	chain := node.BestChain(4)
+	extension, err := node.Extend(chain[3].StateHash, 1, "creator")
+	if err != nil {
+		t.Fatalf("error extending chain: %v", err)
+	}
+	e := receiveBlock(t, blocks)
+	if e.Invalid != nil || e.Block == nil || e.Block.StateHash != extension[0].StateHash || e.Node != node.Host() {
+		t.Errorf("expected new block %v of node %v, got %+v", extension[0].StateHash, node.Host(), e)
+	}
+
+	fork, err := node.Extend(chain[1].StateHash, 4, "creator")
+	if err != nil {
+		t.Fatalf("error creating fork: %v", err)
+	}
+	e = receiveBlock(t, blocks)
+	if e.Block == nil || e.Block.StateHash != fork[3].StateHash {
+		t.Errorf("expected new block %v at the tip of the fork, got %+v", fork[3].StateHash, e)
+	}
+	select {
+	case r := <-reorgs:
+		if r.Status != "CHANGED" || r.Node != node.Host() {
+			t.Errorf("expected chain reorganization of node %v, got %+v", node.Host(), r)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for chain reorganization event")
+	}
+
+	node.SetSyncStatus("CATCHUP")
+	select {
+	case u := <-syncUpdates:
+		if u.Status != "CATCHUP" {
+			t.Errorf("expected sync status CATCHUP, got %v", u.Status)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for sync update event")
+	}
+
+	cancel()
+	for range blocks {
+	}
+	for range reorgs {
+	}
+	for range syncUpdates {
+	}
+	waitFor(t, "subscriptions to be stopped", func() bool { return node.Subscribers() == 0 })
+	waitFor(t, "connection to be closed", func() bool { return !isRunning(m) })
+	if reconnects := reconnectsCounter.Value(node.Host()); reconnects != 0 {
+		t.Errorf("expected no reconnects, got %v", reconnects)
+	}
+}
+
+func TestSubscriptionReconnect(t *testing.T) {
+	node := newTestNode(t, 1)
+	defer node.Close()
+
+	m := NewSubscriptionManager(node.Host(), testRetryPolicy)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocks := m.SubscribeNewBlocks(ctx)
+	waitFor(t, "subscription to be started", func() bool { return node.Subscribers() == 1 })
+
+	node.DropSubscribers()
+	waitFor(t, "subscription to be restarted", func() bool { return node.Subscribers() == 1 })
+	if reconnects := reconnectsCounter.Value(node.Host()); reconnects != 1 {
+		t.Errorf("expected a single reconnect, got %v", reconnects)
+	}
+
+	extension, err := node.Extend(node.BestTip().StateHash, 1, "creator")
+	if err != nil {
+		t.Fatalf("error extending chain: %v", err)
+	}
+	if e := receiveBlock(t, blocks); e.Block == nil || e.Block.StateHash != extension[0].StateHash {
+		t.Errorf("expected new block %v after reconnecting, got %+v", extension[0].StateHash, e)
+	}
+}
+
+func TestSubscriptionKeepAlive(t *testing.T) {
+	t.Run("keep alive frames received", func(t *testing.T) {
+		node := newTestNode(t, 1)
+		defer node.Close()
+		node.SetKeepAliveInterval(time.Millisecond * 20)
+
+		m := NewSubscriptionManager(node.Host(), testRetryPolicy)
+		m.keepAliveTimeout = time.Millisecond * 200
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.SubscribeNewBlocks(ctx)
+		waitFor(t, "subscription to be started", func() bool { return node.Subscribers() == 1 })
+
+		time.Sleep(time.Millisecond * 600)
+		if reconnects := reconnectsCounter.Value(node.Host()); reconnects != 0 {
+			t.Errorf("expected no reconnects, got %v", reconnects)
+		}
+	})
+
+	t.Run("keep alive frames missing", func(t *testing.T) {
+		node := newTestNode(t, 1)
+		defer node.Close()
+
+		m := NewSubscriptionManager(node.Host(), testRetryPolicy)
+		m.keepAliveTimeout = time.Millisecond * 200
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m.SubscribeNewBlocks(ctx)
+
+		waitFor(t, "connection to be reestablished", func() bool { return reconnectsCounter.Value(node.Host()) >= 1 })
+	})
+}
+
+func TestSubscriptionSlowConsumer(t *testing.T) {
+	node := newTestNode(t, 1)
+	defer node.Close()
+
+	m := NewSubscriptionManager(node.Host(), testRetryPolicy)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocks := m.SubscribeNewBlocks(ctx)
+	syncUpdates := m.SubscribeSyncUpdates(ctx)
+	waitFor(t, "subscriptions to be started", func() bool { return node.Subscribers() == 2 })
+
+	// Nobody reads the new blocks until all events have been sent
+	extension, err := node.Extend(node.BestTip().StateHash, subscriptionBuffer*3, "creator")
+	if err != nil {
+		t.Fatalf("error extending chain: %v", err)
+	}
+	node.SetSyncStatus("CATCHUP")
+	select {
+	case u := <-syncUpdates:
+		if u.Status != "CATCHUP" {
+			t.Errorf("expected sync status CATCHUP, got %v", u.Status)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for sync update event while new blocks are not consumed")
+	}
+
+	for _, b := range extension {
+		if e := receiveBlock(t, blocks); e.Block == nil || e.Block.StateHash != b.StateHash {
+			t.Fatalf("expected new block %v, got %+v", b.StateHash, e)
+		}
+	}
+
+	cancel()
+	for range blocks {
+	}
+	for range syncUpdates {
+	}
+}
+
+// Once the queue of a subscription is full its oldest events are dropped, the newest events are still delivered
+func TestSubscriptionDropsOldestEvents(t *testing.T) {
+	node := newTestNode(t, 1)
+	defer node.Close()
+
+	m := NewSubscriptionManager(node.Host(), testRetryPolicy)
+	m.maxPending = 4
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocks := m.SubscribeNewBlocks(ctx)
+	waitFor(t, "subscription to be started", func() bool { return node.Subscribers() == 1 })
+	droppedBefore := droppedEventsCounter.Value(node.Host())
+
+	// The channel buffer, the event being passed to the channel and the queue hold subscriptionBuffer+1+maxPending events
+	extension, err := node.Extend(node.BestTip().StateHash, subscriptionBuffer*3, "creator")
+	if err != nil {
+		t.Fatalf("error extending chain: %v", err)
+	}
+	// Wait until the queue is full before consuming
+	waitFor(t, "events to be dropped", func() bool {
+		return droppedEventsCounter.Value(node.Host())-droppedBefore >= float64(len(extension)-subscriptionBuffer-1-m.maxPending)
+	})
+
+	positions := make(map[string]int)
+	for i, b := range extension {
+		positions[b.StateHash] = i
+	}
+	received := 0
+	last := -1
+	for last < len(extension)-1 {
+		e := receiveBlock(t, blocks)
+		if e.Block == nil {
+			t.Fatalf("expected a new block, got %+v", e)
+		}
+		pos, known := positions[e.Block.StateHash]
+		if !known || pos <= last {
+			t.Fatalf("received block %v out of order", e.Block.StateHash)
+		}
+		last = pos
+		received++
+	}
+
+	dropped := int(droppedEventsCounter.Value(node.Host()) - droppedBefore)
+	if received+dropped != len(extension) {
+		t.Errorf("%v events received and %v dropped, expected %v in total", received, dropped, len(extension))
+	}
+
+	cancel()
+	for range blocks {
+	}
+}
+
+func TestUnsubscribeWhileReconnecting(t *testing.T) {
+	node := fake.NewNode()
+	host := node.Host()
+	node.Close()
+
+	m := NewSubscriptionManager(host, &RetryPolicy{InitialBackoff: time.Hour, MaxBackoff: time.Hour, Multiplier: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	blocks := m.SubscribeNewBlocks(ctx)
+
+	// Let the first connection attempt fail
+	time.Sleep(time.Millisecond * 100)
+	cancel()
+
+	select {
+	case _, ok := <-blocks:
+		if ok {
+			t.Errorf("expected no events")
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for the channel to be closed")
+	}
+	waitFor(t, "reconnecting to be aborted", func() bool { return !isRunning(m) })
+	if reconnects := reconnectsCounter.Value(host); reconnects != 0 {
+		t.Errorf("expected no reconnects, got %v", reconnects)
+	}
+}
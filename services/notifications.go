@@ -0,0 +1,92 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package services
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/types"
+	"context"
+	"sync"
+)
+
+var notificationMux = &sync.Mutex{}
+var notificationSubscribers = make(map[chan *types.Notification]bool)
+
+// SubscribeNotifications registers an in-process consumer of the change notifications sent by the indexer. A nil
+// notification is delivered after the connection to the database has been re-established and notifications might
+// have been missed.
+func SubscribeNotifications() chan *types.Notification {
+	notificationMux.Lock()
+	defer notificationMux.Unlock()
+
+	ch := make(chan *types.Notification, 100)
+	notificationSubscribers[ch] = true
+	return ch
+}
+
+// UnsubscribeNotifications removes a consumer registered by SubscribeNotifications
+func UnsubscribeNotifications(ch chan *types.Notification) {
+	notificationMux.Lock()
+	defer notificationMux.Unlock()
+
+	if notificationSubscribers[ch] {
+		delete(notificationSubscribers, ch)
+		close(ch)
+	}
+}
+
+// Listens for change notifications and fans them out to all subscribers until the context is done, the channels of
+// all subscribers are closed afterwards
+func startNotificationListener(ctx context.Context, dbConnString string) error {
+	notifications := make(chan *types.Notification, 100)
+	err := db.ListenNotifications(ctx, dbConnString, notifications)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer closeNotificationSubscribers()
+
+		for n := range notifications {
+			if n == nil {
+				logger.Warnf("notification listener reconnected, notifications might have been missed")
+			}
+
+			notificationMux.Lock()
+			for ch := range notificationSubscribers {
+				select {
+				case ch <- n:
+				default:
+					logger.Warnf("dropping notification for slow subscriber")
+				}
+			}
+			notificationMux.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+func closeNotificationSubscribers() {
+	notificationMux.Lock()
+	defer notificationMux.Unlock()
+
+	for ch := range notificationSubscribers {
+		delete(notificationSubscribers, ch)
+		close(ch)
+	}
+}
@@ -18,7 +18,9 @@ package services
 
 import (
 	"coda-explorer/db"
+	"coda-explorer/metrics"
 	"coda-explorer/types"
+	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"sync"
@@ -35,58 +37,141 @@ var GeoIpDb ip2location.IP2Location
 
 var logger = logrus.New().WithField("module", "services")
 
-// Init will initialize the services
-func Init() {
+var cacheAgeGauge = metrics.NewGaugeVec("coda_services_cache_age_seconds", "Seconds since a cache of the frontend has last been refreshed", "cache")
 
-	db, err := ip2location.NewIP2Location("ip2location/IP2LOCATION-LITE-DB5.BIN")
+// Records the refresh of a cache, its age is computed whenever the metrics are written
+func cacheRefreshed(cache string) {
+	refreshed := time.Now()
+	cacheAgeGauge.SetFunc(func() float64 {
+		return time.Since(refreshed).Seconds()
+	}, cache)
+}
+
+// Init will initialize the services using the ip2location database at the given path, the background updaters run
+// until the context is done
+func Init(ctx context.Context, dbConnString string, ip2LocationPath string) {
+
+	db, err := ip2location.NewIP2Location(ip2LocationPath)
 	if err != nil {
 		logger.Fatalf("error opening ip2location database: %v", err)
 	}
 	GeoIpDb = db
 
+	StartUpdaters(ctx, dbConnString)
+}
+
+// StartUpdaters starts listening for change notifications of the indexer and the background updaters of the latest height,
+// the index page data and the stream events. Waits until the height and index page data are populated. Once the context
+// is done the updaters stop and all stream consumers are disconnected.
+func StartUpdaters(ctx context.Context, dbConnString string) {
+	heightNotifications := SubscribeNotifications()
+	indexPageDataNotifications := SubscribeNotifications()
+	streamNotifications := SubscribeNotifications()
+
+	err := startNotificationListener(ctx, dbConnString)
+	if err != nil {
+		logger.Fatalf("error starting notification listener: %v", err)
+	}
+
 	ready.Add(2)
-	go heightUpdater()
-	go indexPageDataUpdater()
+	go heightUpdater(ctx, heightNotifications)
+	go indexPageDataUpdater(ctx, indexPageDataNotifications)
 	ready.Wait()
+
+	go streamUpdater(streamNotifications)
 }
 
-func heightUpdater() {
-	firstRun := true
+// Updates the latest height whenever a block is saved
+func heightUpdater(ctx context.Context, notifications chan *types.Notification) {
+	populated := retryUntilPopulated(ctx, updateHeight)
+	ready.Done()
+	if !populated {
+		return
+	}
+
+	for n := range notifications {
+		if n == nil {
+			// Notifications might have been missed
+			updateHeight()
+			continue
+		}
 
-	for true {
-		var height uint64
-		err := db.DB.Get(&height, "SELECT COALESCE(MAX(height), 0) FROM blocks")
+		if n.Type != types.NotificationBlockSaved {
+			continue
+		}
 
-		if err != nil {
-			logger.Printf("error retrieving latest height from the database: %v", err)
-		} else {
-			atomic.StoreUint64(&latestHeight, height)
-			if firstRun {
-				ready.Done()
-				firstRun = false
+		for {
+			height := atomic.LoadUint64(&latestHeight)
+			if uint64(n.Height) <= height || atomic.CompareAndSwapUint64(&latestHeight, height, uint64(n.Height)) {
+				break
 			}
 		}
-		time.Sleep(time.Second)
+		cacheRefreshed("latest_height")
 	}
 }
 
-func indexPageDataUpdater() {
-	firstRun := true
+func updateHeight() bool {
+	var height uint64
+	err := db.DB.Get("services.updateHeight", &height, "SELECT COALESCE(MAX(height), 0) FROM blocks")
+	if err != nil {
+		logger.Errorf("error retrieving latest height from the database: %v", err)
+		return false
+	}
+	atomic.StoreUint64(&latestHeight, height)
+	cacheRefreshed("latest_height")
+	return true
+}
 
-	for true {
-		data, err := getIndexPageData()
-		if err != nil {
-			logger.Errorf("error retrieving index page data: %v", err)
-			time.Sleep(time.Second * 10)
-			continue
+// Calls update until it succeeds, returns false if the context is done before
+func retryUntilPopulated(ctx context.Context, update func() bool) bool {
+	for !update() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second * 10):
 		}
-		indexPageData.Store(data)
-		if firstRun {
-			ready.Done()
-			firstRun = false
+	}
+	return true
+}
+
+// Refreshes the index page data on every change notification. As the data contains values over the last 24 hours
+// it is also refreshed periodically.
+func indexPageDataUpdater(ctx context.Context, notifications chan *types.Notification) {
+	populated := retryUntilPopulated(ctx, updateIndexPageData)
+	ready.Done()
+	if !populated {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case _, ok := <-notifications:
+			if !ok {
+				return
+			}
+			// Coalesce notifications sent in quick succession, e.g. during a chain reorganization
+			time.Sleep(time.Millisecond * 100)
+			for len(notifications) > 0 {
+				<-notifications
+			}
+		case <-ticker.C:
 		}
-		time.Sleep(time.Second * 10)
+		updateIndexPageData()
+	}
+}
+
+func updateIndexPageData() bool {
+	data, err := getIndexPageData()
+	if err != nil {
+		logger.Errorf("error retrieving index page data: %v", err)
+		return false
 	}
+	indexPageData.Store(data)
+	cacheRefreshed("index_page_data")
+	return true
 }
 
 func getIndexPageData() (*types.IndexPageData, error) {
@@ -94,7 +179,7 @@ func getIndexPageData() (*types.IndexPageData, error) {
 
 	var blocks []*types.Block
 
-	err := db.DB.Select(&blocks, `SELECT *
+	err := db.DB.Select("services.getIndexPageData", &blocks, `SELECT *
 										FROM blocks 
 										ORDER BY blocks.height DESC, canonical DESC LIMIT 20`)
 
@@ -110,17 +195,17 @@ func getIndexPageData() (*types.IndexPageData, error) {
 		data.TotalSupply = blocks[0].TotalCurrency
 	}
 
-	err = db.DB.Get(&data.ActiveWorkers, "SELECT COUNT(DISTINCT prover) FROM snarkjobs LEFT JOIN blocks ON blocks.statehash = snarkjobs.blockstatehash WHERE blocks.ts > now() - interval '1 day'")
+	err = db.DB.Get("services.getIndexPageData", &data.ActiveWorkers, "SELECT COUNT(DISTINCT prover) FROM snarkjobs LEFT JOIN blocks ON blocks.statehash = snarkjobs.blockstatehash WHERE blocks.ts > now() - interval '1 day'")
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving active workers data: %w", err)
 	}
 
-	err = db.DB.Get(&data.ActiveValidators, "select count (distinct creator) from blocks where ts > now() - interval '1 day';")
+	err = db.DB.Get("services.getIndexPageData", &data.ActiveValidators, "select count (distinct creator) from blocks where ts > now() - interval '1 day';")
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving active validators data: %w", err)
 	}
 
-	err = db.DB.Get(&data.Peers, "select peerscount from daemonstatus order by ts desc limit 1;")
+	err = db.DB.Get("services.getIndexPageData", &data.Peers, "select peerscount from daemonstatus order by ts desc limit 1;")
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving peerscount data: %w", err)
 	}
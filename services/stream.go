@@ -0,0 +1,141 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package services
+
+import (
+	"coda-explorer/db"
+	"coda-explorer/types"
+	"sync"
+)
+
+// Number of past events kept to allow reconnecting clients to catch up
+const streamHistorySize = 100
+
+var streamMux = &sync.Mutex{}
+var streamSubscribers = make(map[chan *types.StreamEvent]bool)
+var streamHistory []*types.StreamEvent
+var streamEventID uint64
+
+// SubscribeStream registers a new consumer of stream events. Events after lastEventID that are still kept in the
+// history are delivered first, pass 0 to only receive new events. The channel is closed if the consumer can
+// not keep up with the published events.
+func SubscribeStream(lastEventID uint64) chan *types.StreamEvent {
+	streamMux.Lock()
+	defer streamMux.Unlock()
+
+	ch := make(chan *types.StreamEvent, streamHistorySize*2)
+	if lastEventID > 0 {
+		for _, e := range streamHistory {
+			if e.ID > lastEventID {
+				ch <- e
+			}
+		}
+	}
+	streamSubscribers[ch] = true
+	return ch
+}
+
+// UnsubscribeStream removes a consumer registered by SubscribeStream
+func UnsubscribeStream(ch chan *types.StreamEvent) {
+	streamMux.Lock()
+	defer streamMux.Unlock()
+
+	if streamSubscribers[ch] {
+		delete(streamSubscribers, ch)
+		close(ch)
+	}
+}
+
+func publishStreamEvent(event *types.StreamEvent) {
+	streamMux.Lock()
+	defer streamMux.Unlock()
+
+	streamEventID++
+	event.ID = streamEventID
+
+	streamHistory = append(streamHistory, event)
+	if len(streamHistory) > streamHistorySize {
+		streamHistory = streamHistory[len(streamHistory)-streamHistorySize:]
+	}
+
+	for ch := range streamSubscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warnf("dropping slow stream subscriber")
+			delete(streamSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Closes the channels of all stream consumers, causing the stream handlers to end their responses
+func closeStreamSubscribers() {
+	streamMux.Lock()
+	defer streamMux.Unlock()
+
+	for ch := range streamSubscribers {
+		delete(streamSubscribers, ch)
+		close(ch)
+	}
+}
+
+// Publishes stream events for all saved blocks and canonical status changes until the notification channel is closed,
+// all stream consumers are disconnected afterwards
+func streamUpdater(notifications chan *types.Notification) {
+	defer closeStreamSubscribers()
+
+	for n := range notifications {
+		if n == nil {
+			continue
+		}
+
+		var err error
+		switch n.Type {
+		case types.NotificationBlockSaved:
+			err = publishBlockEvents(n.StateHash, types.StreamEventNewBlock)
+		case types.NotificationBlockCanonical:
+			err = publishBlockEvents(n.StateHash, types.StreamEventBlockCanonical)
+		case types.NotificationBlockOrphaned:
+			err = publishBlockEvents(n.StateHash, types.StreamEventBlockOrphaned)
+		}
+		if err != nil {
+			logger.Errorf("error publishing stream events for block %v: %v", n.StateHash, err)
+		}
+	}
+}
+
+func publishBlockEvents(stateHash string, eventType string) error {
+	block, err := db.GetBlockByHash(stateHash)
+	if err != nil {
+		return err
+	}
+	userJobs := block.UserJobs
+
+	block.SnarkJobs = nil
+	block.FeeTransfers = nil
+	block.UserJobs = nil
+
+	publishStreamEvent(&types.StreamEvent{Type: eventType, Block: block})
+
+	if eventType == types.StreamEventNewBlock {
+		for _, uj := range userJobs {
+			publishStreamEvent(&types.StreamEvent{Type: types.StreamEventNewTransaction, Block: block, Transaction: uj})
+		}
+	}
+	return nil
+}
@@ -76,3 +76,46 @@ items.forEach(function(item, index) {
     handleIndicator(item)
   })
 })
+
+// Formats an amount of nanocoda (string or number) as coda with 9 decimals
+function formatCoda(nanocoda) {
+  var str = String(nanocoda)
+  var sign = ''
+  if (str.charAt(0) === '-') {
+    sign = '-'
+    str = str.substr(1)
+  }
+  while (str.length < 10) {
+    str = '0' + str
+  }
+  var coda = str.substr(0, str.length - 9).replace(/\B(?=(\d{3})+(?!\d))/g, ',')
+  return sign + coda + '.' + str.substr(str.length - 9) + ' CODA'
+}
+
+// Live updates
+// Calls onEvent for every newly indexed block, canonical status change and new transaction pushed by the /stream
+// endpoint. A websocket is used if available, otherwise the events are received as server-sent events.
+function subscribeStream(onEvent) {
+  if (window.WebSocket) {
+    var connect = function () {
+      var protocol = window.location.protocol === 'https:' ? 'wss://' : 'ws://'
+      var socket = new WebSocket(protocol + window.location.host + '/stream')
+      socket.onmessage = function (msg) {
+        onEvent(JSON.parse(msg.data))
+      }
+      socket.onclose = function () {
+        setTimeout(connect, 5000)
+      }
+    }
+    connect()
+    return
+  }
+
+  var source = new EventSource('/stream')
+  var types = ['new_block', 'block_canonical', 'block_orphaned', 'new_transaction']
+  types.forEach(function (type) {
+    source.addEventListener(type, function (msg) {
+      onEvent(JSON.parse(msg.data))
+    })
+  })
+}
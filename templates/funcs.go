@@ -18,6 +18,7 @@ package templates
 
 import (
 	"coda-explorer/services"
+	"coda-explorer/types"
 	"fmt"
 	"github.com/lib/pq"
 	"html/template"
@@ -38,6 +39,7 @@ func GetTemplateFuncs() template.FuncMap {
 		"decodeBase58":       decodeBase58,
 		"joinHtml":           joinHtml,
 		"ipToCountry":        ipToCountry,
+		"formatAmount":       formatAmount,
 	}
 
 	gtf.ForceInject(fm)
@@ -54,6 +56,11 @@ func formatMilliSeconds(ms int) string {
 	return fmt.Sprintf("%v", time.Millisecond*time.Duration(ms))
 }
 
+// Formats an amount of nanocoda as coda with 9 decimals
+func formatAmount(amount types.Amount) string {
+	return amount.Coda() + " CODA"
+}
+
 // Formats a array of int64 values (postgresql driver format)
 func formatPGIntArray(arr pq.Int64Array) string {
 	return strings.Trim(strings.Replace(fmt.Sprint(arr), " ", ", ", -1), "[]")
@@ -0,0 +1,199 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Number of nanocoda per coda
+const nanocodaPerCoda = 1000000000
+
+// Amount represents an amount of coda in nanocoda with arbitrary precision.
+// The zero value represents an amount of 0, amounts are immutable once created.
+type Amount struct {
+	value *big.Int
+}
+
+// NewAmount creates a new amount from a nanocoda value
+func NewAmount(nanocoda int64) Amount {
+	return Amount{value: big.NewInt(nanocoda)}
+}
+
+// ParseAmount parses a decimal string containing an amount in nanocoda as returned by String, negative amounts start
+// with a minus sign
+func ParseAmount(str string) (Amount, error) {
+	digits := strings.TrimPrefix(str, "-")
+	// Numeric db columns may carry a fractional part consisting of zeros only
+	if i := strings.IndexByte(digits, '.'); i >= 0 && strings.Trim(digits[i+1:], "0") == "" {
+		digits = digits[:i]
+	}
+	if !isDigits(digits) {
+		return Amount{}, fmt.Errorf("invalid amount %q", str)
+	}
+	if strings.HasPrefix(str, "-") {
+		digits = "-" + digits
+	}
+
+	value, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount %q", str)
+	}
+	return Amount{value: value}, nil
+}
+
+// ParseCoda parses an unsigned decimal string containing an amount in coda with at most 9 decimals
+func ParseCoda(str string) (Amount, error) {
+	whole, fraction := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		whole, fraction = str[:i], str[i+1:]
+		if !isDigits(fraction) {
+			return Amount{}, fmt.Errorf("invalid coda amount %q", str)
+		}
+	}
+	if !isDigits(whole) || len(fraction) > 9 {
+		return Amount{}, fmt.Errorf("invalid coda amount %q", str)
+	}
+
+	amount, err := ParseAmount(whole + fraction + strings.Repeat("0", 9-len(fraction)))
+	if err != nil {
+		return Amount{}, fmt.Errorf("invalid coda amount %q", str)
+	}
+	return amount, nil
+}
+
+// Returns true if the string is not empty and consists of the digits 0-9 only
+func isDigits(str string) bool {
+	if str == "" {
+		return false
+	}
+	for _, c := range str {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// BigInt returns a copy of the amount in nanocoda as big.Int
+func (a Amount) BigInt() *big.Int {
+	if a.value == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(a.value)
+}
+
+// Add returns the sum of both amounts
+func (a Amount) Add(b Amount) Amount {
+	return Amount{value: new(big.Int).Add(a.BigInt(), b.BigInt())}
+}
+
+// Sub returns the difference of both amounts
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{value: new(big.Int).Sub(a.BigInt(), b.BigInt())}
+}
+
+// Cmp compares both amounts and returns -1, 0 or +1
+func (a Amount) Cmp(b Amount) int {
+	return a.BigInt().Cmp(b.BigInt())
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of the amount
+func (a Amount) Sign() int {
+	if a.value == nil {
+		return 0
+	}
+	return a.value.Sign()
+}
+
+// String returns the amount in nanocoda as decimal string
+func (a Amount) String() string {
+	if a.value == nil {
+		return "0"
+	}
+	return a.value.String()
+}
+
+// Coda returns the amount in coda as decimal string with 9 decimals
+func (a Amount) Coda() string {
+	quo, rem := new(big.Int).QuoRem(a.BigInt(), big.NewInt(nanocodaPerCoda), new(big.Int))
+
+	sign := ""
+	if a.Sign() < 0 {
+		sign = "-"
+		quo.Abs(quo)
+		rem.Abs(rem)
+	}
+	return fmt.Sprintf("%s%s.%09s", sign, quo.String(), rem.String())
+}
+
+// Value implements the driver.Valuer interface, amounts are stored as numeric values which Scan reads back including their sign
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan implements the sql.Scanner interface
+func (a *Amount) Scan(src interface{}) error {
+	var str string
+	switch v := src.(type) {
+	case nil:
+		*a = Amount{}
+		return nil
+	case int64:
+		*a = NewAmount(v)
+		return nil
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return fmt.Errorf("cannot scan %T into amount", src)
+	}
+
+	parsed, err := ParseAmount(str)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface, amounts are encoded as strings to retain their precision
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, accepts both strings and numbers including the signed
+// strings written by MarshalJSON
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(string(data), "\"")
+	if str == "null" {
+		*a = Amount{}
+		return nil
+	}
+
+	parsed, err := ParseAmount(str)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
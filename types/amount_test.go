@@ -0,0 +1,214 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{"0", "0", true},
+		{"1", "1", true},
+		{"20000000000", "20000000000", true},
+		{"123456789012345678901234567890", "123456789012345678901234567890", true},
+		{"000123", "123", true},
+		// Fractional parts of numeric db columns
+		{"5.000", "5", true},
+		{"5.", "5", true},
+		{"5.001", "", false},
+		{"", "", false},
+		{".0", "", false},
+		// Negative amounts as written by String
+		{"-1", "-1", true},
+		{"-20000000000", "-20000000000", true},
+		{"-5.000", "-5", true},
+		{"-", "", false},
+		{"--1", "", false},
+		{"- 1", "", false},
+		{"+1", "", false},
+		{"1e9", "", false},
+		{"0x10", "", false},
+		{" 1", "", false},
+		{"1,000", "", false},
+		{"one", "", false},
+	}
+
+	for _, tt := range tests {
+		amount, err := ParseAmount(tt.input)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("ParseAmount(%q) = %v, expected an error", tt.input, amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAmount(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if amount.String() != tt.expected {
+			t.Errorf("ParseAmount(%q) = %v, expected %v", tt.input, amount, tt.expected)
+		}
+	}
+}
+
+func TestParseCoda(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{"0", "0", true},
+		{"1", "1000000000", true},
+		{"1.5", "1500000000", true},
+		{"0.000000001", "1", true},
+		{"1.000000001", "1000000001", true},
+		{"12345.123456789", "12345123456789", true},
+		{"20.000000000", "20000000000", true},
+		// More than 9 decimals would have to be rounded
+		{"0.0000000001", "", false},
+		{"1.1234567890", "", false},
+		{"", "", false},
+		{".", "", false},
+		{".5", "", false},
+		{"1.", "", false},
+		{"-1", "", false},
+		{"+1", "", false},
+		{"1.-5", "", false},
+		{"1.+5", "", false},
+		{"1.2.3", "", false},
+		{"1e9", "", false},
+		{"one", "", false},
+	}
+
+	for _, tt := range tests {
+		amount, err := ParseCoda(tt.input)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("ParseCoda(%q) = %v, expected an error", tt.input, amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCoda(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if amount.String() != tt.expected {
+			t.Errorf("ParseCoda(%q) = %v, expected %v", tt.input, amount, tt.expected)
+		}
+	}
+}
+
+func TestAmountCoda(t *testing.T) {
+	tests := []struct {
+		nanocoda int64
+		expected string
+	}{
+		{0, "0.000000000"},
+		{1, "0.000000001"},
+		{1500000000, "1.500000000"},
+		{20000000000, "20.000000000"},
+		{-1, "-0.000000001"},
+		{-1500000000, "-1.500000000"},
+	}
+
+	for _, tt := range tests {
+		coda := NewAmount(tt.nanocoda).Coda()
+		if coda != tt.expected {
+			t.Errorf("NewAmount(%v).Coda() = %v, expected %v", tt.nanocoda, coda, tt.expected)
+		}
+		if tt.nanocoda < 0 {
+			continue
+		}
+		parsed, err := ParseCoda(coda)
+		if err != nil || parsed.Cmp(NewAmount(tt.nanocoda)) != 0 {
+			t.Errorf("ParseCoda(%q) = %v, %v, expected %v", coda, parsed, err, tt.nanocoda)
+		}
+	}
+
+	if (Amount{}).Coda() != "0.000000000" {
+		t.Errorf("zero value is formatted as %v", (Amount{}).Coda())
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var decoded struct {
+		Number Amount `json:"number"`
+		String Amount `json:"string"`
+		Null   Amount `json:"null"`
+	}
+	err := json.Unmarshal([]byte(`{"number": 123, "string": "456", "null": null}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Number.String() != "123" || decoded.String.String() != "456" || decoded.Null.Sign() != 0 {
+		t.Errorf("unexpected decoded amounts %v, %v, %v", decoded.Number, decoded.String, decoded.Null)
+	}
+
+	err = json.Unmarshal([]byte(`{"number": 1.5}`), &decoded)
+	if err == nil {
+		t.Error("expected an error for a fractional amount")
+	}
+
+	encoded, err := json.Marshal(NewAmount(789))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"789"` {
+		t.Errorf("amount is encoded as %s", encoded)
+	}
+}
+
+// Negative amounts, e.g. balances derived from an incomplete account history, are read back as they have been written
+func TestAmountNegativeRoundTrip(t *testing.T) {
+	amount := NewAmount(100).Sub(NewAmount(250))
+
+	encoded, err := json.Marshal(amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"-150"` {
+		t.Errorf("amount is encoded as %s", encoded)
+	}
+	var decoded Amount
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cmp(amount) != 0 {
+		t.Errorf("amount %v is decoded as %v", amount, decoded)
+	}
+
+	value, err := amount.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Postgres returns numeric values as text
+	var scanned Amount
+	err = scanned.Scan([]byte(value.(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned.Cmp(amount) != 0 {
+		t.Errorf("amount %v is scanned as %v", amount, scanned)
+	}
+}
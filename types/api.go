@@ -0,0 +1,35 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package types
+
+// APIResponse is the envelope of all successful /api/v1 responses. Next contains the cursor
+// for retrieving the following page of paginated resources and is empty on the last page.
+type APIResponse struct {
+	Data interface{} `json:"data"`
+	Next string      `json:"next,omitempty"`
+}
+
+// APIErrorResponse is the envelope of all failed /api/v1 responses
+type APIErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// APIError describes why an api request failed
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
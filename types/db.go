@@ -28,20 +28,23 @@ type Block struct {
 	PreviousStateHash string    `db:"previousstatehash" json:"previous_state_hash"`
 	SnarkedLedgerHash string    `db:"snarkedledgerhash" json:"snarked_ledger_hash"`
 	StagedLedgerHash  string    `db:"stagedledgerhash" json:"staged_ledger_hash"`
-	Coinbase          int       `db:"coinbase" json:"coinbase"`
+	Coinbase          Amount    `db:"coinbase" json:"coinbase"`
 	Creator           string    `db:"creator" json:"creator"`
 	Slot              int       `db:"slot" json:"slot"`
 	Height            int       `db:"height" json:"height"`
 	Epoch             int       `db:"epoch" json:"epoch"`
 	Ts                time.Time `db:"ts" json:"ts"`
-	TotalCurrency     int       `db:"totalcurrency" json:"total_currency"`
+	TotalCurrency     Amount    `db:"totalcurrency" json:"total_currency"`
 	UserCommandsCount int       `db:"usercommandscount" json:"user_commands_count"`
 	SnarkJobsCount    int       `db:"snarkjobscount" json:"snark_jobs_count"`
 	FeeTransferCount  int       `db:"feetransfercount" json:"fee_transfer_count"`
 
-	SnarkJobs    []*SnarkJob
-	FeeTransfers []*FeeTransfer
-	UserJobs     []*UserJob
+	SnarkJobs    []*SnarkJob    `json:"snark_jobs,omitempty"`
+	FeeTransfers []*FeeTransfer `json:"fee_transfers,omitempty"`
+	UserJobs     []*UserJob     `json:"user_jobs,omitempty"`
+
+	// State of the accounts touched by the block after it has been applied, only set during indexing
+	AccountBalances []*AccountBalance `json:"-"`
 }
 
 // BlockHashNumber is a helper type that contains only the hash, the parent hash, the height and the canonical status of a block
@@ -52,54 +55,152 @@ type BlockHashNumber struct {
 	Height            int    `db:"height"`
 }
 
+// HeightGap is a helper type that contains an inclusive range of block heights missing from the blocks db table
+type HeightGap struct {
+	From int `db:"fromheight"`
+	To   int `db:"toheight"`
+}
+
+// BackfillCheckpoint represents a row of the backfillcheckpoint db table
+type BackfillCheckpoint struct {
+	StateHash string    `db:"statehash"`
+	Canonical bool      `db:"canonical"`
+	Updated   time.Time `db:"updated"`
+}
+
 // SnarkJob represents a row of the snarkjobs db table
 type SnarkJob struct {
-	BlockStateHash string        `db:"blockstatehash"`
-	Canonical      bool          `db:"canonical"`
-	Index          int           `db:"index"`
-	Jobids         pq.Int64Array `db:"jobids"`
-	Prover         string        `db:"prover"`
-	Fee            int           `db:"fee"`
+	BlockStateHash string        `db:"blockstatehash" json:"block_state_hash"`
+	Canonical      bool          `db:"canonical" json:"canonical"`
+	Index          int           `db:"index" json:"index"`
+	Jobids         pq.Int64Array `db:"jobids" json:"job_ids"`
+	Prover         string        `db:"prover" json:"prover"`
+	Fee            Amount        `db:"fee" json:"fee"`
 }
 
 // FeeTransfer represents a row of the feetransfers db table
 type FeeTransfer struct {
-	BlockStateHash string `db:"blockstatehash"`
-	Canonical      bool   `db:"canonical"`
-	Index          int    `db:"index"`
-	Recipient      string `db:"recipient"`
-	Fee            int    `db:"fee"`
+	BlockStateHash string `db:"blockstatehash" json:"block_state_hash"`
+	Canonical      bool   `db:"canonical" json:"canonical"`
+	Index          int    `db:"index" json:"index"`
+	Recipient      string `db:"recipient" json:"recipient"`
+	Fee            Amount `db:"fee" json:"fee"`
 }
 
 // UserJob represents a row of the userjobs db table
 type UserJob struct {
-	BlockStateHash string `db:"blockstatehash"`
-	Canonical      bool   `db:"canonical"`
-	Index          int    `db:"index"`
-	ID             string `db:"id"`
-	Sender         string `db:"sender"`
-	Recipient      string `db:"recipient"`
-	Memo           string `db:"memo"`
-	Fee            int    `db:"fee"`
-	Amount         int    `db:"amount"`
-	Nonce          int    `db:"nonce"`
-	Delegation     bool   `db:"delegation"`
+	BlockStateHash string `db:"blockstatehash" json:"block_state_hash"`
+	Canonical      bool   `db:"canonical" json:"canonical"`
+	Index          int    `db:"index" json:"index"`
+	ID             string `db:"id" json:"id"`
+	Sender         string `db:"sender" json:"sender"`
+	Recipient      string `db:"recipient" json:"recipient"`
+	Memo           string `db:"memo" json:"memo"`
+	Fee            Amount `db:"fee" json:"fee"`
+	Amount         Amount `db:"amount" json:"amount"`
+	Nonce          int    `db:"nonce" json:"nonce"`
+	Delegation     bool   `db:"delegation" json:"delegation"`
 }
 
 // Account represents a row of the accounts db table
 type Account struct {
-	PublicKey        string    `db:"publickey"`
-	Balance          int       `db:"balance"`
-	Nonce            int       `db:"nonce"`
-	ReceiptChainHash string    `db:"receiptchainhash"`
-	Delegate         string    `db:"delegate"`
-	VotingFor        string    `db:"votingfor"`
-	TxSent           int       `db:"txsent"`
-	TxReceived       int       `db:"txreceived"`
-	BlocksProposed   int       `db:"blocksproposed"`
-	SnarkJobs        int       `db:"snarkjobs"`
-	FirstSeen        time.Time `db:"firstseen"`
-	LastSeen         time.Time `db:"lastseen"`
+	PublicKey        string    `db:"publickey" json:"public_key"`
+	Balance          Amount    `db:"balance" json:"balance"`
+	Nonce            int       `db:"nonce" json:"nonce"`
+	ReceiptChainHash string    `db:"receiptchainhash" json:"receipt_chain_hash"`
+	Delegate         string    `db:"delegate" json:"delegate"`
+	VotingFor        string    `db:"votingfor" json:"voting_for"`
+	TxSent           int       `db:"txsent" json:"tx_sent"`
+	TxReceived       int       `db:"txreceived" json:"tx_received"`
+	BlocksProposed   int       `db:"blocksproposed" json:"blocks_proposed"`
+	SnarkJobs        int       `db:"snarkjobs" json:"snark_jobs"`
+	FirstSeen        time.Time `db:"firstseen" json:"first_seen"`
+	LastSeen         time.Time `db:"lastseen" json:"last_seen"`
+}
+
+// AccountBalance represents a row of the account_balances db table, it holds the state of an account after a block
+// touching the account has been applied. Derived states have been computed by replaying the block against the previous
+// known state, all other states have been reported by the node while the block was its best tip.
+type AccountBalance struct {
+	PublicKey      string    `db:"publickey" json:"public_key"`
+	BlockStateHash string    `db:"blockstatehash" json:"block_state_hash"`
+	Height         int       `db:"height" json:"height"`
+	Canonical      bool      `db:"canonical" json:"canonical"`
+	Balance        Amount    `db:"balance" json:"balance"`
+	Nonce          int       `db:"nonce" json:"nonce"`
+	Ts             time.Time `db:"ts" json:"ts"`
+	Delegate       string    `db:"delegate" json:"delegate"`
+	Derived        bool      `db:"derived" json:"derived"`
+}
+
+// AccountDiscrepancy represents a row of the account_discrepancies db table, a field of an account state that differs
+// between the state reported by the node and the state derived by replaying a block
+type AccountDiscrepancy struct {
+	PublicKey      string    `db:"publickey"`
+	BlockStateHash string    `db:"blockstatehash"`
+	Height         int       `db:"height"`
+	Field          string    `db:"field"`
+	Derived        string    `db:"derived"`
+	Node           string    `db:"node"`
+	DetectedAt     time.Time `db:"detectedat"`
+}
+
+// QuarantinedBlock represents a row of the quarantined_blocks db table, a block returned by the node that failed
+// validation. Errors and Payload contain json, Height is zero if it could not be read from the payload.
+type QuarantinedBlock struct {
+	StateHash         string    `db:"statehash"`
+	PreviousStateHash string    `db:"previousstatehash"`
+	Height            int       `db:"height"`
+	Errors            string    `db:"errors"`
+	Payload           string    `db:"payload"`
+	Attempts          int       `db:"attempts"`
+	FirstSeen         time.Time `db:"firstseen"`
+	LastSeen          time.Time `db:"lastseen"`
+}
+
+// NodeDivergence represents a row of the node_divergences db table, the block a node had at a height at which the
+// best chains of the configured nodes disagreed
+type NodeDivergence struct {
+	Height    int       `db:"height"`
+	Node      string    `db:"node"`
+	StateHash string    `db:"statehash"`
+	FirstSeen time.Time `db:"firstseen"`
+	LastSeen  time.Time `db:"lastseen"`
+}
+
+// Statuses of a transaction in the mempool db table
+const (
+	MempoolStatusPending  = "pending"
+	MempoolStatusIncluded = "included"
+	MempoolStatusDropped  = "dropped"
+)
+
+// TxConfirmationDepth is the number of canonical blocks built on top of the including block after which a transaction is considered confirmed
+const TxConfirmationDepth = 10
+
+// MempoolTransaction represents a row of the mempool db table, BlockStateHash is only set for included transactions
+type MempoolTransaction struct {
+	ID             string    `db:"id" json:"id"`
+	Sender         string    `db:"sender" json:"sender"`
+	Recipient      string    `db:"recipient" json:"recipient"`
+	Memo           string    `db:"memo" json:"memo"`
+	Fee            Amount    `db:"fee" json:"fee"`
+	Amount         Amount    `db:"amount" json:"amount"`
+	Nonce          int       `db:"nonce" json:"nonce"`
+	Delegation     bool      `db:"delegation" json:"delegation"`
+	Status         string    `db:"status" json:"status"`
+	BlockStateHash string    `db:"blockstatehash" json:"block_state_hash"`
+	FirstSeen      time.Time `db:"firstseen" json:"first_seen"`
+	LastSeen       time.Time `db:"lastseen" json:"last_seen"`
+}
+
+// TxLatency holds the time a canonical transaction was first seen in the mempool together with the timestamps of the
+// including block and of the block confirming it, ConfirmedTs is null as long as there are less than TxConfirmationDepth confirmations
+type TxLatency struct {
+	FirstSeen     time.Time   `db:"firstseen"`
+	IncludedTs    time.Time   `db:"includedts"`
+	Confirmations int         `db:"confirmations"`
+	ConfirmedTs   pq.NullTime `db:"confirmedts"`
 }
 
 // AccountTransaction represents a row of the accounttransactions db table
@@ -128,9 +229,31 @@ type DaemonStatus struct {
 	Uptime                     int            `db:"uptime"`
 }
 
+// Types of the change notifications sent by the db package
+const (
+	NotificationBlockSaved     = "block_saved"
+	NotificationBlockCanonical = "block_canonical"
+	NotificationBlockOrphaned  = "block_orphaned"
+	NotificationDaemonStatus   = "daemon_status"
+)
+
+// Notification is a change of the indexed data sent via postgres NOTIFY, StateHash and Height are only set for block notifications
+type Notification struct {
+	Type      string `json:"type"`
+	StateHash string `json:"state_hash,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
 // Statistic represents a row of the statistics db table
 type Statistic struct {
 	Indicator string    `db:"indicator" json:"indicator"`
 	Ts        time.Time `db:"ts" json:"ts"`
 	Value     float64   `db:"value" json:"value"`
 }
+
+// SchemaMigration represents a row of the schema_migrations db table, AppliedAt is zero for pending migrations
+type SchemaMigration struct {
+	Version     int       `db:"version"`
+	Description string    `db:"description"`
+	AppliedAt   time.Time `db:"appliedat"`
+}
@@ -0,0 +1,34 @@
+/*
+ *    Copyright 2020 bitfly gmbh
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package types
+
+// Types of the events pushed to the clients of the /stream endpoint
+const (
+	StreamEventNewBlock       = "new_block"
+	StreamEventBlockCanonical = "block_canonical"
+	StreamEventBlockOrphaned  = "block_orphaned"
+	StreamEventNewTransaction = "new_transaction"
+)
+
+// StreamEvent is a change of the indexed chain pushed to the clients of the /stream endpoint. Block is set for
+// all events (without its transactions, snark jobs and fee transfers), Transaction only for new transactions.
+type StreamEvent struct {
+	ID          uint64   `json:"id"`
+	Type        string   `json:"type"`
+	Block       *Block   `json:"block,omitempty"`
+	Transaction *UserJob `json:"transaction,omitempty"`
+}
@@ -49,7 +49,7 @@ type IndexPageData struct {
 	CurrentHeight    int      `json:"current_height"`
 	ActiveValidators int      `json:"active_validators"`
 	ActiveWorkers    int      `json:"active_workers"`
-	TotalSupply      int      `json:"total_supply"`
+	TotalSupply      Amount   `json:"total_supply"`
 	Peers            int      `json:"peers"`
 	Blocks           []*Block `json:"blocks"`
 }
@@ -64,21 +64,38 @@ type DataTableResponse struct {
 
 // TxPageData is a struct to hold data for transaction page & the transactions table on the account page
 type TxPageData struct {
-	BlockStateHash string    `db:"blockstatehash"`
-	Canonical      bool      `db:"canonical"`
-	Index          int       `db:"index"`
-	ID             string    `db:"id"`
-	Sender         string    `db:"sender"`
-	Recipient      string    `db:"recipient"`
-	Memo           string    `db:"memo"`
-	Fee            int       `db:"fee"`
-	Amount         int       `db:"amount"`
-	Nonce          int       `db:"nonce"`
-	Delegation     bool      `db:"delegation"`
-	Ts             time.Time `db:"ts"`
-	Slot           int       `db:"slot"`
-	Height         int       `db:"height"`
-	Epoch          int       `db:"epoch"`
+	BlockStateHash string    `db:"blockstatehash" json:"block_state_hash"`
+	Canonical      bool      `db:"canonical" json:"canonical"`
+	Index          int       `db:"index" json:"index"`
+	ID             string    `db:"id" json:"id"`
+	Sender         string    `db:"sender" json:"sender"`
+	Recipient      string    `db:"recipient" json:"recipient"`
+	Memo           string    `db:"memo" json:"memo"`
+	Fee            Amount    `db:"fee" json:"fee"`
+	Amount         Amount    `db:"amount" json:"amount"`
+	Nonce          int       `db:"nonce" json:"nonce"`
+	Delegation     bool      `db:"delegation" json:"delegation"`
+	Ts             time.Time `db:"ts" json:"ts"`
+	Slot           int       `db:"slot" json:"slot"`
+	Height         int       `db:"height" json:"height"`
+	Epoch          int       `db:"epoch" json:"epoch"`
+
+	// MempoolStatus is set for transactions that have not been included in a canonical block (yet), MempoolFirstSeen for
+	// all transactions that have been seen in the mempool
+	MempoolStatus    string    `db:"-" json:"-"`
+	MempoolFirstSeen time.Time `db:"-" json:"-"`
+
+	// Set for canonical transactions that have been seen in the mempool before their inclusion
+	InclusionDelay    time.Duration `db:"-" json:"-"`
+	ConfirmationDelay time.Duration `db:"-" json:"-"`
+	Confirmations     int           `db:"-" json:"-"`
+	ConfirmationDepth int           `db:"-" json:"-"`
+}
+
+// MempoolPageData is a struct to hold data for the mempool page
+type MempoolPageData struct {
+	Pending []*MempoolTransaction
+	Dropped []*MempoolTransaction
 }
 
 // SnarkJobPageData is a struct to hold data for the snarkjob table on the accounts page
@@ -88,7 +105,7 @@ type SnarkJobPageData struct {
 	Index          int           `db:"index"`
 	Jobids         pq.Int64Array `db:"jobids"`
 	Prover         string        `db:"prover"`
-	Fee            int           `db:"fee"`
+	Fee            Amount        `db:"fee"`
 	Ts             time.Time     `db:"ts"`
 	Slot           int           `db:"slot"`
 	Height         int           `db:"height"`
@@ -108,7 +125,7 @@ type PeerInfoPageData struct {
 // AccountPageData is a struct to hold data for the  accounts page
 type AccountPageData struct {
 	PublicKey        string    `db:"publickey"`
-	Balance          int       `db:"balance"`
+	Balance          Amount    `db:"balance"`
 	Nonce            int       `db:"nonce"`
 	ReceiptChainHash string    `db:"receiptchainhash"`
 	Delegate         string    `db:"delegate"`
@@ -125,5 +142,5 @@ type AccountPageData struct {
 
 type AccountDelegations struct {
 	PublicKey string `db:"publickey"`
-	Balance   int    `db:"balance"`
+	Balance   Amount `db:"balance"`
 }
@@ -17,41 +17,32 @@
 package util
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"os"
 	"os/signal"
-	"strconv"
-	"time"
-)
-
-// MustParseInt must parse an int
-func MustParseInt(str string) int {
-	res, err := strconv.Atoi(str)
-
-	if err != nil {
-		panic(fmt.Sprintf("error parsing string %v: %v", str, err))
-	}
+	"syscall"
 
-	return res
-}
+	"github.com/sirupsen/logrus"
+)
 
-// MustParseJsTimestamp must parse a timestamp in js timestamp format
-func MustParseJsTimestamp(str string) time.Time {
-	res, err := strconv.ParseInt(str, 10, 64)
+var logger = logrus.New().WithField("module", "util")
 
-	if err != nil {
-		log.Fatalf("error parsing string %v: %v", str, err)
-	}
+// ShutdownContext returns a context that is cancelled once an interrupt or a SIGTERM is received. A second signal
+// terminates the process immediately.
+func ShutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	ts := time.Unix(res/1000, 0)
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		logger.Infof("received %v, shutting down", sig)
+		cancel()
 
-	return ts
-}
+		sig = <-c
+		logger.Warnf("received %v during shutdown, exiting immediately", sig)
+		os.Exit(1)
+	}()
 
-// WaitForCtrlC will block/wait until a control-c is pressed
-func WaitForCtrlC() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	return ctx
 }